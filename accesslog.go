@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// defaultAccessLogExclude keeps probe and scrape traffic out of the access log
+var defaultAccessLogExclude = []string{"/healthz", "/readyz", "/metrics"}
+
+// redactedHeaders are never written to the access log, nor are the api_key
+// headers of any service
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	defaultAPIKeyHeader:   true,
+}
+
+// LoggingConfig controls the access log written for every request
+type LoggingConfig struct {
+	Level        string   `yaml:"level"`
+	Headers      bool     `yaml:"headers"`
+	ExcludePaths []string `yaml:"exclude_paths"`
+}
+
+func (c *LoggingConfig) level() (slog.Level, error) {
+	var l slog.Level
+	if c == nil || c.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(c.Level)); err != nil {
+		return 0, fmt.Errorf("logging: invalid level %q", c.Level)
+	}
+	return l, nil
+}
+
+// excluded reports whether requests for path are left out of the access log;
+// exclude_paths replaces the defaults, so an empty list logs everything
+func (c *LoggingConfig) excluded(path string) bool {
+	exclude := defaultAccessLogExclude
+	if c != nil && c.ExcludePaths != nil {
+		exclude = c.ExcludePaths
+	}
+	for _, p := range exclude {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *LoggingConfig) validate() error {
+	_, err := c.level()
+	return err
+}
+
+type accessLogKey struct{}
+
+// accessLogEntry collects what inner handlers learn about a request, such as
+// the matched service, the upstream chosen and the authenticated subject
+type accessLogEntry struct {
+	service  string
+	upstream string
+	subject  string
+}
+
+// accessLog writes one structured entry per request once it has been served.
+// The headers in redact are logged as [redacted] like redactedHeaders.
+func accessLog(c *LoggingConfig, redact []string) func(http.Handler) http.Handler {
+	level, _ := c.level()
+	logHeaders := c != nil && c.Headers
+	redacted := make(map[string]bool, len(redactedHeaders)+len(redact))
+	for name := range redactedHeaders {
+		redacted[name] = true
+	}
+	for _, name := range redact {
+		redacted[http.CanonicalHeaderKey(name)] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.excluded(r.URL.Path) || !logger.Enabled(r.Context(), level) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			entry := &accessLogEntry{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				slog.String("remote_addr", r.RemoteAddr),
+			}
+			if entry.service != "" {
+				attrs = append(attrs, slog.String("service", entry.service))
+			}
+			if entry.upstream != "" {
+				attrs = append(attrs, slog.String("upstream", entry.upstream))
+			}
+			if entry.subject != "" {
+				attrs = append(attrs, slog.String("sub", entry.subject))
+			}
+			if logHeaders {
+				attrs = append(attrs, headerAttr("request_headers", r.Header, redacted), headerAttr("response_headers", ww.Header(), redacted))
+			}
+			logger.LogAttrs(r.Context(), level, "access", attrs...)
+		})
+	}
+}
+
+func headerAttr(key string, h http.Header, redacted map[string]bool) slog.Attr {
+	attrs := make([]any, 0, len(h))
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		if redacted[name] {
+			value = "[redacted]"
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group(key, attrs...)
+}
+
+// logService records the matched service in the request's access log entry
+func logService(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if e, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+				e.service = name
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// logSubject records the authenticated subject in the access log entry
+func logSubject(r *http.Request, sub string) {
+	if e, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		e.subject = sub
+	}
+}
+
+// logUpstream records the upstream chosen for the request in the access log entry
+func logUpstream(r *http.Request, upstream string) {
+	if e, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		e.upstream = upstream
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// captureLogs sends log output to the returned buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := logger
+	logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() { logger = prev })
+	return &buf
+}
+
+// accessEntries returns the decoded access log entries in buf
+func accessEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if e["msg"] == "access" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "orders")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{Logging: &LoggingConfig{Level: "debug", Headers: true}},
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	buf := captureLogs(t)
+
+	req := httptest.NewRequest("POST", "/api/orders/1", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"sub": "42"}))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	entries := accessEntries(t, buf)
+	if len(entries) != 1 {
+		t.Fatalf("expected one access log entry, got %d: %s", len(entries), buf)
+	}
+	e := entries[0]
+	if e["level"] != "DEBUG" || e["method"] != "POST" || e["path"] != "/api/orders/1" ||
+		e["status"] != float64(http.StatusCreated) || e["bytes"] != float64(5) ||
+		e["service"] != "orders" || e["sub"] != "42" || e["request_id"] == "" {
+		t.Fatalf("unexpected access log entry %v", e)
+	}
+	reqHeaders := e["request_headers"].(map[string]interface{})
+	if reqHeaders["Authorization"] != "[redacted]" {
+		t.Fatalf("expected Authorization to be redacted, got %v", reqHeaders["Authorization"])
+	}
+	if e["response_headers"].(map[string]interface{})["X-Upstream"] != "orders" {
+		t.Fatalf("expected response headers in entry %v", e)
+	}
+}
+
+func TestAccessLogRedactsAPIKeyHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server: ServerConfig{Logging: &LoggingConfig{Headers: true}},
+		Services: []ServiceConfig{
+			{
+				Name: "partners", PathPrefix: "/api/partners", TargetURL: upstream.URL, AuthRequired: true, Auth: authAPIKey,
+				APIKey: &APIKeyConfig{Header: "x-partner-token", Keys: []string{"k-123"}},
+			},
+			{Name: "public", PathPrefix: "/api/public", TargetURL: upstream.URL},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	buf := captureLogs(t)
+
+	// the header is redacted on every route, not only the service's own
+	for _, path := range []string{"/api/partners/x", "/api/public/x"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Partner-Token", "k-123")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := accessEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("expected two access log entries, got %d: %s", len(entries), buf)
+	}
+	// the api_key middleware drops the header once checked, so it only shows
+	// where another service receives it
+	if got := entries[1]["request_headers"].(map[string]interface{})["X-Partner-Token"]; got != "[redacted]" {
+		t.Errorf("api key header logged as %v", got)
+	}
+	if strings.Contains(buf.String(), "k-123") {
+		t.Errorf("api key written to the log: %s", buf)
+	}
+}
+
+func TestAccessLogExcludePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		exclude []string
+		path    string
+		want    bool
+	}{
+		{"default health", nil, "/healthz", true},
+		{"default nested", nil, "/healthz/services", true},
+		{"default service", nil, "/api/orders", false},
+		{"empty list logs all", []string{}, "/healthz", false},
+		{"custom", []string{"/api/internal/"}, "/api/internal/x", true},
+		{"prefix boundary", []string{"/api/in"}, "/api/internal", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &LoggingConfig{ExcludePaths: tt.exclude}
+			if got := c.excluded(tt.path); got != tt.want {
+				t.Fatalf("excluded(%q) = %v want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggingConfigValidation(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  logging:
+    level: "loud"
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for invalid logging level")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// redactedConfigPaths are the config fields whose values /admin/config
+// never shows, as dot separated yaml paths in which * stands for any list
+// index or map key. Request header values are included since upstream
+// credentials are usually injected there.
+var redactedConfigPaths = [][]string{
+	{"jwt_secret"},
+	{"server", "admin_token"},
+	{"introspection", "client_secret"},
+	{"services", "*", "jwt_secret"},
+	{"services", "*", "api_key", "keys"},
+	{"services", "*", "api_key", "clients", "*", "key"},
+	{"services", "*", "basic_auth", "users"},
+	{"services", "*", "request_headers", "set", "*"},
+	{"services", "*", "request_headers", "add", "*"},
+}
+
+// adminAPI serves runtime state of one router build under /admin
+type adminAPI struct {
+	cfg         *Config
+	services    []adminService
+	revocations revocationStore
+}
+
+// adminService is what the admin API knows about a registered service
+type adminService struct {
+	config ServiceConfig
+	proxy  *serviceProxy // nil for static services
+}
+
+func (a *adminAPI) add(s ServiceConfig, p *serviceProxy) {
+	a.services = append(a.services, adminService{config: s, proxy: p})
+}
+
+// routes mounts the admin endpoints behind the admin token
+func (a *adminAPI) routes(token string) http.Handler {
+	r := chi.NewRouter()
+	r.Use(requireAdminToken(token))
+	r.Get("/config", a.config)
+	r.Get("/services", a.servicesHandler)
+	r.Get("/health", a.health)
+	r.Get("/requests", a.requests)
+	r.Post("/revocations", a.revoke)
+	return r
+}
+
+// requireAdminToken accepts only requests bearing the admin token, which is
+// unrelated to user JWTs
+func requireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				logger.Warn("admin request rejected", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				writeError(w, r, http.StatusUnauthorized, "invalid admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// config returns the loaded config as its yaml field names, secrets redacted
+func (a *adminAPI) config(w http.ResponseWriter, r *http.Request) {
+	raw, err := yaml.Marshal(a.cfg)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to encode config")
+		return
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to encode config")
+		return
+	}
+	writeJSON(w, http.StatusOK, redactConfig(doc, nil))
+}
+
+// redactConfig replaces the values at redactedConfigPaths in doc, which sits
+// at path in the config
+func redactConfig(doc interface{}, path []string) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = redactConfigValue(value, append(path[:len(path):len(path)], key))
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactConfigValue(v[i], append(path[:len(path):len(path)], "*"))
+		}
+	}
+	return doc
+}
+
+func redactConfigValue(value interface{}, path []string) interface{} {
+	if value == nil || value == "" || !configPathIn(path, redactedConfigPaths) {
+		return redactConfig(value, path)
+	}
+	return "[redacted]"
+}
+
+// configPathIn reports whether path matches one of paths, in which * stands
+// for any list index or map key
+func configPathIn(path []string, paths [][]string) bool {
+	for _, p := range paths {
+		if len(p) != len(path) {
+			continue
+		}
+		match := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+type adminServiceStatus struct {
+	Name           string            `json:"name"`
+	PathPrefix     string            `json:"path_prefix"`
+	Targets        []string          `json:"targets"`
+	Auth           string            `json:"auth"`
+	CircuitBreaker *breakerSnapshot  `json:"circuit_breaker,omitempty"`
+	Requests       map[string]uint64 `json:"requests"`
+}
+
+func (a *adminAPI) servicesHandler(w http.ResponseWriter, r *http.Request) {
+	counts := requestCounts()
+	out := make([]adminServiceStatus, 0, len(a.services))
+	for _, s := range a.services {
+		auth := "none"
+		if s.config.authenticates() {
+			auth = s.config.authMode()
+		}
+		status := adminServiceStatus{
+			Name:       s.config.Name,
+			PathPrefix: s.config.PathPrefix,
+			Targets:    s.config.targets(),
+			Auth:       auth,
+			Requests:   counts[s.config.Name],
+		}
+		if s.proxy != nil {
+			if snap, ok := s.proxy.breakerSnapshot(); ok {
+				status.CircuitBreaker = &snap
+			}
+		}
+		if status.Requests == nil {
+			status.Requests = map[string]uint64{}
+		}
+		out = append(out, status)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// health reports whether each upstream of each service is currently in use
+func (a *adminAPI) health(w http.ResponseWriter, r *http.Request) {
+	out := make(map[string]map[string]bool, len(a.services))
+	for _, s := range a.services {
+		// static services have no upstreams
+		if s.proxy != nil {
+			out[s.config.Name] = s.proxy.lb.healthStatus()
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// requests reports how many requests the gateway is serving right now
+func (a *adminAPI) requests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]int64{"active": activeRequests.Load()})
+}
+
+// revocationRequest revokes a token by jti or a subject's tokens by sub,
+// until expires_at or for ttl
+type revocationRequest struct {
+	JTI       string    `json:"jti"`
+	Sub       string    `json:"sub"`
+	ExpiresAt time.Time `json:"expires_at"`
+	TTL       string    `json:"ttl"`
+}
+
+// revoke adds an entry to the revocation list, which authMiddleware checks
+func (a *adminAPI) revoke(w http.ResponseWriter, r *http.Request) {
+	var req revocationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid revocation: "+err.Error())
+		return
+	}
+	now := time.Now()
+	e := revocation{JTI: req.JTI, Sub: req.Sub, RevokedAt: now, ExpiresAt: req.ExpiresAt}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || !req.ExpiresAt.IsZero() {
+			writeError(w, r, http.StatusBadRequest, "invalid revocation: ttl must be a positive duration and excludes expires_at")
+			return
+		}
+		e.ExpiresAt = now.Add(ttl)
+	}
+	if err := e.validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid revocation: "+err.Error())
+		return
+	}
+	if !e.ExpiresAt.After(now) {
+		writeError(w, r, http.StatusBadRequest, "invalid revocation: expires_at is in the past")
+		return
+	}
+	if err := a.revocations.add(r.Context(), e); err != nil {
+		logger.Error("revocation failed", "jti", e.JTI, "sub", e.Sub, "err", err)
+		writeError(w, r, http.StatusBadGateway, "failed to store revocation")
+		return
+	}
+	logger.Info("token revoked", "jti", e.JTI, "sub", e.Sub, "expires_at", e.ExpiresAt)
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// requestCounts sums gateway_requests_total per service by status class. The
+// counters are only fed while metrics are enabled.
+func requestCounts() map[string]map[string]uint64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		requestsTotal.Collect(ch)
+		close(ch)
+	}()
+	counts := make(map[string]map[string]uint64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			continue
+		}
+		var service, status string
+		for _, l := range pb.GetLabel() {
+			switch l.GetName() {
+			case "service":
+				service = l.GetValue()
+			case "status":
+				status = l.GetValue()
+			}
+		}
+		if counts[service] == nil {
+			counts[service] = make(map[string]uint64)
+		}
+		counts[service][status] += uint64(pb.GetCounter().GetValue())
+	}
+	return counts
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{AdminToken: "admin-secret"},
+		JWTSecret: "jwt-secret",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 5}},
+			{
+				Name: "partners", PathPrefix: "/api/partners", TargetURL: upstream.URL, AuthRequired: true, Auth: authAPIKey,
+				APIKey: &APIKeyConfig{Keys: []string{"partner-key"}},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/orders/x", nil))
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	for _, token := range []string{"", "wrong", signToken(t, "jwt-secret", nil)} {
+		if rw := get("/admin/services", token); rw.Code != http.StatusUnauthorized {
+			t.Fatalf("token %q: expected 401, got %d", token, rw.Code)
+		}
+	}
+
+	rw := get("/admin/config", "admin-secret")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rw.Code)
+	}
+	body := rw.Body.String()
+	for _, secret := range []string{"admin-secret", "jwt-secret", "partner-key"} {
+		if strings.Contains(body, secret) {
+			t.Fatalf("config leaks %q: %s", secret, body)
+		}
+	}
+	if !strings.Contains(body, `"path_prefix":"/api/orders"`) {
+		t.Fatalf("expected config to use yaml field names: %s", body)
+	}
+
+	var services []adminServiceStatus
+	rw = get("/admin/services", "admin-secret")
+	if err := json.NewDecoder(rw.Body).Decode(&services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 || services[0].Name != "orders" || services[1].Auth != authAPIKey {
+		t.Fatalf("unexpected services %+v", services)
+	}
+	if services[0].CircuitBreaker == nil || services[0].CircuitBreaker.State != "closed" {
+		t.Fatalf("expected closed circuit breaker, got %+v", services[0].CircuitBreaker)
+	}
+	if services[0].Requests["2xx"] == 0 {
+		t.Fatalf("expected request counters, got %v", services[0].Requests)
+	}
+
+	var health map[string]map[string]bool
+	rw = get("/admin/health", "admin-secret")
+	if err := json.NewDecoder(rw.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if !health["orders"][upstream.URL] {
+		t.Fatalf("unexpected health %v", health)
+	}
+}
+
+func TestAdminAPIDisabledWithoutToken(t *testing.T) {
+	r := mustBuildRouter(t, &Config{JWTSecret: "dummy"})
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/admin/services", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("expected admin API to be off without admin_token, got %d", rw.Code)
+	}
+}
+
+func TestAdminConfigRedaction(t *testing.T) {
+	cfg := &Config{
+		Server:        ServerConfig{AdminToken: "admin-secret"},
+		Introspection: &IntrospectionConfig{URL: "http://idp/introspect", ClientID: "gateway", ClientSecret: "idp-secret"},
+		Services: []ServiceConfig{
+			{
+				Name: "partners", PathPrefix: "/api/partners", TargetURL: "http://partners:8080", AuthRequired: true, Auth: authAPIKey,
+				APIKey:         &APIKeyConfig{Clients: []APIKeyClient{{ID: "acme", Key: "client-key"}}},
+				RateLimit:      &RateLimitConfig{RequestsPerSecond: 1, Key: "ip"},
+				RequestHeaders: &RequestHeadersConfig{Set: map[string]string{"Authorization": "Bearer upstream-token"}, Add: map[string]string{"X-Upstream-Key": "upstream-key"}},
+			},
+			{Name: "staff", PathPrefix: "/api/staff", TargetURL: "http://staff:8080", AuthRequired: true, JWTSecret: "staff-secret"},
+			{Name: "legacy", PathPrefix: "/api/legacy", TargetURL: "http://legacy:8080", AuthRequired: true, Auth: authBasic, BasicAuth: &BasicAuthConfig{Users: []string{"bob:$2a$10$hash"}}},
+		},
+	}
+	rw := httptest.NewRecorder()
+	(&adminAPI{cfg: cfg}).config(rw, httptest.NewRequest("GET", "/admin/config", nil))
+	body := rw.Body.String()
+	for _, secret := range []string{"admin-secret", "idp-secret", "client-key", "upstream-token", "upstream-key", "staff-secret", "$2a$10$hash"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("config leaks %q: %s", secret, body)
+		}
+	}
+	// harmless fields that share a name with a secret elsewhere stay visible,
+	// as do the names of injected headers
+	for _, want := range []string{`"key":"ip"`, `"id":"acme"`, `"client_id":"gateway"`, `"X-Upstream-Key":"[redacted]"`, `"Authorization":"[redacted]"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("config lacks %s: %s", want, body)
+		}
+	}
+}
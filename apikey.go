@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultAPIKeyHeader = "X-API-Key"
+	// clientIDHeader names the API key client that made the request
+	clientIDHeader = "X-Client-Id"
+	// hashedKeyPrefix marks a key stored as the hex SHA-256 of the real key
+	hashedKeyPrefix = "sha256:"
+)
+
+// APIKeyConfig authenticates requests by a static key in a header. Keys may be
+// listed inline, as named clients or, to keep them out of the config file, in
+// the comma separated env var named by keys_env. Any key may be written as
+// ${VAR} to read it from the environment, or as sha256:<hex> to store only its
+// hash. subject and roles describe the caller to role checks and upstreams.
+type APIKeyConfig struct {
+	Header  string         `yaml:"header"`
+	Keys    []string       `yaml:"keys"`
+	KeysEnv string         `yaml:"keys_env"`
+	Clients []APIKeyClient `yaml:"clients"`
+	Subject string         `yaml:"subject"`
+	Roles   []string       `yaml:"roles"`
+}
+
+// APIKeyClient is a key with a name that is forwarded upstream in X-Client-Id
+type APIKeyClient struct {
+	ID  string `yaml:"id"`
+	Key string `yaml:"key"`
+}
+
+// apiKey is an accepted key reduced to its hash
+type apiKey struct {
+	clientID string
+	hash     [sha256.Size]byte
+}
+
+func (c *APIKeyConfig) header() string {
+	if c.Header == "" {
+		return defaultAPIKeyHeader
+	}
+	return c.Header
+}
+
+// apiKeyHeaders lists the headers services take api keys from
+func (c *Config) apiKeyHeaders() []string {
+	var headers []string
+	for _, s := range c.Services {
+		if s.APIKey != nil {
+			headers = append(headers, s.APIKey.header())
+		}
+	}
+	return headers
+}
+
+// loadEnvKeys appends the keys from keys_env
+func (c *APIKeyConfig) loadEnvKeys() {
+	if c.KeysEnv == "" {
+		return
+	}
+	for _, k := range strings.Split(os.Getenv(c.KeysEnv), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			c.Keys = append(c.Keys, k)
+		}
+	}
+}
+
+func (c *APIKeyConfig) validate() error {
+	_, err := c.compile()
+	return err
+}
+
+// compile hashes every plain key so a request is always checked by comparing
+// hashes. Unnamed keys are identified by a short fingerprint of their hash.
+func (c *APIKeyConfig) compile() ([]apiKey, error) {
+	var keys []apiKey
+	add := func(id, key string) error {
+		if key == "" {
+			return errors.New("api_key: empty key")
+		}
+		k := apiKey{clientID: id}
+		if hexHash, ok := strings.CutPrefix(key, hashedKeyPrefix); ok {
+			b, err := hex.DecodeString(hexHash)
+			if err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("api_key: %s must be followed by a hex SHA-256 hash", hashedKeyPrefix)
+			}
+			copy(k.hash[:], b)
+		} else {
+			k.hash = sha256.Sum256([]byte(key))
+		}
+		if k.clientID == "" {
+			k.clientID = "key-" + hex.EncodeToString(k.hash[:4])
+		}
+		keys = append(keys, k)
+		return nil
+	}
+	for _, key := range c.Keys {
+		if err := add("", key); err != nil {
+			return nil, err
+		}
+	}
+	for _, client := range c.Clients {
+		if client.ID == "" {
+			return nil, errors.New("api_key: every client needs an id")
+		}
+		if err := add(client.ID, client.Key); err != nil {
+			return nil, fmt.Errorf("client %s: %w", client.ID, err)
+		}
+	}
+	if len(keys) == 0 {
+		if c.KeysEnv != "" {
+			return nil, fmt.Errorf("api_key: no keys configured and %s is empty", c.KeysEnv)
+		}
+		return nil, errors.New("api_key: no keys configured")
+	}
+	return keys, nil
+}
+
+// apiKeyMiddleware accepts requests carrying one of the configured keys. The
+// key is replaced by X-Client-Id before proxying and the configured subject
+// and roles are stored as claims, so role checks and injectUserInfo work as
+// with a JWT.
+func apiKeyMiddleware(c APIKeyConfig, rolesClaim string) (func(http.Handler) http.Handler, error) {
+	keys, err := c.compile()
+	if err != nil {
+		return nil, err
+	}
+	header := c.header()
+	claims := jwt.MapClaims{}
+	if c.Subject != "" {
+		claims["sub"] = c.Subject
+	}
+	if len(c.Roles) > 0 {
+		roles := make([]interface{}, len(c.Roles))
+		for i, role := range c.Roles {
+			roles[i] = role
+		}
+		setClaim(claims, rolesClaim, roles)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(header)
+			if key == "" {
+				writeError(w, r, http.StatusUnauthorized, "Missing API Key")
+				return
+			}
+			clientID, ok := matchAPIKey(keys, key)
+			if !ok {
+				logger.Warn("invalid api key", "header", header, "path", r.URL.Path)
+				writeError(w, r, http.StatusUnauthorized, "Invalid API Key")
+				return
+			}
+			r.Header.Del(header)
+			r.Header.Set(clientIDHeader, clientID)
+			ctx := context.WithValue(r.Context(), userClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, nil
+}
+
+// matchAPIKey compares the hash of key against every allowed key in constant
+// time and returns the client id of the match
+func matchAPIKey(keys []apiKey, key string) (string, bool) {
+	hash := sha256.Sum256([]byte(key))
+	var clientID string
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(k.hash[:], hash[:]) == 1 {
+			clientID = k.clientID
+		}
+	}
+	return clientID, clientID != ""
+}
+
+// setClaim stores value at a dot separated claim path, creating nested
+// objects as needed; it is the inverse of claimValue
+func setClaim(claims jwt.MapClaims, path string, value interface{}) {
+	m := map[string]interface{}(claims)
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{
+				Name: "batch", PathPrefix: "/api/batch", TargetURL: upstream.URL, AuthRequired: true,
+				Auth:          "api_key",
+				APIKey:        &APIKeyConfig{Keys: []string{"k1", "k2"}, Subject: "batch-runner", Roles: []string{"service"}},
+				RequiredRoles: []string{"service"},
+			},
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	do := func(path, key string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	if code := do("/api/batch/x", "k2"); code != http.StatusOK {
+		t.Fatalf("valid key: got %d want %d", code, http.StatusOK)
+	}
+	if got.Get("X-API-Key") != "" {
+		t.Fatal("api key was forwarded upstream")
+	}
+	if got.Get("X-User-Subject") != "batch-runner" || got.Get("X-User-Roles") != "service" {
+		t.Fatalf("unexpected identity headers: subject %q roles %q", got.Get("X-User-Subject"), got.Get("X-User-Roles"))
+	}
+	if code := do("/api/batch/x", "nope"); code != http.StatusUnauthorized {
+		t.Fatalf("invalid key: got %d want %d", code, http.StatusUnauthorized)
+	}
+	if code := do("/api/batch/x", ""); code != http.StatusUnauthorized {
+		t.Fatalf("missing key: got %d want %d", code, http.StatusUnauthorized)
+	}
+	// JWT services are unaffected by API keys
+	if code := do("/api/orders/x", "k1"); code != http.StatusUnauthorized {
+		t.Fatalf("api key on jwt service: got %d want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestLoadConfigAPIKeysFromEnv(t *testing.T) {
+	t.Setenv("TEST_BATCH_API_KEYS", "k1, k2")
+	path := writeConfig(t, `
+services:
+  - name: "batch"
+    path_prefix: "/api/batch"
+    target_url: "http://batch:8080"
+    env_var: "TEST_BATCH_SERVICE_URL"
+    auth_required: true
+    auth: api_key
+    api_key:
+      keys_env: "TEST_BATCH_API_KEYS"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := cfg.Services[0].APIKey.Keys; len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	t.Setenv("TEST_BATCH_API_KEYS", "")
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error when no api keys are configured")
+	}
+}
+
+func TestAPIKeyClients(t *testing.T) {
+	var clientID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID = r.Header.Get("X-Client-Id")
+	}))
+	defer upstream.Close()
+
+	hash := sha256.Sum256([]byte("hashed-secret"))
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{
+				Name: "reports", PathPrefix: "/api/reports", TargetURL: upstream.URL, AuthRequired: true, Auth: "api_key",
+				APIKey: &APIKeyConfig{
+					Keys: []string{"plain-secret"},
+					Clients: []APIKeyClient{
+						{ID: "billing", Key: "sha256:" + hex.EncodeToString(hash[:])},
+						{ID: "reporting", Key: "reporting-secret"},
+					},
+				},
+			},
+			{Name: "public", PathPrefix: "/api/public", TargetURL: upstream.URL},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	do := func(path, key string) int {
+		clientID = ""
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", key)
+		req.Header.Set("X-Client-Id", "forged")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	plainHash := sha256.Sum256([]byte("plain-secret"))
+	tests := []struct{ key, want string }{
+		{"hashed-secret", "billing"},
+		{"reporting-secret", "reporting"},
+		{"plain-secret", "key-" + hex.EncodeToString(plainHash[:4])},
+	}
+	for _, tt := range tests {
+		if code := do("/api/reports/x", tt.key); code != http.StatusOK {
+			t.Fatalf("key %q: got %d want %d", tt.key, code, http.StatusOK)
+		}
+		if clientID != tt.want {
+			t.Errorf("key %q: got client id %q want %q", tt.key, clientID, tt.want)
+		}
+	}
+	// the hash itself is not a valid key
+	if code := do("/api/reports/x", hex.EncodeToString(hash[:])); code != http.StatusUnauthorized {
+		t.Fatalf("hash as key: got %d want %d", code, http.StatusUnauthorized)
+	}
+	if do("/api/public/x", ""); clientID != "" {
+		t.Fatalf("forged client id reached upstream: %q", clientID)
+	}
+}
+
+func TestAPIKeyConfigInvalid(t *testing.T) {
+	for name, c := range map[string]APIKeyConfig{
+		"bad hash":     {Keys: []string{"sha256:abc"}},
+		"client no id": {Clients: []APIKeyClient{{Key: "k"}}},
+		"empty":        {},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// auth modes of auth_required services
+const (
+	authJWT           = "jwt"
+	authAPIKey        = "api_key"
+	authIntrospection = "introspection"
+	authBasic         = "basic"
+	authForward       = "forward"
+)
+
+// authMode returns the configured auth mode, defaulting to jwt
+func (s ServiceConfig) authMode() string {
+	if s.Auth == "" {
+		return authJWT
+	}
+	return s.Auth
+}
+
+// authenticates reports whether requests are checked for credentials at all
+func (s ServiceConfig) authenticates() bool {
+	return s.AuthRequired || s.AuthOptional
+}
+
+// credentialHeader is the request header the service's auth mode reads
+func (s ServiceConfig) credentialHeader() string {
+	if s.authMode() == authAPIKey && s.APIKey != nil {
+		return s.APIKey.header()
+	}
+	return "Authorization"
+}
+
+func (s ServiceConfig) validateAuth() error {
+	if s.AuthRequired && s.AuthOptional {
+		return errors.New("auth: set either auth_required or auth_optional, not both")
+	}
+	switch s.authMode() {
+	case authJWT:
+		return nil
+	case authAPIKey:
+		if !s.authenticates() {
+			return errors.New("auth: api_key needs auth_required or auth_optional")
+		}
+		if s.APIKey == nil {
+			return errors.New("auth: api_key needs an api_key block")
+		}
+		return s.APIKey.validate()
+	case authIntrospection:
+		if !s.authenticates() {
+			return errors.New("auth: introspection needs auth_required or auth_optional")
+		}
+		return nil
+	case authBasic:
+		if !s.authenticates() {
+			return errors.New("auth: basic needs auth_required or auth_optional")
+		}
+		if s.BasicAuth == nil {
+			return errors.New("auth: basic needs a basic_auth block")
+		}
+		return s.BasicAuth.validate()
+	case authForward:
+		if !s.authenticates() {
+			return errors.New("auth: forward needs auth_required or auth_optional")
+		}
+		return s.validateForwardAuth()
+	}
+	return fmt.Errorf("auth must be %q, %q, %q, %q or %q, got %q", authJWT, authAPIKey, authIntrospection, authBasic, authForward, s.Auth)
+}
+
+// optionalAuth lets requests in which sent finds no credentials through
+// anonymously and hands the rest to auth, so a bad or expired token is still
+// rejected
+func optionalAuth(sent func(*http.Request) bool, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authed := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !sent(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasOwnJWT reports whether the service overrides the top-level token
+// verification settings
+func (s ServiceConfig) hasOwnJWT() bool {
+	return s.JWTSecret != "" || s.JWKSURL != "" || s.JWTIssuer != "" || s.JWTAudience != ""
+}
+
+// validateJWT makes sure per-service token settings are only set where
+// tokens are verified
+func (s ServiceConfig) validateJWT() error {
+	if s.hasOwnJWT() && (!s.authenticates() || s.authMode() != authJWT) {
+		return errors.New("jwt_secret, jwt_jwks_url, jwt_issuer and jwt_audience need jwt auth with auth_required or auth_optional")
+	}
+	return nil
+}
+
+// jwtConfig returns c with the service's token settings applied. A service
+// that sets jwt_secret or jwt_jwks_url replaces both top-level keys, so it
+// does not accept tokens signed for other services; jwt_issuer and
+// jwt_audience fall back to the top-level values one by one.
+func (c *Config) jwtConfig(s ServiceConfig) *Config {
+	out := *c
+	if s.JWTSecret != "" || s.JWKSURL != "" {
+		out.JWTSecret, out.JWKSURL = s.JWTSecret, s.JWKSURL
+	}
+	if s.JWTIssuer != "" {
+		out.JWTIssuer = s.JWTIssuer
+	}
+	if s.JWTAudience != "" {
+		out.JWTAudience = s.JWTAudience
+	}
+	return &out
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// failedUpstreamCooldown is how long an upstream that failed a request is
+// passed over in favour of its siblings
+const failedUpstreamCooldown = 10 * time.Second
+
+// upstream is a single concrete backend of a service
+type upstream struct {
+	url     *url.URL
+	direct  func(*http.Request)
+	healthy atomic.Bool
+	// weight is the upstream's share of traffic when the balancer is weighted
+	weight int
+	// failedUntil is the unix nano time until which the upstream is avoided
+	failedUntil atomic.Int64
+}
+
+// markFailed makes the balancer avoid the upstream for failedUpstreamCooldown
+func (u *upstream) markFailed() {
+	u.failedUntil.Store(time.Now().Add(failedUpstreamCooldown).UnixNano())
+}
+
+func (u *upstream) recentlyFailed(now time.Time) bool {
+	return now.UnixNano() < u.failedUntil.Load()
+}
+
+// balancer spreads requests for a service across its upstreams round-robin
+type balancer struct {
+	// upstreams is replaced, never modified, when discovery finds new targets
+	mu        sync.RWMutex
+	upstreams []*upstream
+	counter   atomic.Uint64
+	// weighted balancers pick upstreams at random in proportion to weight
+	weighted bool
+	// probed is set once checkHealth finished its first round of probes
+	probed atomic.Bool
+	// canary is never returned by next or pick, only by canaryUpstream
+	canary *upstream
+}
+
+func newBalancer(targetURLs []string) (*balancer, error) {
+	if len(targetURLs) == 0 {
+		return nil, errors.New("no target url configured")
+	}
+	b := &balancer{}
+	for _, raw := range targetURLs {
+		up, err := newUpstream(raw)
+		if err != nil {
+			return nil, err
+		}
+		b.upstreams = append(b.upstreams, up)
+	}
+	return b, nil
+}
+
+func newUpstream(raw string) (*upstream, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target url: %w", err)
+	}
+	up := &upstream{
+		url:    u,
+		direct: httputil.NewSingleHostReverseProxy(u).Director,
+	}
+	up.healthy.Store(true)
+	return up, nil
+}
+
+// current returns the upstreams, without the canary
+func (b *balancer) current() []*upstream {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.upstreams
+}
+
+// setTargets replaces the upstreams with targetURLs. Upstreams whose url is
+// kept carry over their health, so a re-resolve doesn't reset it.
+func (b *balancer) setTargets(targetURLs []string) error {
+	old := make(map[string]*upstream)
+	for _, u := range b.current() {
+		old[u.url.String()] = u
+	}
+	ups := make([]*upstream, 0, len(targetURLs))
+	for _, raw := range targetURLs {
+		if u, ok := old[raw]; ok {
+			ups = append(ups, u)
+			continue
+		}
+		u, err := newUpstream(raw)
+		if err != nil {
+			return err
+		}
+		ups = append(ups, u)
+	}
+	b.mu.Lock()
+	b.upstreams = ups
+	b.mu.Unlock()
+	return nil
+}
+
+// all returns every upstream, the canary included, for health checking
+func (b *balancer) all() []*upstream {
+	ups := b.current()
+	if b.canary == nil {
+		return ups
+	}
+	return append(ups[:len(ups):len(ups)], b.canary)
+}
+
+// exhausted reports whether no upstream is left that is healthy and hasn't
+// recently failed
+func (b *balancer) exhausted() bool {
+	now := time.Now()
+	for _, u := range b.current() {
+		if u.healthy.Load() && !u.recentlyFailed(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// canaryUpstream returns the canary if there is one and it is usable
+func (b *balancer) canaryUpstream() *upstream {
+	if b.canary == nil || !b.canary.healthy.Load() || b.canary.recentlyFailed(time.Now()) {
+		return nil
+	}
+	return b.canary
+}
+
+// next returns the healthy upstream that should serve the next request, or
+// nil when every upstream is unhealthy. Upstreams that recently failed a
+// request are skipped unless no other healthy upstream is left.
+func (b *balancer) next() *upstream {
+	n := b.counter.Add(1) - 1
+	now := time.Now()
+	ups := b.current()
+	var fallback *upstream
+	for i := range ups {
+		u := ups[(n+uint64(i))%uint64(len(ups))]
+		if !u.healthy.Load() {
+			continue
+		}
+		if !u.recentlyFailed(now) {
+			return u
+		}
+		if fallback == nil {
+			fallback = u
+		}
+	}
+	return fallback
+}
+
+// pick returns the upstream for a request. Weighted balancers choose at
+// random in proportion to the weights; a non-empty key makes the choice
+// sticky, so requests sharing a key land on the same upstream while it is
+// available. Without weights or a key this is next.
+func (b *balancer) pick(key string) *upstream {
+	if !b.weighted && key == "" {
+		return b.next()
+	}
+	now := time.Now()
+	var available, fallback []*upstream
+	availableWeight, fallbackWeight := 0, 0
+	for _, u := range b.current() {
+		w := u.weight
+		if !b.weighted {
+			w = 1
+		}
+		if !u.healthy.Load() || w == 0 {
+			continue
+		}
+		if !u.recentlyFailed(now) {
+			available = append(available, u)
+			availableWeight += w
+		}
+		fallback = append(fallback, u)
+		fallbackWeight += w
+	}
+	if len(available) == 0 {
+		available, availableWeight = fallback, fallbackWeight
+	}
+	if len(available) == 0 {
+		return nil
+	}
+
+	var n int
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = int(h.Sum32() % uint32(availableWeight))
+	} else {
+		n = rand.Intn(availableWeight)
+	}
+	for _, u := range available {
+		w := u.weight
+		if !b.weighted {
+			w = 1
+		}
+		if n < w {
+			return u
+		}
+		n -= w
+	}
+	return available[len(available)-1]
+}
+
+// setWeights makes the balancer weighted; weights match upstreams by index
+func (b *balancer) setWeights(weights []int) {
+	for i, w := range weights {
+		b.upstreams[i].weight = w
+	}
+	b.weighted = true
+}
+
+// validateWeights checks target_weights against the service's targets
+func (s ServiceConfig) validateWeights() error {
+	if len(s.TargetWeights) == 0 {
+		return nil
+	}
+	if len(s.TargetWeights) != len(s.targets()) {
+		return fmt.Errorf("target_weights has %d entries for %d targets", len(s.TargetWeights), len(s.targets()))
+	}
+	total := 0
+	for _, w := range s.TargetWeights {
+		if w < 0 {
+			return fmt.Errorf("target_weights must not be negative, got %d", w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.New("target_weights must not all be zero")
+	}
+	return nil
+}
+
+// healthStatus reports whether each upstream, keyed by url, is healthy
+func (b *balancer) healthStatus() map[string]bool {
+	status := make(map[string]bool, len(b.current()))
+	for _, u := range b.all() {
+		status[u.url.String()] = u.healthy.Load()
+	}
+	return status
+}
+
+// ready reports whether the health checks found at least one upstream up
+func (b *balancer) ready() bool {
+	if !b.probed.Load() {
+		return false
+	}
+	for _, u := range b.current() {
+		if u.healthy.Load() {
+			return true
+		}
+	}
+	return false
+}
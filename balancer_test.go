@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoundRobinTargets(t *testing.T) {
+	var upstreams []string
+	for _, name := range []string{"a", "b"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+		defer srv.Close()
+		upstreams = append(upstreams, srv.URL)
+	}
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "multi", PathPrefix: "/api/multi", TargetURLs: upstreams},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/api/multi/x", nil)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		got = append(got, rw.Header().Get("Upstream"))
+	}
+
+	want := []string{"a", "b", "a", "b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected rotation: got %v want %v", got, want)
+		}
+	}
+}
+
+func TestLoadConfigTargetURLs(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "multi"
+    path_prefix: "/api/multi"
+    target_url: "http://a:8080"
+    target_urls: ["http://b:8080"]
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error when both target_url and target_urls are set")
+	}
+}
+
+func TestFailedUpstreamSkipped(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upstream", "live")
+	}))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "multi", PathPrefix: "/api/multi", TargetURLs: []string{deadURL, live.URL}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/multi/x", nil))
+	if rw.Code != http.StatusBadGateway {
+		t.Fatalf("expected first request to hit the dead upstream, got %d", rw.Code)
+	}
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/multi/x", nil))
+		if rw.Code != http.StatusOK || rw.Header().Get("Upstream") != "live" {
+			t.Fatalf("request %d: expected live upstream, got %d", i, rw.Code)
+		}
+	}
+}
+
+func TestAllUpstreamsFailedStillTried(t *testing.T) {
+	b, err := newBalancer([]string{"http://a:8080", "http://b:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range b.upstreams {
+		u.markFailed()
+	}
+	if b.next() == nil {
+		t.Fatal("expected a recently failed upstream when no other is left")
+	}
+	b.upstreams[0].healthy.Store(false)
+	b.upstreams[1].healthy.Store(false)
+	if b.next() != nil {
+		t.Fatal("expected no upstream when all are unhealthy")
+	}
+}
+
+func TestTargetURLsFromEnv(t *testing.T) {
+	t.Setenv("TEST_MULTI_URLS", "http://a:8080, http://b:8080")
+	path := writeConfig(t, `
+services:
+  - name: "multi"
+    path_prefix: "/api/multi"
+    target_url: "http://c:8080"
+    env_var: "TEST_MULTI_URLS"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := cfg.Services[0].targets()
+	if len(got) != 2 || got[0] != "http://a:8080" || got[1] != "http://b:8080" {
+		t.Fatalf("unexpected targets %v", got)
+	}
+}
+
+func TestWeightedPick(t *testing.T) {
+	b, err := newBalancer([]string{"http://stable:8080", "http://canary:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setWeights([]int{9, 1})
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		counts[b.pick("").url.Host]++
+	}
+	if c := counts["canary:8080"]; c < 100 || c > 300 {
+		t.Fatalf("expected about 10%% canary traffic, got %v", counts)
+	}
+
+	b.setWeights([]int{0, 1})
+	for i := 0; i < 10; i++ {
+		if u := b.pick(""); u.url.Host != "canary:8080" {
+			t.Fatalf("expected zero weight upstream to be skipped, got %s", u.url.Host)
+		}
+	}
+}
+
+func TestStickyPick(t *testing.T) {
+	b, err := newBalancer([]string{"http://stable:8080", "http://canary:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setWeights([]int{1, 1})
+
+	seen := map[string]bool{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		first := b.pick(user)
+		seen[first.url.Host] = true
+		for i := 0; i < 5; i++ {
+			if u := b.pick(user); u != first {
+				t.Fatalf("%s: expected sticky upstream %s, got %s", user, first.url.Host, u.url.Host)
+			}
+		}
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected keys to spread over both upstreams, got %v", seen)
+	}
+
+	b.upstreams[1].healthy.Store(false)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if u := b.pick(user); u.url.Host != "stable:8080" {
+			t.Fatalf("%s: expected unhealthy upstream to be skipped, got %s", user, u.url.Host)
+		}
+	}
+}
+
+func TestCanaryHeader(t *testing.T) {
+	var upstreams []string
+	for _, name := range []string{"stable", "canary"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+		defer srv.Close()
+		upstreams = append(upstreams, srv.URL)
+	}
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURLs: upstreams, TargetWeights: []int{50, 50}, CanaryHeader: "X-User-Key"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	var first string
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/api/orders/x", nil)
+		req.Header.Set("X-User-Key", "alice")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		got := rw.Header().Get("Upstream")
+		if first == "" {
+			first = got
+		}
+		if got != first {
+			t.Fatalf("request %d: expected sticky upstream %q, got %q", i, first, got)
+		}
+	}
+}
+
+func TestLoadConfigTargetWeights(t *testing.T) {
+	tests := map[string]string{
+		"count mismatch": `[90]`,
+		"negative":       `[110, -10]`,
+		"all zero":       `[0, 0]`,
+	}
+	for name, weights := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_urls: ["http://a:8080", "http://b:8080"]
+    env_var: "TEST_WEIGHTS_SERVICE_URL"
+    target_weights: `+weights+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid target_weights")
+			}
+		})
+	}
+}
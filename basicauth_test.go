@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptUser(t *testing.T, name, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name + ":" + string(hash)
+}
+
+func TestBasicAuth(t *testing.T) {
+	var gotSubject, gotAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSubject, gotAuth = r.Header.Get("X-User-Subject"), r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	users := []string{bcryptUser(t, "ci", "s3cret"), bcryptUser(t, "backup", "hunter2")}
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{
+				Name: "tools", PathPrefix: "/api/tools", TargetURL: upstream.URL, AuthRequired: true, Auth: authBasic,
+				BasicAuth: &BasicAuthConfig{Users: users, Realm: "tools", InjectUsername: true},
+			},
+			{
+				Name: "legacy", PathPrefix: "/api/legacy", TargetURL: upstream.URL, AuthRequired: true, Auth: authBasic, StripAuthorization: true,
+				BasicAuth: &BasicAuthConfig{Users: users},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name, path, user, password string
+		want                       int
+		wantSubject                string
+		wantChallenge              string
+	}{
+		{name: "valid", path: "/api/tools/x", user: "ci", password: "s3cret", want: http.StatusOK, wantSubject: "ci"},
+		{name: "wrong password", path: "/api/tools/x", user: "ci", password: "hunter2", want: http.StatusUnauthorized, wantChallenge: `Basic realm="tools", charset="UTF-8"`},
+		{name: "unknown user", path: "/api/tools/x", user: "eve", password: "s3cret", want: http.StatusUnauthorized, wantChallenge: `Basic realm="tools", charset="UTF-8"`},
+		{name: "missing", path: "/api/tools/x", want: http.StatusUnauthorized, wantChallenge: `Basic realm="tools", charset="UTF-8"`},
+		{name: "not injected", path: "/api/legacy/x", user: "backup", password: "hunter2", want: http.StatusOK},
+		{name: "default realm", path: "/api/legacy/x", want: http.StatusUnauthorized, wantChallenge: `Basic realm="api-gateway", charset="UTF-8"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSubject, gotAuth = "", ""
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+			if got := rw.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q want %q", got, tt.wantChallenge)
+			}
+			if gotSubject != tt.wantSubject {
+				t.Errorf("X-User-Subject = %q want %q", gotSubject, tt.wantSubject)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/legacy/x", nil)
+	req.SetBasicAuth("backup", "hunter2")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if gotAuth != "" {
+		t.Errorf("expected strip_authorization to drop the credentials, upstream got %q", gotAuth)
+	}
+}
+
+func TestLoadConfigInvalidBasicAuth(t *testing.T) {
+	tests := map[string]string{
+		"no block":   "auth_required: true\n    auth: basic",
+		"no users":   "auth_required: true\n    auth: basic\n    basic_auth: {users: []}",
+		"plain hash": "auth_required: true\n    auth: basic\n    basic_auth: {users: [\"ci:s3cret\"]}",
+		"no auth":    "auth: basic\n    basic_auth: {users: [\"ci:$2a$04$abcdefghijklmnopqrstuuKq6bqU2bWOWzGe3y4p2Sk3MZf2/7Zy\"]}",
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "tools"
+    path_prefix: "/api/tools"
+    target_url: "http://tools:8080"
+    `+extra+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid basic auth")
+			}
+		})
+	}
+}
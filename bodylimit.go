@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the suffixes accepted by parseByteSize, all binary
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "512", "64KB" or "10MiB"
+func parseByteSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 || n > (1<<62)/mult {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// bodyLimit resolves max_body_size or max_body_bytes, of which only one may
+// be set; zero means unset
+func bodyLimit(size string, bytes int64) (int64, error) {
+	switch {
+	case bytes < 0:
+		return 0, fmt.Errorf("max_body_bytes must not be negative, got %d", bytes)
+	case bytes > 0 && size != "":
+		return 0, errors.New("set either max_body_size or max_body_bytes, not both")
+	case size != "":
+		n, err := parseByteSize(size)
+		if err != nil {
+			return 0, fmt.Errorf("max_body_size: %w", err)
+		}
+		return n, nil
+	}
+	return bytes, nil
+}
+
+// maxBodySize returns the body limit of the service, falling back to the
+// server default; zero means unlimited
+func (s ServiceConfig) maxBodySize(server ServerConfig) (int64, error) {
+	n, err := bodyLimit(s.MaxBodySize, s.MaxBodyBytes)
+	if err != nil || n > 0 {
+		return n, err
+	}
+	if n, err = bodyLimit(server.MaxBodySize, server.MaxBodyBytes); err != nil {
+		return 0, fmt.Errorf("server: %w", err)
+	}
+	return n, nil
+}
+
+// limitBody rejects requests whose body exceeds limit bytes with 413. A
+// declared Content-Length is checked up front; bodies of unknown length, such
+// as chunked uploads, are cut off by http.MaxBytesReader while being proxied.
+func limitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				writeBodyTooLarge(w, r, limit)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// bodyTooLarge reports whether err came from a body cut off by limitBody
+func bodyTooLarge(err error) (int64, bool) {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return mbe.Limit, true
+	}
+	return 0, false
+}
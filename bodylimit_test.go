@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"512":   512,
+		"64KB":  64 << 10,
+		"10MiB": 10 << 20,
+		"1g":    1 << 30,
+		"2 M":   2 << 20,
+	}
+	for in, want := range tests {
+		got, err := parseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("%q: got %d, %v want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "-1", "0", "ten", "5TB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Server:    ServerConfig{MaxBodySize: "16"},
+		Services: []ServiceConfig{
+			{Name: "small", PathPrefix: "/api/small", TargetURL: upstream.URL},
+			{Name: "uploads", PathPrefix: "/api/uploads", TargetURL: upstream.URL, MaxBodySize: "1KB"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	do := func(path string, body io.Reader) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("POST", path, body))
+		return rw
+	}
+	// hides the length so the request is sent like a chunked upload
+	unsized := func(s string) io.Reader { return io.MultiReader(strings.NewReader(s)) }
+
+	tests := []struct {
+		name string
+		path string
+		body io.Reader
+		want int
+	}{
+		{"within default", "/api/small/x", strings.NewReader("tiny"), http.StatusOK},
+		{"declared over default", "/api/small/x", strings.NewReader(strings.Repeat("a", 17)), http.StatusRequestEntityTooLarge},
+		{"chunked over default", "/api/small/x", unsized(strings.Repeat("a", 17)), http.StatusRequestEntityTooLarge},
+		{"chunked within override", "/api/uploads/x", unsized(strings.Repeat("a", 1024)), http.StatusOK},
+		{"chunked over override", "/api/uploads/x", unsized(strings.Repeat("a", 1025)), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		rw := do(tt.path, tt.body)
+		if rw.Code != tt.want {
+			t.Errorf("%s: got %d want %d", tt.name, rw.Code, tt.want)
+			continue
+		}
+		if tt.want == http.StatusRequestEntityTooLarge && rw.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected JSON error, got %q", tt.name, rw.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestMaxBodyBytesStreaming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, n)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Server:    ServerConfig{MaxBodyBytes: 1 << 20},
+		Services:  []ServiceConfig{{Name: "uploads", PathPrefix: "/api/uploads", TargetURL: upstream.URL}},
+	}
+	gw := httptest.NewServer(mustBuildRouter(t, cfg))
+	defer gw.Close()
+
+	// stream the upload in chunks through a real connection
+	upload := func(size int) *http.Response {
+		pr, pw := io.Pipe()
+		go func() {
+			chunk := []byte(strings.Repeat("a", 4096))
+			for left := size; left > 0; left -= len(chunk) {
+				if left < len(chunk) {
+					chunk = chunk[:left]
+				}
+				if _, err := pw.Write(chunk); err != nil {
+					return
+				}
+			}
+			pw.Close()
+		}()
+		resp, err := http.Post(gw.URL+"/api/uploads/x", "application/octet-stream", pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := upload(1 << 20)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "1048576" {
+		t.Fatalf("upload at the limit: got %d %q", resp.StatusCode, body)
+	}
+	if resp := upload(1<<20 + 1); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("upload over the limit: got %d want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestLoadConfigBodyLimitConflict(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "uploads"
+    path_prefix: "/api/uploads"
+    target_url: "http://uploads:8080"
+    env_var: "TEST_UPLOADS_SERVICE_URL"
+    max_body_size: "1MB"
+    max_body_bytes: 1048576
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error when both max_body_size and max_body_bytes are set")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerCooldown    = 30 * time.Second
+	defaultBreakerMinRequests = 10
+)
+
+// CircuitBreakerConfig opens the breaker after consecutive_failures failed
+// requests in a row within window, or when more than error_rate of at least
+// min_requests requests within window failed. Either threshold may be used
+// alone.
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int     `yaml:"consecutive_failures"`
+	ErrorRate           float64 `yaml:"error_rate"`
+	MinRequests         int     `yaml:"min_requests"`
+	Window              string  `yaml:"window"`
+	Cooldown            string  `yaml:"cooldown"`
+}
+
+func (c *CircuitBreakerConfig) durations() (window, cooldown time.Duration, err error) {
+	window, cooldown = defaultBreakerWindow, defaultBreakerCooldown
+	if c.Window != "" {
+		if window, err = time.ParseDuration(c.Window); err != nil || window <= 0 {
+			return 0, 0, fmt.Errorf("circuit_breaker: invalid window %q", c.Window)
+		}
+	}
+	if c.Cooldown != "" {
+		if cooldown, err = time.ParseDuration(c.Cooldown); err != nil || cooldown <= 0 {
+			return 0, 0, fmt.Errorf("circuit_breaker: invalid cooldown %q", c.Cooldown)
+		}
+	}
+	return window, cooldown, nil
+}
+
+func (c *CircuitBreakerConfig) validate() error {
+	if c.ConsecutiveFailures <= 0 && c.ErrorRate <= 0 {
+		return errors.New("circuit_breaker: set consecutive_failures or error_rate")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("circuit_breaker: error_rate must be between 0 and 1, got %v", c.ErrorRate)
+	}
+	if c.MinRequests < 0 {
+		return fmt.Errorf("circuit_breaker: min_requests must not be negative, got %d", c.MinRequests)
+	}
+	_, _, err := c.durations()
+	return err
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+var breakerStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gateway_circuit_breaker_state",
+	Help: "Circuit breaker state per service: 0 closed, 1 half-open, 2 open.",
+}, []string{"service"})
+
+func init() {
+	prometheus.MustRegister(breakerStateGauge)
+}
+
+// circuitBreaker short-circuits requests to a failing service. While open
+// every request is rejected; after the cooldown a single probe request is let
+// through (half-open) and its outcome closes or re-opens the breaker.
+type circuitBreaker struct {
+	service     string
+	consecutive int
+	errorRate   float64
+	minRequests int
+	window      time.Duration
+	cooldown    time.Duration
+	now         func() time.Time
+
+	mu          sync.Mutex
+	state       breakerState
+	failStreak  int
+	lastFailure time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// breakerSnapshot is a point-in-time view of a breaker for reporting
+type breakerSnapshot struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	WindowRequests      int        `json:"window_requests"`
+	WindowFailures      int        `json:"window_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+func newCircuitBreaker(service string, c CircuitBreakerConfig) (*circuitBreaker, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	window, cooldown, _ := c.durations()
+	minRequests := c.MinRequests
+	if minRequests == 0 {
+		minRequests = defaultBreakerMinRequests
+	}
+	b := &circuitBreaker{
+		service:     service,
+		consecutive: c.ConsecutiveFailures,
+		errorRate:   c.ErrorRate,
+		minRequests: minRequests,
+		window:      window,
+		cooldown:    cooldown,
+		now:         time.Now,
+	}
+	breakerStateGauge.WithLabelValues(service).Set(float64(breakerClosed))
+	return b, nil
+}
+
+// allow reports whether a request may proceed. When it may not, it returns
+// how long until the breaker will admit a probe.
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		remaining := b.cooldown - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			return false, remaining
+		}
+		b.transition(breakerHalfOpen)
+		b.probing = true
+		return true, 0
+	case breakerHalfOpen:
+		if b.probing {
+			return false, time.Second
+		}
+		b.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// record feeds the outcome of an admitted request into the breaker
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if success {
+			b.transition(breakerClosed)
+		} else {
+			b.trip()
+		}
+		return
+	}
+	if b.state == breakerOpen {
+		return
+	}
+
+	now := b.now()
+	if now.Sub(b.windowStart) > b.window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if success {
+		b.failStreak = 0
+		return
+	}
+	b.failures++
+	// a streak only counts failures that follow each other within the window
+	if now.Sub(b.lastFailure) > b.window {
+		b.failStreak = 0
+	}
+	b.failStreak++
+	b.lastFailure = now
+
+	if b.consecutive > 0 && b.failStreak >= b.consecutive {
+		b.trip()
+		return
+	}
+	if b.errorRate > 0 && b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) > b.errorRate {
+		b.trip()
+	}
+}
+
+// release gives up an admitted request without an outcome, e.g. when the
+// client went away before the upstream answered
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) snapshot() breakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snap := breakerSnapshot{
+		State:               b.state.String(),
+		ConsecutiveFailures: b.failStreak,
+		WindowRequests:      b.requests,
+		WindowFailures:      b.failures,
+	}
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		snap.OpenedAt = &openedAt
+	}
+	return snap
+}
+
+// trip opens the breaker; b.mu must be held
+func (b *circuitBreaker) trip() {
+	b.openedAt = b.now()
+	b.failStreak, b.requests, b.failures = 0, 0, 0
+	b.transition(breakerOpen)
+}
+
+// transition must be called with b.mu held
+func (b *circuitBreaker) transition(to breakerState) {
+	if b.state == to {
+		return
+	}
+	logger.Warn("circuit breaker state change", "service", b.service, "from", b.state.String(), "to", to.String())
+	b.state = to
+	breakerStateGauge.WithLabelValues(b.service).Set(float64(to))
+}
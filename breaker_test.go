@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	b, err := newCircuitBreaker("svc", CircuitBreakerConfig{ConsecutiveFailures: 2, Cooldown: "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	if b.State() != breakerClosed {
+		t.Fatalf("opened before threshold: %v", b.State())
+	}
+	b.record(false)
+	if b.State() != breakerOpen {
+		t.Fatalf("expected open after consecutive failures, got %v", b.State())
+	}
+	if ok, wait := b.allow(); ok || wait != 10*time.Second {
+		t.Fatalf("open breaker admitted request (ok=%v wait=%v)", ok, wait)
+	}
+
+	now = now.Add(10 * time.Second)
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("expected a probe after cooldown")
+	}
+	if b.State() != breakerHalfOpen {
+		t.Fatalf("expected half-open, got %v", b.State())
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("only one probe may be in flight while half-open")
+	}
+
+	b.record(false)
+	if b.State() != breakerOpen {
+		t.Fatalf("failed probe must re-open, got %v", b.State())
+	}
+
+	now = now.Add(10 * time.Second)
+	b.allow()
+	b.record(true)
+	if b.State() != breakerClosed {
+		t.Fatalf("successful probe must close, got %v", b.State())
+	}
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b, err := newCircuitBreaker("svc", CircuitBreakerConfig{ErrorRate: 0.5, MinRequests: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ok := range []bool{true, false, true, false} {
+		b.record(ok)
+	}
+	if b.State() != breakerClosed {
+		t.Fatalf("50%% failures must not exceed a 0.5 error rate, got %v", b.State())
+	}
+	b.record(false)
+	if b.State() != breakerOpen {
+		t.Fatalf("expected open once error rate is exceeded, got %v", b.State())
+	}
+}
+
+func TestCircuitBreakerShortCircuits(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 2, Cooldown: "1m"}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		last = httptest.NewRecorder()
+		r.ServeHTTP(last, httptest.NewRequest("GET", "/api/orders/1", nil))
+	}
+
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Fatalf("open breaker still forwarded requests: %d upstream hits", got)
+	}
+	if got, want := last.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("unexpected status: got %d want %d", got, want)
+	}
+	if got := last.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("unexpected Retry-After: %q", got)
+	}
+}
+
+func TestCircuitBreakerStreakWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	b, err := newCircuitBreaker("svc", CircuitBreakerConfig{ConsecutiveFailures: 2, Window: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	now = now.Add(2 * time.Second)
+	b.record(false)
+	if b.State() != breakerClosed {
+		t.Fatalf("failures further apart than the window must not trip, got %v", b.State())
+	}
+	if snap := b.snapshot(); snap.State != "closed" || snap.ConsecutiveFailures != 1 || snap.OpenedAt != nil {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	b.record(false)
+	if snap := b.snapshot(); snap.State != "open" || snap.OpenedAt == nil {
+		t.Fatalf("unexpected snapshot after trip: %+v", snap)
+	}
+}
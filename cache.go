@@ -0,0 +1,335 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheMaxSize = 64 << 20
+	cacheHeader         = "X-Cache"
+)
+
+// CacheConfig caches successful GET responses of a service in memory
+type CacheConfig struct {
+	TTL     string `yaml:"ttl"`
+	MaxSize string `yaml:"max_size"`
+	Private bool   `yaml:"private"`
+}
+
+func (c *CacheConfig) ttl() (time.Duration, error) {
+	if c.TTL == "" {
+		return 0, errors.New("cache: ttl must be set")
+	}
+	d, err := time.ParseDuration(c.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("cache: invalid ttl %q: %w", c.TTL, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("cache: ttl must be positive, got %q", c.TTL)
+	}
+	return d, nil
+}
+
+func (c *CacheConfig) maxSize() (int64, error) {
+	if c.MaxSize == "" {
+		return defaultCacheMaxSize, nil
+	}
+	n, err := parseByteSize(c.MaxSize)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("cache: invalid max_size %q", c.MaxSize)
+	}
+	return n, nil
+}
+
+func (c *CacheConfig) validate() error {
+	if _, err := c.ttl(); err != nil {
+		return err
+	}
+	_, err := c.maxSize()
+	return err
+}
+
+// responseCache is an LRU of responses bounded by the total size of their
+// bodies. Entries expire ttl after they were stored.
+type responseCache struct {
+	service string
+	ttl     time.Duration
+	maxSize int64
+	private bool
+	now     func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cachedResponse, most recently used first
+	entries map[string]*list.Element
+	// vary remembers the Vary header names last seen for a URL, which are
+	// needed to build the key before the response is known
+	vary map[string][]string
+}
+
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResponseCache(service string, c CacheConfig) (*responseCache, error) {
+	ttl, err := c.ttl()
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := c.maxSize()
+	if err != nil {
+		return nil, err
+	}
+	return &responseCache{
+		service: service,
+		ttl:     ttl,
+		maxSize: maxSize,
+		private: c.Private,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		vary:    make(map[string][]string),
+	}, nil
+}
+
+// baseKey identifies the resource; private caches keep users apart
+func (c *responseCache) baseKey(r *http.Request) string {
+	key := r.Method + " " + r.Host + r.URL.RequestURI()
+	if c.private {
+		key += " sub=" + tokenSubject(r)
+	}
+	return key
+}
+
+func (c *responseCache) key(r *http.Request) string {
+	base := c.baseKey(r)
+	c.mu.Lock()
+	names := c.vary[base]
+	c.mu.Unlock()
+	return varyKey(base, names, r.Header)
+}
+
+func varyKey(base string, names []string, h http.Header) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range names {
+		b.WriteString("\n" + name + ":" + strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cachedResponse)
+	if !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return entry
+}
+
+func (c *responseCache) put(r *http.Request, status int, header http.Header, body []byte) {
+	names := varyNames(header)
+	base := c.baseKey(r)
+	now := c.now()
+	entry := &cachedResponse{
+		key:     varyKey(base, names, r.Header),
+		status:  status,
+		header:  header,
+		body:    body,
+		stored:  now,
+		expires: now.Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vary[base] = names
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += int64(len(body))
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry; c.mu must be held
+func (c *responseCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cachedResponse)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body))
+}
+
+// varyNames returns the canonical, sorted header names a response varies on
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cacheable reports whether a response may be stored. Responses setting
+// cookies, varying on everything or marked private or no-store never are.
+func (c *responseCache) cacheable(status int, h http.Header) bool {
+	if status != http.StatusOK || h.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, name := range varyNames(h) {
+		if name == "*" {
+			return false
+		}
+	}
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-store", "no-cache":
+			return false
+		case "private":
+			if !c.private {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cacheResponses answers repeated GET requests from the cache until their
+// entry expires. Requests carrying credentials bypass it unless the cache is
+// private, in which case entries are per token subject.
+func cacheResponses(c *responseCache) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, verified := requestTokenSource(r)
+			credentials := r.Header.Get("Authorization") != "" || verified
+			if r.Method != http.MethodGet || credentials && !c.private {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := c.key(r)
+			if entry := c.get(key); entry != nil {
+				cacheRequestsTotal.WithLabelValues(c.service, "hit").Inc()
+				// headers outer middleware set for this request, such as its
+				// rate limit, win over the stored ones
+				h := w.Header()
+				for name, values := range entry.header {
+					if _, ok := h[name]; !ok {
+						h[name] = append([]string(nil), values...)
+					}
+				}
+				h.Set(cacheHeader, "HIT")
+				h.Set("Age", strconv.Itoa(int(c.now().Sub(entry.stored).Seconds())))
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+				return
+			}
+			cacheRequestsTotal.WithLabelValues(c.service, "miss").Inc()
+			w.Header().Set(cacheHeader, "MISS")
+			rec := &cacheRecorder{ResponseWriter: w, limit: c.maxSize, before: w.Header().Clone()}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 || rec.overflow || !c.cacheable(rec.status, rec.header) {
+				return
+			}
+			c.put(r, rec.status, rec.header, rec.body)
+		})
+	}
+}
+
+// cacheRecorder passes the response through while keeping a copy of up to
+// limit bytes; larger responses are not cached. Only headers set after
+// before was taken are copied, since those outer middleware set earlier,
+// like the request id or rate limit, belong to this one request.
+type cacheRecorder struct {
+	http.ResponseWriter
+	limit    int64
+	before   http.Header
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = addedHeaders(rec.before, rec.Header())
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// addedHeaders returns what after holds beyond before: headers that are new
+// or had values appended, with only the appended values, and headers that
+// were replaced
+func addedHeaders(before, after http.Header) http.Header {
+	added := make(http.Header)
+	for name, values := range after {
+		prev := before[name]
+		switch {
+		case len(values) > len(prev) && equalValues(values[:len(prev)], prev):
+			added[name] = append([]string(nil), values[len(prev):]...)
+		case !equalValues(values, prev):
+			added[name] = append([]string(nil), values...)
+		}
+	}
+	return added
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if int64(len(rec.body)+len(p)) > rec.limit {
+			rec.overflow, rec.body = true, nil
+		} else {
+			rec.body = append(rec.body, p...)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *cacheRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/api/catalog/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/api/catalog/lang":
+			w.Header().Set("Vary", "Accept-Language")
+		case "/api/catalog/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprintf(w, "%d %s", n, r.Header.Get("Accept-Language"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "catalog", PathPrefix: "/api/catalog", TargetURL: upstream.URL, Cache: &CacheConfig{TTL: "1m"}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	do := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	first := do("GET", "/api/catalog/items", nil)
+	second := do("GET", "/api/catalog/items", nil)
+	if first.Header().Get(cacheHeader) != "MISS" || second.Header().Get(cacheHeader) != "HIT" {
+		t.Fatalf("expected MISS then HIT, got %q %q", first.Header().Get(cacheHeader), second.Header().Get(cacheHeader))
+	}
+	if first.Header().Get("X-Request-ID") == second.Header().Get("X-Request-ID") {
+		t.Fatal("expected a cache hit to carry its own request id")
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Fatalf("cached body differs: %q vs %q", first.Body.String(), second.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		want   int32
+	}{
+		{"other query", "GET", "/api/catalog/items?page=2", nil, 1},
+		{"post", "POST", "/api/catalog/items", nil, 2},
+		{"authorization", "GET", "/api/catalog/items", http.Header{"Authorization": {"Bearer x"}}, 2},
+		{"no-store", "GET", "/api/catalog/nostore", nil, 2},
+		{"not found", "GET", "/api/catalog/missing", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls.Load()
+			do(tt.method, tt.path, tt.header)
+			do(tt.method, tt.path, tt.header)
+			if got := calls.Load() - before; got != tt.want {
+				t.Fatalf("unexpected upstream calls: got %d want %d", got, tt.want)
+			}
+		})
+	}
+
+	en := http.Header{"Accept-Language": {"en"}}
+	de := http.Header{"Accept-Language": {"de"}}
+	do("GET", "/api/catalog/lang", en)
+	do("GET", "/api/catalog/lang", de)
+	if got := do("GET", "/api/catalog/lang", en); got.Header().Get(cacheHeader) != "HIT" || got.Body.String() != fmt.Sprintf("%d en", calls.Load()-1) {
+		t.Fatalf("expected cached en variant, got %q %q", got.Header().Get(cacheHeader), got.Body.String())
+	}
+	if got := do("GET", "/api/catalog/lang", de); got.Header().Get(cacheHeader) != "HIT" || got.Body.String() != fmt.Sprintf("%d de", calls.Load()) {
+		t.Fatalf("expected cached de variant, got %q %q", got.Header().Get(cacheHeader), got.Body.String())
+	}
+}
+
+func TestPrivateResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, r.Header.Get("X-User-Id"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "profile", PathPrefix: "/api/profile", TargetURL: upstream.URL, AuthRequired: true, Cache: &CacheConfig{TTL: "1m", Private: true}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	get := func(sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/profile/me", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"sub": sub}))
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	for i := 0; i < 2; i++ {
+		for _, sub := range []string{"alice", "bob"} {
+			if rw := get(sub); rw.Body.String() != sub {
+				t.Fatalf("%s got %q", sub, rw.Body.String())
+			}
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected one upstream call per user, got %d", got)
+	}
+}
+
+func TestResponseCacheExpiryAndEviction(t *testing.T) {
+	c, err := newResponseCache("svc", CacheConfig{TTL: "10s", MaxSize: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	req := func(path string) *http.Request { return httptest.NewRequest("GET", path, nil) }
+
+	c.put(req("/a"), http.StatusOK, http.Header{}, []byte("12345"))
+	c.put(req("/b"), http.StatusOK, http.Header{}, []byte("12345"))
+	if c.get(c.key(req("/a"))) == nil {
+		t.Fatal("expected /a to be cached")
+	}
+	c.put(req("/c"), http.StatusOK, http.Header{}, []byte("12345"))
+	if c.get(c.key(req("/b"))) != nil {
+		t.Fatal("expected least recently used /b to be evicted")
+	}
+	if c.get(c.key(req("/a"))) == nil || c.get(c.key(req("/c"))) == nil {
+		t.Fatal("expected /a and /c to stay cached")
+	}
+
+	now = now.Add(10 * time.Second)
+	if c.get(c.key(req("/a"))) != nil {
+		t.Fatal("expected /a to expire")
+	}
+	if c.size != 5 {
+		t.Fatalf("unexpected cache size %d", c.size)
+	}
+}
+
+func TestLoadConfigInvalidCache(t *testing.T) {
+	for _, cache := range []string{`{max_size: "1MB"}`, `{ttl: "soon"}`, `{ttl: "1m", max_size: "lots"}`} {
+		path := writeConfig(t, `
+services:
+  - name: "catalog"
+    path_prefix: "/api/catalog"
+    target_url: "http://catalog:8080"
+    env_var: "TEST_CACHE_SERVICE_URL"
+    cache: `+cache+`
+`)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("cache %s: expected error", cache)
+		}
+	}
+}
+
+func TestResponseCacheKeepsPerRequestHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "catalog")
+		w.Write([]byte("items"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Services: []ServiceConfig{{
+			Name: "catalog", PathPrefix: "/api/catalog", TargetURL: upstream.URL,
+			Cache:     &CacheConfig{TTL: "1m"},
+			RateLimit: &RateLimitConfig{RequestsPerSecond: 1, Burst: 10},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+	do := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// the entry is stored from a client that already used part of its quota
+	do("/api/catalog/other", "10.0.0.1:1000")
+	if got := do("/api/catalog/items", "10.0.0.1:1000").Header().Get("X-RateLimit-Remaining"); got != "8" {
+		t.Fatalf("first client: X-RateLimit-Remaining = %q want 8", got)
+	}
+	// another client gets the cached body with its own rate limit headers
+	rw := do("/api/catalog/items", "10.0.0.2:1000")
+	if rw.Header().Get(cacheHeader) != "HIT" {
+		t.Fatalf("expected a cache hit, got %q", rw.Header().Get(cacheHeader))
+	}
+	if got := rw.Header().Values("X-RateLimit-Remaining"); len(got) != 1 || got[0] != "9" {
+		t.Errorf("X-RateLimit-Remaining = %v want [9]", got)
+	}
+	if got := rw.Header().Values("X-Request-ID"); len(got) != 1 {
+		t.Errorf("X-Request-ID = %v, want the request's own id only", got)
+	}
+	if rw.Header().Get("X-Upstream") != "catalog" || rw.Body.String() != "items" {
+		t.Errorf("cached response lost upstream data: %v %q", rw.Header(), rw.Body.String())
+	}
+}
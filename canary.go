@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// backendHeader tells clients whether the stable or the canary backend answered
+const backendHeader = "X-Gateway-Backend"
+
+const (
+	backendStable = "stable"
+	backendCanary = "canary"
+)
+
+// CanaryConfig sends weight percent of a service's traffic to target_url
+type CanaryConfig struct {
+	TargetURL string `yaml:"target_url"`
+	Weight    int    `yaml:"weight"`
+}
+
+func (c *CanaryConfig) validate() error {
+	if c.TargetURL == "" {
+		return errors.New("canary: target_url must be set")
+	}
+	if _, err := url.Parse(c.TargetURL); err != nil {
+		return fmt.Errorf("canary: invalid target_url: %w", err)
+	}
+	if c.Weight < 0 || c.Weight > 100 {
+		return fmt.Errorf("canary: weight must be between 0 and 100, got %d", c.Weight)
+	}
+	return nil
+}
+
+// canaryKey identifies who a request comes from so they keep seeing the same
+// backend: the canary_header value, else the token subject, else the request
+// id, which only keeps a single request's retries together
+func canaryKey(r *http.Request, header string) string {
+	if header != "" {
+		if v := r.Header.Get(header); v != "" {
+			return v
+		}
+	}
+	if sub := tokenSubject(r); sub != "" {
+		return sub
+	}
+	return middleware.GetReqID(r.Context())
+}
+
+// inCanary buckets key into one of 100 slots and reports whether it falls in
+// the first weight of them
+func inCanary(key string, weight int) bool {
+	h := fnv.New32a()
+	h.Write([]byte("canary:" + key))
+	return int(h.Sum32()%100) < weight
+}
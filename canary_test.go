@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestCanaryRouting(t *testing.T) {
+	backends := map[string]string{}
+	for _, name := range []string{"stable", "canary"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+		defer srv.Close()
+		backends[name] = srv.URL
+	}
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{
+				Name: "orders", PathPrefix: "/api/orders", TargetURL: backends["stable"], AuthRequired: true,
+				Canary: &CanaryConfig{TargetURL: backends["canary"], Weight: 50},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		token := signToken(t, "secret", jwt.MapClaims{"sub": fmt.Sprintf("user-%d", i)})
+		var first string
+		for j := 0; j < 3; j++ {
+			req := httptest.NewRequest("GET", "/api/orders/x", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			backend := rw.Header().Get(backendHeader)
+			if got := rw.Header().Get("Upstream"); got != backend {
+				t.Fatalf("%s header says %q but %q answered", backendHeader, backend, got)
+			}
+			if first == "" {
+				first = backend
+			}
+			if backend != first {
+				t.Fatalf("user-%d: expected sticky backend %q, got %q", i, first, backend)
+			}
+		}
+		seen[first] = true
+	}
+	if !seen[backendStable] || !seen[backendCanary] {
+		t.Fatalf("expected users on both backends, got %v", seen)
+	}
+}
+
+func TestCanaryUnavailableFallsBack(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer stable.Close()
+
+	p, err := newProxy(ServiceConfig{
+		Name: "orders", PathPrefix: "/api/orders", TargetURL: stable.URL,
+		Canary: &CanaryConfig{TargetURL: "http://canary:8080", Weight: 100},
+	}, ServerConfig{}, http.DefaultTransport.(*http.Transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.lb.canary.healthy.Store(false)
+
+	rw := httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/x", nil))
+	if rw.Code != http.StatusOK || rw.Header().Get(backendHeader) != backendStable {
+		t.Fatalf("expected stable backend, got %d %q", rw.Code, rw.Header().Get(backendHeader))
+	}
+}
+
+func TestInCanaryWeight(t *testing.T) {
+	for _, weight := range []int{0, 5, 100} {
+		n := 0
+		for i := 0; i < 10000; i++ {
+			if inCanary(fmt.Sprintf("req-%d", i), weight) {
+				n++
+			}
+		}
+		if got, want := n/100, weight; got < want-1 || got > want+1 {
+			t.Errorf("weight %d: %d of 10000 keys in canary", weight, n)
+		}
+	}
+}
+
+func TestLoadConfigInvalidCanary(t *testing.T) {
+	tests := map[string]string{
+		"missing url":  `{weight: 5}`,
+		"weight range": `{target_url: "http://canary:8080", weight: 150}`,
+	}
+	for name, canary := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    env_var: "TEST_CANARY_SERVICE_URL"
+    canary: `+canary+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid canary")
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/net/http/httpguts"
+)
+
+// validateClaimHeaders checks a claim_headers map of dot separated claim
+// paths to header names
+func validateClaimHeaders(claimHeaders map[string]string, service bool) error {
+	for claim, header := range claimHeaders {
+		if !validFieldPath(claim) {
+			return fmt.Errorf("claim_headers: invalid claim path %q", claim)
+		}
+		// a service may map a claim to nothing to drop a top-level entry
+		if header == "" && service {
+			continue
+		}
+		if !httpguts.ValidHeaderFieldName(header) {
+			return fmt.Errorf("claim_headers: invalid header name %q for claim %s", header, claim)
+		}
+	}
+	return nil
+}
+
+// validateClaimHeaders checks the service's claim_headers, which need
+// credentials to take claims from
+func (s ServiceConfig) validateClaimHeaders() error {
+	if len(s.ClaimHeaders) > 0 && !s.authenticates() {
+		return errors.New("claim_headers needs auth_required or auth_optional")
+	}
+	return validateClaimHeaders(s.ClaimHeaders, true)
+}
+
+// claimHeaders merges the top-level claim_headers with the service's, the
+// service winning per claim. An empty header name in the service drops a
+// top-level entry.
+func (c *Config) claimHeaders(s ServiceConfig) map[string]string {
+	if len(c.ClaimHeaders) == 0 && len(s.ClaimHeaders) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(c.ClaimHeaders)+len(s.ClaimHeaders))
+	for claim, header := range c.ClaimHeaders {
+		merged[claim] = header
+	}
+	for claim, header := range s.ClaimHeaders {
+		if header == "" {
+			delete(merged, claim)
+			continue
+		}
+		merged[claim] = header
+	}
+	return merged
+}
+
+// claimHeaderNames lists every header claim_headers may set anywhere, which
+// are dropped from client requests like the X-User-* headers
+func (c *Config) claimHeaderNames() []string {
+	var names []string
+	for _, header := range c.ClaimHeaders {
+		names = append(names, header)
+	}
+	for _, s := range c.Services {
+		for _, header := range s.ClaimHeaders {
+			if header != "" {
+				names = append(names, header)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// claimHeader is one claim_headers entry with its path split up front
+type claimHeader struct {
+	claim  string
+	header string
+}
+
+func newClaimHeaders(m map[string]string) []claimHeader {
+	hs := make([]claimHeader, 0, len(m))
+	for claim, header := range m {
+		hs = append(hs, claimHeader{claim: claim, header: header})
+	}
+	// a stable order keeps two claims mapped to one header deterministic
+	sort.Slice(hs, func(i, j int) bool { return hs[i].claim < hs[j].claim })
+	return hs
+}
+
+// setClaimHeaders copies the configured claims into the request headers.
+// Strings are sent as is, anything else JSON encoded; claims that are
+// missing or can't be sent in a header are left out.
+func setClaimHeaders(h http.Header, claims jwt.MapClaims, hs []claimHeader) {
+	for _, ch := range hs {
+		v, ok := claimValue(claims, ch.claim)
+		if !ok || v == nil {
+			continue
+		}
+		value, ok := claimHeaderValue(v)
+		if !ok {
+			logger.Warn("claim value not valid in a header", "claim", ch.claim, "header", ch.header)
+			continue
+		}
+		if value != "" {
+			h.Set(ch.header, value)
+		}
+	}
+}
+
+// claimHeaderValue renders a claim for a header: strings as is, anything
+// else JSON encoded. It reports false for values a header can't carry.
+func claimHeaderValue(v interface{}) (string, bool) {
+	value, ok := v.(string)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		value = string(b)
+	}
+	return value, httpguts.ValidHeaderFieldValue(value)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestClaimHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret:    "secret",
+		ClaimHeaders: map[string]string{"email": "X-User-Email", "org.id": "X-Org-Id"},
+		Services: []ServiceConfig{
+			{
+				Name: "billing", PathPrefix: "/api/billing", TargetURL: upstream.URL, AuthRequired: true,
+				ClaimHeaders: map[string]string{"tenant_id": "X-Tenant-Id", "org.plan": "X-Org-Plan", "email": ""},
+			},
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+			{Name: "public", PathPrefix: "/api/public", TargetURL: upstream.URL},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	token := signToken(t, "secret", jwt.MapClaims{
+		"sub":       "42",
+		"email":     "alice@example.com",
+		"tenant_id": 7,
+		"org":       map[string]interface{}{"id": "acme", "plan": map[string]interface{}{"tier": "gold", "seats": 10}},
+	})
+	send := func(path string) http.Header {
+		got = nil
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Org-Id", "forged")
+		req.Header.Set("X-Tenant-Id", "forged")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got %d want %d", path, rw.Code, http.StatusOK)
+		}
+		return got
+	}
+
+	h := send("/api/billing/x")
+	want := map[string]string{
+		"X-User-Id":    "42",
+		"X-User-Email": "",
+		"X-Org-Id":     "acme",
+		"X-Tenant-Id":  "7",
+		"X-Org-Plan":   `{"seats":10,"tier":"gold"}`,
+	}
+	for name, value := range want {
+		if h.Get(name) != value {
+			t.Errorf("billing: %s = %q want %q", name, h.Get(name), value)
+		}
+	}
+
+	h = send("/api/orders/x")
+	if h.Get("X-User-Email") != "alice@example.com" || h.Get("X-Org-Id") != "acme" || h.Get("X-Org-Plan") != "" {
+		t.Errorf("orders: got email %q, org %q, plan %q", h.Get("X-User-Email"), h.Get("X-Org-Id"), h.Get("X-Org-Plan"))
+	}
+
+	// headers of any service's claim_headers are dropped on every route
+	h = send("/api/public/x")
+	if h.Get("X-Org-Id") != "" || h.Get("X-Tenant-Id") != "" {
+		t.Errorf("public: forged headers reached the upstream: %q %q", h.Get("X-Org-Id"), h.Get("X-Tenant-Id"))
+	}
+}
+
+func TestLoadConfigInvalidClaimHeaders(t *testing.T) {
+	tests := map[string]struct{ global, service string }{
+		"bad header":       {global: `{email: "X User Email"}`},
+		"empty header":     {global: `{email: ""}`},
+		"bad claim path":   {global: `{"org..id": "X-Org-Id"}`},
+		"service bad name": {service: "auth_required: true\n    claim_headers: {email: \"X-Email:\"}"},
+		"service no auth":  {service: `claim_headers: {email: "X-User-Email"}`},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			global := ""
+			if tt.global != "" {
+				global = "claim_headers: " + tt.global
+			}
+			path := writeConfig(t, `
+jwt_secret: "secret"
+`+global+`
+services:
+  - name: "billing"
+    path_prefix: "/api/billing"
+    target_url: "http://billing:8080"
+    `+tt.service+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid claim_headers")
+			}
+		})
+	}
+}
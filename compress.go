@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// defaultCompressibleTypes are compressed when content_types is not set;
+// types ending in +json or +xml are included as well
+var defaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-ndjson",
+	"image/svg+xml",
+}
+
+// defaultCompressionMinSize leaves responses too small to benefit alone
+const defaultCompressionMinSize = 1024
+
+// CompressionConfig compresses responses for clients that accept it. In yaml
+// it may also be a plain bool to turn compression on with the defaults.
+type CompressionConfig struct {
+	Enabled      *bool    `yaml:"enabled"`
+	Algorithms   []string `yaml:"algorithms"`
+	Level        int      `yaml:"level"`
+	MinSize      string   `yaml:"min_size"`
+	ContentTypes []string `yaml:"content_types"`
+}
+
+func (c *CompressionConfig) UnmarshalYAML(value *yaml.Node) error {
+	var enabled bool
+	if value.Decode(&enabled) == nil {
+		*c = CompressionConfig{Enabled: &enabled}
+		return nil
+	}
+	type plain CompressionConfig
+	return value.Decode((*plain)(c))
+}
+
+func (c *CompressionConfig) enabled() bool {
+	return c != nil && (c.Enabled == nil || *c.Enabled)
+}
+
+// algorithms returns the configured encodings in order of preference
+func (c *CompressionConfig) algorithms() []string {
+	if len(c.Algorithms) == 0 {
+		return []string{encodingGzip}
+	}
+	return c.Algorithms
+}
+
+func (c *CompressionConfig) minSize() (int64, error) {
+	switch strings.TrimSpace(c.MinSize) {
+	case "":
+		return defaultCompressionMinSize, nil
+	case "0":
+		return 0, nil
+	}
+	n, err := parseByteSize(c.MinSize)
+	if err != nil {
+		return 0, fmt.Errorf("compression: invalid min_size %q", c.MinSize)
+	}
+	return n, nil
+}
+
+func (c *CompressionConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if _, err := c.minSize(); err != nil {
+		return err
+	}
+	for _, a := range c.algorithms() {
+		if a != encodingGzip && a != encodingBrotli {
+			return fmt.Errorf("compression: unknown algorithm %q, want %q or %q", a, encodingGzip, encodingBrotli)
+		}
+	}
+	if c.Level < 0 || c.Level > 9 {
+		return fmt.Errorf("compression: level must be between 1 and 9, got %d", c.Level)
+	}
+	return nil
+}
+
+// compressible reports whether responses of contentType are worth compressing
+func (c *CompressionConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := c.ContentTypes
+	if len(types) == 0 {
+		if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+			return true
+		}
+		types = defaultCompressibleTypes
+	}
+	for _, t := range types {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the first configured encoding the Accept-Encoding header
+// allows, or "" when the response has to stay uncompressed
+func (c *CompressionConfig) negotiate(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, a := range c.algorithms() {
+		if ok, listed := accepted[a]; ok || !listed && accepted["*"] {
+			return a
+		}
+	}
+	return ""
+}
+
+// compress encodes responses the client accepts compressed as they are
+// written, so streamed responses stay streamed. At most min_size bytes are
+// held back while deciding whether a response is worth compressing.
+func compress(c CompressionConfig) func(http.Handler) http.Handler {
+	minSize, _ := c.minSize()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead || isWebSocketUpgrade(r.Header) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, config: &c, encoding: encoding, minSize: minSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter decides on the final WriteHeader whether a response may be
+// compressed, based on its status, Content-Type and Content-Encoding. When
+// the length is unknown the body is buffered until it reaches minSize or is
+// flushed before the header goes out.
+type compressWriter struct {
+	http.ResponseWriter
+	config   *CompressionConfig
+	encoding string
+	minSize  int64
+
+	status  int
+	pending bool // header held back until the size is known
+	buf     []byte
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// informational responses such as 103 Early Hints go out as they come
+	// and leave the decision to the final status
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	h := cw.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if !cw.shouldCompress(status, h) {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+		if n >= cw.minSize {
+			cw.startEncoding()
+		} else {
+			cw.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+	cw.pending = true
+}
+
+func (cw *compressWriter) shouldCompress(status int, h http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	return cw.config.compressible(h.Get("Content-Type"))
+}
+
+// startEncoding sends the header for a compressed body and writes out what
+// was buffered through the encoder
+func (cw *compressWriter) startEncoding() error {
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.encoding)
+	cw.pending = false
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.enc = newEncoder(cw.ResponseWriter, cw.encoding, cw.config.Level)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.enc.Write(buf)
+	return err
+}
+
+// writePlain gives up on compression and writes out the buffered body as is
+func (cw *compressWriter) writePlain() error {
+	cw.pending = false
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending {
+		cw.buf = append(cw.buf, p...)
+		if int64(len(cw.buf)) < cw.minSize {
+			return len(p), nil
+		}
+		return len(p), cw.startEncoding()
+	}
+	if cw.enc == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.enc.Write(p)
+}
+
+// Flush pushes out what the encoder holds so streamed responses reach the
+// client as promptly as uncompressed ones. A response flushed before it
+// reached minSize is taken to be a stream and compressed.
+func (cw *compressWriter) Flush() {
+	if cw.pending {
+		cw.startEncoding()
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("compression: %T does not support hijacking", cw.ResponseWriter)
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close writes out a body that stayed below minSize, or the encoder's
+// trailer, once the handler is done
+func (cw *compressWriter) Close() error {
+	if cw.pending {
+		return cw.writePlain()
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	return cw.enc.Close()
+}
+
+func newEncoder(w io.Writer, encoding string, level int) io.WriteCloser {
+	if encoding == encodingBrotli {
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level)
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	// level was validated, so the error can't happen
+	gz, _ := gzip.NewWriterLevel(w, level)
+	return gz
+}
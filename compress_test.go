@@ -0,0 +1,299 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat(`{"id": 1, "name": "item"},`, 200)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/items/png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/api/items/encoded":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server: ServerConfig{Compression: &CompressionConfig{Algorithms: []string{"br", "gzip"}}},
+		Services: []ServiceConfig{
+			{Name: "items", PathPrefix: "/api/items", TargetURL: upstream.URL},
+		},
+		JWTSecret: "dummy",
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name, path, accept, want string
+	}{
+		{"gzip", "/api/items/x", "gzip, deflate", "gzip"},
+		{"brotli preferred", "/api/items/x", "gzip, br", "br"},
+		{"brotli refused", "/api/items/x", "br;q=0, gzip", "gzip"},
+		{"not accepted", "/api/items/x", "", ""},
+		{"incompressible type", "/api/items/png", "gzip", ""},
+		{"already encoded", "/api/items/encoded", "br", "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Header().Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("unexpected Content-Encoding: got %q want %q", got, tt.want)
+			}
+			if tt.path != "/api/items/x" || tt.want == "" {
+				return
+			}
+			if rw.Header().Get("Content-Length") != "" {
+				t.Fatal("expected Content-Length to be dropped")
+			}
+			var dec io.Reader
+			if tt.want == "br" {
+				dec = brotli.NewReader(rw.Body)
+			} else {
+				gz, err := gzip.NewReader(rw.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec = gz
+			}
+			got, err := io.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Fatal("decompressed body does not match")
+			}
+		})
+	}
+}
+
+func TestCompressionStreams(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	cfg := &Config{
+		Server:    ServerConfig{Compression: &CompressionConfig{}},
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "events", PathPrefix: "/api/events", TargetURL: upstream.URL, Timeout: "0"},
+		},
+	}
+	gateway := httptest.NewServer(mustBuildRouter(t, cfg))
+	defer gateway.Close()
+
+	req, _ := http.NewRequest("GET", gateway.URL+"/api/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip stream, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(gz, buf); err != nil || string(buf) != "data: first\n\n" {
+		t.Fatalf("expected first event before the upstream finished, got %q %v", buf, err)
+	}
+}
+
+func TestCompressionEarlyHints(t *testing.T) {
+	body := strings.Repeat("a", 2048)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{Compression: &CompressionConfig{}},
+		JWTSecret: "dummy",
+		Services:  []ServiceConfig{{Name: "items", PathPrefix: "/api/items", TargetURL: upstream.URL}},
+	}
+	gateway := httptest.NewServer(mustBuildRouter(t, cfg))
+	defer gateway.Close()
+
+	req, _ := http.NewRequest("GET", gateway.URL+"/api/items/x", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the 103 goes out on its own and does not stand in for the final status
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip body, got %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestLoadConfigInvalidCompression(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  compression:
+    algorithms: ["zstd"]
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for unknown compression algorithm")
+	}
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		size := 100
+		if r.URL.Path == "/api/items/large" {
+			size = 4096
+		}
+		if r.URL.Query().Get("length") != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		io.WriteString(w, strings.Repeat("a", size))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "items", PathPrefix: "/api/items", TargetURL: upstream.URL, Compression: &CompressionConfig{MinSize: "1KB"}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/items/small", ""},
+		{"/api/items/small?length=1", ""},
+		{"/api/items/large", "gzip"},
+		{"/api/items/large?length=1", "gzip"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if got := rw.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("%s: unexpected Content-Encoding: got %q want %q", tt.path, got, tt.want)
+		}
+		if tt.want == "" && rw.Body.Len() != 100 {
+			t.Errorf("%s: expected the small body unchanged, got %d bytes", tt.path, rw.Body.Len())
+		}
+	}
+}
+
+func TestCompressionMinSizeEarlyHints(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := 100
+		if r.URL.Path == "/api/items/large" {
+			size = 4096
+		}
+		w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		// no Content-Length, so the gateway holds the header back
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, strings.Repeat("a", size))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "items", PathPrefix: "/api/items", TargetURL: upstream.URL, Compression: &CompressionConfig{MinSize: "1KB"}},
+		},
+	}
+	gateway := httptest.NewServer(mustBuildRouter(t, cfg))
+	defer gateway.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 5 * time.Second}
+
+	for path, want := range map[string]string{"/api/items/small": "", "/api/items/large": "gzip"} {
+		req, _ := http.NewRequest("GET", gateway.URL+path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("%s: got status %d want %d", path, resp.StatusCode, http.StatusInternalServerError)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != want {
+			t.Errorf("%s: unexpected Content-Encoding: got %q want %q", path, got, want)
+		}
+	}
+}
+
+func TestCompressionConfigForms(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  compression: true
+services:
+  - name: "items"
+    path_prefix: "/api/items"
+    target_url: "http://items:8080"
+    env_var: "TEST_COMPRESSION_ITEMS_URL"
+    compression: false
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    env_var: "TEST_COMPRESSION_ORDERS_URL"
+    compression:
+      min_size: "0"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Server.Compression.enabled() {
+		t.Fatal("expected compression: true to enable compression")
+	}
+	if cfg.Services[0].Compression.enabled() {
+		t.Fatal("expected compression: false to disable compression")
+	}
+	if n, _ := cfg.Services[1].Compression.minSize(); !cfg.Services[1].Compression.enabled() || n != 0 {
+		t.Fatalf("unexpected compression config %+v", cfg.Services[1].Compression)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var inflightGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gateway_inflight_requests",
+	Help: "Requests of services with max_concurrent currently holding a slot.",
+}, []string{"service"})
+
+func init() {
+	prometheus.MustRegister(inflightGauge)
+}
+
+// maxConcurrentWait is how long a request may queue for a free slot; zero
+// rejects it at once
+func (s ServiceConfig) maxConcurrentWait() (time.Duration, error) {
+	if s.MaxConcurrentWait == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.MaxConcurrentWait)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid max_concurrent_wait %q", s.MaxConcurrentWait)
+	}
+	return d, nil
+}
+
+func (s ServiceConfig) validateMaxConcurrent() error {
+	if s.MaxConcurrent < 0 {
+		return errors.New("max_concurrent must not be negative")
+	}
+	if s.MaxConcurrentWait != "" && s.MaxConcurrent == 0 {
+		return errors.New("max_concurrent_wait needs max_concurrent")
+	}
+	_, err := s.maxConcurrentWait()
+	return err
+}
+
+// limitConcurrency lets at most n requests of a service in flight at once.
+// Others wait up to wait for a slot and are then answered with 503, so a
+// slow upstream can't tie up an unbounded share of the gateway. The
+// semaphore is per router; a reload starts with free slots while requests
+// of the old router finish.
+func limitConcurrency(service string, n int, wait time.Duration) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, n)
+	inflight := inflightGauge.WithLabelValues(service)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !acquireSlot(r, slots, wait) {
+				logger.Warn("service at max_concurrent", "service", service, "limit", n, "path", r.URL.Path)
+				writeError(w, r, http.StatusServiceUnavailable, "too many concurrent requests")
+				return
+			}
+			inflight.Inc()
+			defer func() {
+				inflight.Dec()
+				<-slots
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acquireSlot takes a slot, waiting up to wait or until the client goes away
+func acquireSlot(r *http.Request, slots chan struct{}, wait time.Duration) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMaxConcurrent(t *testing.T) {
+	arrived := make(chan struct{}, 10)
+	release := map[string]chan struct{}{"/reports": make(chan struct{}), "/exports": make(chan struct{})}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release[path.Dir(r.URL.Path)]
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Services: []ServiceConfig{
+			{Name: "reports", PathPrefix: "/api/reports", TargetURL: upstream.URL, StripPrefix: "/api", MaxConcurrent: 2},
+			{Name: "exports", PathPrefix: "/api/exports", TargetURL: upstream.URL, StripPrefix: "/api", MaxConcurrent: 1, MaxConcurrentWait: "5s"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	send := func(p string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest("GET", p, nil))
+			codes <- rw.Code
+		}()
+	}
+	waitArrived := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-arrived:
+			case <-time.After(5 * time.Second):
+				t.Fatal("request did not reach the upstream")
+			}
+		}
+	}
+
+	send("/api/reports/1")
+	send("/api/reports/2")
+	waitArrived(2)
+	if got := testutil.ToFloat64(inflightGauge.WithLabelValues("reports")); got != 2 {
+		t.Errorf("inflight gauge = %v want 2", got)
+	}
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/reports/3", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("saturated: got %d want %d", rw.Code, http.StatusServiceUnavailable)
+	}
+	close(release["/reports"])
+
+	// with max_concurrent_wait the second request queues instead
+	send("/api/exports/1")
+	waitArrived(1)
+	send("/api/exports/2")
+	select {
+	case <-arrived:
+		t.Fatal("queued request reached the upstream while the slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release["/exports"] <- struct{}{}
+	waitArrived(1)
+	close(release["/exports"])
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("got %d want %d", code, http.StatusOK)
+		}
+	}
+	if got := testutil.ToFloat64(inflightGauge.WithLabelValues("reports")); got != 0 {
+		t.Errorf("inflight gauge = %v after completion, want 0", got)
+	}
+}
+
+func TestLoadConfigInvalidMaxConcurrent(t *testing.T) {
+	tests := map[string]string{
+		"negative":          "max_concurrent: -1",
+		"wait without max":  `max_concurrent_wait: "1s"`,
+		"bad wait":          "max_concurrent: 10\n    max_concurrent_wait: \"a bit\"",
+		"negative duration": "max_concurrent: 10\n    max_concurrent_wait: \"-1s\"",
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "reports"
+    path_prefix: "/api/reports"
+    target_url: "http://reports:8080"
+    `+extra+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid max_concurrent")
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envRef matches ${VAR} and ${VAR:-default} in a config file, and $${,
+// which stands for a literal ${
+var envRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv expands environment references in the raw bytes of a config
+// file before it is parsed, so a variable can fill any part of it: a number,
+// a key or a flow list such as ["a", "b"]. A default applies when the
+// variable is unset or empty; variables without one must be set, and $${ is
+// kept as a literal ${, which is how rewrite replacements refer to ${name}
+// groups. The text is substituted as is, comments included, so a value
+// holding YAML syntax such as ": ", "#" or quotes changes how the file parses.
+func interpolateEnv(data []byte) ([]byte, error) {
+	missing := make(map[string]bool)
+	out := envRef.ReplaceAllStringFunc(string(data), func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		m := envRef.FindStringSubmatch(ref)
+		if v := os.Getenv(m[1]); v != "" {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if _, ok := os.LookupEnv(m[1]); !ok {
+			missing[m[1]] = true
+		}
+		return ""
+	})
+	if len(missing) == 0 {
+		return []byte(out), nil
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("environment variables referenced but not set: %s", strings.Join(names, ", "))
+}
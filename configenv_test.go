@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigInterpolatesEnv(t *testing.T) {
+	t.Setenv("GW_HOST", "127.0.0.1")
+	t.Setenv("ORDERS_MAX_CONCURRENT", "25")
+	// inside quotes YAML syntax in a value stays part of the value
+	t.Setenv("ORDERS_TOKEN", "s3cret: #1")
+	t.Setenv("ORDERS_PREFIX", "")
+	// unquoted, a value can fill in structure or a key
+	t.Setenv("ORDERS_ALLOW_IPS", `["10.0.0.0/8", "fd00::/8"]`)
+	t.Setenv("ORDERS_TRACE_HEADER", "X-Trace-Source")
+	path := writeConfig(t, `
+server:
+  host: ${GW_HOST}
+  port: "${GW_PORT:-9090}"
+services:
+  - name: "orders"
+    path_prefix: "${ORDERS_PREFIX:-/api/orders}"
+    target_url: "http://orders:8080"
+    max_concurrent: ${ORDERS_MAX_CONCURRENT}
+    allow_ips: ${ORDERS_ALLOW_IPS}
+    request_headers:
+      set:
+        Authorization: "Bearer ${ORDERS_TOKEN}"
+        ${ORDERS_TRACE_HEADER}: "gateway"
+    add_response_headers:
+      X-Template: "$${ORDERS_TOKEN} is $$ORDERS_TOKEN"
+    rewrite:
+      pattern: "^/v1/(?P<rest>.*)$"
+      replacement: "/$${rest}"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Services[0]
+	if cfg.Server.Host != "127.0.0.1" || cfg.Server.Port != "9090" {
+		t.Errorf("server: got host %q port %q", cfg.Server.Host, cfg.Server.Port)
+	}
+	// an empty variable takes the default like in the shell
+	if s.PathPrefix != "/api/orders" {
+		t.Errorf("path_prefix = %q want /api/orders", s.PathPrefix)
+	}
+	if s.MaxConcurrent != 25 {
+		t.Errorf("max_concurrent = %d want 25", s.MaxConcurrent)
+	}
+	if len(s.AllowIPs) != 2 || s.AllowIPs[0] != "10.0.0.0/8" || s.AllowIPs[1] != "fd00::/8" {
+		t.Errorf("allow_ips = %q", s.AllowIPs)
+	}
+	if got := s.RequestHeaders.Set["Authorization"]; got != "Bearer s3cret: #1" {
+		t.Errorf("request header = %q", got)
+	}
+	if got := s.RequestHeaders.Set["X-Trace-Source"]; got != "gateway" {
+		t.Errorf("expanded header name: got %v", s.RequestHeaders.Set)
+	}
+	if got := s.AddResponseHeaders["X-Template"]; got != "${ORDERS_TOKEN} is $$ORDERS_TOKEN" {
+		t.Errorf("escaped reference = %q", got)
+	}
+	if s.Rewrite.Replacement != "/${rest}" {
+		t.Errorf("expected the escaped group reference in the rewrite replacement, got %q", s.Rewrite.Replacement)
+	}
+}
+
+// Values are expanded once, so a $ inside a variable reaches the upstream and
+// the key check as is
+func TestLoadConfigEnvValueWithDollar(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	t.Setenv("ORDERS_TOKEN", "ab$cd")
+	t.Setenv("ORDERS_KEY", "k$ey")
+	path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "`+upstream.URL+`"
+    auth_required: true
+    auth: api_key
+    api_key:
+      keys: ["${ORDERS_KEY}"]
+      clients:
+        - id: "billing"
+          key: "billing-${ORDERS_KEY}"
+    request_headers:
+      set:
+        X-Internal-Token: "${ORDERS_TOKEN}"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mustBuildRouter(t, cfg)
+	for _, key := range []string{"k$ey", "billing-k$ey"} {
+		got = nil
+		req := httptest.NewRequest("GET", "/api/orders/1", nil)
+		req.Header.Set("X-API-Key", key)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("key %q: got %d want %d", key, rw.Code, http.StatusOK)
+		}
+		if v := got.Get("X-Internal-Token"); v != "ab$cd" {
+			t.Errorf("X-Internal-Token = %q want ab$cd", v)
+		}
+	}
+}
+
+func TestLoadConfigEnvEscape(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	t.Setenv("ORDERS_TOKEN", "s3cret")
+	t.Setenv("ORDERS_KEY", "k3y")
+	path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "`+upstream.URL+`"
+    auth_required: true
+    auth: api_key
+    api_key:
+      keys: ["$${ORDERS_KEY}"]
+    request_headers:
+      set:
+        X-Template: "$${ORDERS_TOKEN}"
+    rewrites:
+      - pattern: "^/v1/(?P<rest>.*)$"
+        replacement: "/$${rest}"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Services[0].Rewrites[0].Replacement; got != "/${rest}" {
+		t.Errorf("expected the escaped group reference in the rewrites replacement, got %q", got)
+	}
+
+	r := mustBuildRouter(t, cfg)
+	req := httptest.NewRequest("GET", "/api/orders/1", nil)
+	req.Header.Set("X-API-Key", "${ORDERS_KEY}")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("escaped key: got %d want %d", rw.Code, http.StatusOK)
+	}
+	if v := got.Get("X-Template"); v != "${ORDERS_TOKEN}" {
+		t.Errorf("X-Template = %q want ${ORDERS_TOKEN}", v)
+	}
+}
+
+func TestLoadConfigUnsetEnv(t *testing.T) {
+	path := writeConfig(t, `
+jwt_secret: "${GW_TEST_UNSET_SECRET}"
+# comments are expanded too: ${GW_TEST_UNSET_COMMENT}
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "${GW_TEST_UNSET_URL}"
+    timeout: "${GW_TEST_UNSET_TIMEOUT:-5s}"
+`)
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("expected error for unset variables")
+	}
+	if !strings.Contains(err.Error(), "GW_TEST_UNSET_COMMENT, GW_TEST_UNSET_SECRET, GW_TEST_UNSET_URL") || strings.Contains(err.Error(), "TIMEOUT") {
+		t.Errorf("expected the error to list the unset variables without defaults, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles expands the -config path: a glob matches files, a directory
+// stands for the *.yaml files in it and anything else is a single file
+func configFiles(path string) ([]string, error) {
+	pattern := path
+	if !strings.ContainsAny(path, "*?[") {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.IsDir() {
+			return []string{path}, nil
+		}
+		pattern = filepath.Join(path, "*.yaml")
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config path %q: %w", path, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config files match %q", pattern)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readConfig parses the config at path, merging every file when path names
+// several. Services and redirects are concatenated; any other top-level
+// setting may be given by one file, or by several with the same value.
+func readConfig(path string) (*Config, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 1 {
+		return readConfigFile(files[0])
+	}
+	var (
+		merged   Config
+		setIn    = make(map[string]string)
+		services = make(map[string]string)
+	)
+	for _, file := range files {
+		c, err := readConfigFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := mergeSettings(&merged, c, file, setIn); err != nil {
+			return nil, err
+		}
+		for _, s := range c.Services {
+			if prev, ok := services[s.Name]; ok && s.Name != "" {
+				return nil, fmt.Errorf("service %s is defined in both %s and %s", s.Name, prev, file)
+			}
+			services[s.Name] = file
+		}
+		merged.Services = append(merged.Services, c.Services...)
+		merged.Redirects = append(merged.Redirects, c.Redirects...)
+	}
+	return &merged, nil
+}
+
+func readConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if data, err = interpolateEnv(data); err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config yaml: %w", err)
+	}
+	return &cfg, nil
+}
+
+// mergeSettings copies the top-level settings of c, other than services and
+// redirects, into dst. setIn records which file set each one so a second
+// file giving a different value can be reported.
+func mergeSettings(dst, c *Config, file string, setIn map[string]string) error {
+	dv, cv := reflect.ValueOf(dst).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < dv.NumField(); i++ {
+		key, _, _ := strings.Cut(dv.Type().Field(i).Tag.Get("yaml"), ",")
+		if key == "services" || key == "redirects" || cv.Field(i).IsZero() {
+			continue
+		}
+		if prev, ok := setIn[key]; ok {
+			if !reflect.DeepEqual(dv.Field(i).Interface(), cv.Field(i).Interface()) {
+				return fmt.Errorf("%s is set differently in %s and %s", key, prev, file)
+			}
+			continue
+		}
+		dv.Field(i).Set(cv.Field(i))
+		setIn[key] = file
+	}
+	return nil
+}
+
+// configStamp summarises the size and modification time of every file
+// behind path, as expanded by configFiles, so a watcher notices edits,
+// additions and removals
+func configStamp(path string) (string, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", file, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigDir writes files, keyed by name, into a fresh directory
+func writeConfigDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"base.yaml": `
+server:
+  port: ":9000"
+jwt_secret: "secret"
+`,
+		"orders.yaml": `
+jwt_secret: "secret"
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+`,
+		"users.yaml": `
+services:
+  - name: "users"
+    path_prefix: "/api/users"
+    target_url: "http://users:8080"
+redirects:
+  - from_prefix: "/old-users"
+    to: "/api/users"
+`,
+		"notes.txt": "not yaml",
+	})
+
+	for _, path := range []string{dir, filepath.Join(dir, "*.yaml")} {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if cfg.Server.Port != ":9000" || cfg.JWTSecret != "secret" {
+			t.Errorf("%s: base settings not merged: port %q secret %q", path, cfg.Server.Port, cfg.JWTSecret)
+		}
+		if len(cfg.Services) != 2 || cfg.Services[0].Name != "orders" || cfg.Services[1].Name != "users" {
+			t.Errorf("%s: unexpected services %+v", path, cfg.Services)
+		}
+		if len(cfg.Redirects) != 1 {
+			t.Errorf("%s: expected 1 redirect, got %d", path, len(cfg.Redirects))
+		}
+	}
+}
+
+func TestLoadConfigDirectoryConflicts(t *testing.T) {
+	tests := map[string]struct {
+		files map[string]string
+		want  string
+	}{
+		"server": {
+			files: map[string]string{
+				"a.yaml": "server:\n  port: \":9000\"\n",
+				"b.yaml": "server:\n  port: \":9001\"\n",
+			},
+			want: "server is set differently",
+		},
+		"duplicate service": {
+			files: map[string]string{
+				"a.yaml": "services:\n  - name: orders\n    path_prefix: /api/orders\n    target_url: http://orders:8080\n",
+				"b.yaml": "services:\n  - name: orders\n    path_prefix: /api/orders2\n    target_url: http://orders:8080\n",
+			},
+			want: "service orders is defined in both",
+		},
+		"empty": {
+			files: map[string]string{"config.json": "{}"},
+			want:  "no config files match",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := writeConfigDir(t, tt.files)
+			_, err := loadConfig(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestWatchFileDirectory(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{"base.yaml": "services: []\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := watchFile(ctx, dir, 10*time.Millisecond)
+
+	select {
+	case <-changed:
+		t.Fatal("unexpected change signal for an untouched directory")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "orders.yaml"), []byte("services: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected a change signal after adding a file")
+	}
+}
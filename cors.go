@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/cors"
+)
+
+// CORSConfig is the cross-origin policy of the gateway or a single service.
+// With enabled: false the gateway adds no CORS headers and passes preflight
+// requests through, leaving CORS to the upstream.
+type CORSConfig struct {
+	Enabled          *bool    `yaml:"enabled"`
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"`
+}
+
+// defaultCORS is used when neither the server nor the service configures a
+// policy. It allows any origin and therefore never allows credentials.
+var defaultCORS = CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+	AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+	ExposedHeaders: []string{"Link"},
+	MaxAge:         300,
+}
+
+func (c *CORSConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+func (c *CORSConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if len(c.AllowedOrigins) == 0 {
+		return errors.New("cors: allowed_origins must not be empty")
+	}
+	if c.AllowCredentials {
+		// browsers refuse credentialed responses with a wildcard origin, so the
+		// matched origin has to be echoed and must be listed explicitly
+		for _, o := range c.AllowedOrigins {
+			if o == "*" {
+				return errors.New(`cors: allow_credentials cannot be combined with a "*" origin`)
+			}
+		}
+	}
+	if c.MaxAge < 0 {
+		return errors.New("cors: max_age must not be negative")
+	}
+	return nil
+}
+
+// corsHandler returns the middleware enforcing c. Allowed origins are echoed
+// back individually; "*" is only sent when credentials are not allowed.
+func corsHandler(c CORSConfig) func(http.Handler) http.Handler {
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORS.AllowedMethods
+	}
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORS.AllowedHeaders
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   methods,
+		AllowedHeaders:   headers,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}).Handler
+}
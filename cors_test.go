@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServiceCORSOverride(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Server: ServerConfig{
+			CORS: &CORSConfig{AllowedOrigins: []string{"https://shop.example"}},
+		},
+		Services: []ServiceConfig{
+			{Name: "shop", PathPrefix: "/api/shop", TargetURL: upstream.URL},
+			{
+				Name: "account", PathPrefix: "/api/account", TargetURL: upstream.URL, AuthRequired: true,
+				CORS: &CORSConfig{AllowedOrigins: []string{"https://account.example"}, AllowCredentials: true},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		path, origin, wantOrigin string
+		wantCredentials          bool
+	}{
+		{"/api/shop/x", "https://shop.example", "https://shop.example", false},
+		{"/api/shop/x", "https://account.example", "", false},
+		{"/api/account/x", "https://account.example", "https://account.example", true},
+		{"/api/account/x", "https://shop.example", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+
+		if got := rw.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s from %s: allow origin %q, want %q", tt.path, tt.origin, got, tt.wantOrigin)
+		}
+		if got := rw.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.wantCredentials {
+			t.Errorf("%s from %s: allow credentials %v, want %v", tt.path, tt.origin, got, tt.wantCredentials)
+		}
+		if rw.Code == http.StatusUnauthorized {
+			t.Errorf("%s: preflight must not require auth", tt.path)
+		}
+	}
+}
+
+func TestDefaultCORSWithoutCredentials(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services:  []ServiceConfig{{Name: "shop", PathPrefix: "/api/shop", TargetURL: upstream.URL}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/shop/x", nil)
+	req.Header.Set("Origin", "https://any.example")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if got := rw.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected wildcard origin, got %q", got)
+	}
+	if rw.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatal("wildcard origin must not allow credentials")
+	}
+}
+
+func TestLoadConfigCORSWildcardCredentials(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  cors:
+    allowed_origins: ["*"]
+    allow_credentials: true
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for wildcard origin with credentials")
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	var preflights int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			preflights++
+		}
+	}))
+	defer upstream.Close()
+
+	disabled := false
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Server:    ServerConfig{CORS: &CORSConfig{Enabled: &disabled}},
+		Services:  []ServiceConfig{{Name: "shop", PathPrefix: "/api/shop", TargetURL: upstream.URL}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/shop/x", nil)
+	req.Header.Set("Origin", "https://any.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if got := rw.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no CORS headers, got allow origin %q", got)
+	}
+	if preflights != 1 {
+		t.Fatalf("expected preflight to reach the upstream, got %d", preflights)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	discoveryDNSSRV = "dns-srv"
+
+	defaultDiscoveryInterval = 30 * time.Second
+	discoveryTimeout         = 5 * time.Second
+)
+
+// lookupSRV resolves SRV records; tests replace it
+var lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+	_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	return addrs, err
+}
+
+// discovers reports whether the service finds its targets through DNS SRV
+func (s ServiceConfig) discovers() bool {
+	return s.Discovery == discoveryDNSSRV
+}
+
+// validateDiscovery checks the discovery settings. With dns-srv the host of
+// target_url is the SRV name, so the targets cannot be listed or weighted.
+func (s ServiceConfig) validateDiscovery() error {
+	switch s.Discovery {
+	case "":
+		if s.DiscoveryInterval != "" {
+			return errors.New("discovery_interval needs discovery")
+		}
+		return nil
+	case discoveryDNSSRV:
+	default:
+		return fmt.Errorf("discovery must be %q, got %q", discoveryDNSSRV, s.Discovery)
+	}
+	if s.TargetURL == "" || len(s.TargetURLs) > 0 {
+		return errors.New("discovery needs target_url and no target_urls")
+	}
+	if len(s.TargetWeights) > 0 {
+		return errors.New("discovery cannot be combined with target_weights")
+	}
+	_, err := s.discoveryInterval()
+	return err
+}
+
+// discoveryInterval parses discovery_interval, defaulting to 30s
+func (s ServiceConfig) discoveryInterval() (time.Duration, error) {
+	if s.DiscoveryInterval == "" {
+		return defaultDiscoveryInterval, nil
+	}
+	d, err := time.ParseDuration(s.DiscoveryInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid discovery_interval: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("discovery_interval must be positive, got %s", s.DiscoveryInterval)
+	}
+	return d, nil
+}
+
+// srvDiscovery keeps a balancer's upstreams in line with the SRV records
+// named by the host of target_url. The scheme and path of target_url are
+// used for every resolved host:port.
+type srvDiscovery struct {
+	service  string
+	target   *url.URL
+	lb       *balancer
+	interval time.Duration
+	// trigger asks run for a resolve before the next tick
+	trigger chan struct{}
+}
+
+func newSRVDiscovery(s ServiceConfig, lb *balancer) (*srvDiscovery, error) {
+	target, err := url.Parse(s.TargetURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target url: %w", err)
+	}
+	interval, err := s.discoveryInterval()
+	if err != nil {
+		return nil, err
+	}
+	return &srvDiscovery{
+		service:  s.Name,
+		target:   target,
+		lb:       lb,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+	}, nil
+}
+
+// resolve looks up the SRV records and hands the targets to the balancer.
+// On failure, or when no records come back, the last known targets stay.
+func (d *srvDiscovery) resolve(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	records, err := lookupSRV(ctx, d.target.Hostname())
+	if err == nil && len(records) == 0 {
+		err = errors.New("no records")
+	}
+	if err != nil {
+		logger.Warn("service discovery failed, keeping last known targets", "service", d.service, "name", d.target.Hostname(), "error", err)
+		return err
+	}
+	targets := make([]string, 0, len(records))
+	for _, rec := range records {
+		u := *d.target
+		u.Host = net.JoinHostPort(strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port)))
+		targets = append(targets, u.String())
+	}
+	// a stable order keeps round-robin from jumping on every resolve
+	sort.Strings(targets)
+	if err := d.lb.setTargets(targets); err != nil {
+		return err
+	}
+	logger.Debug("service discovery resolved", "service", d.service, "targets", targets)
+	return nil
+}
+
+// refresh asks for a resolve without waiting for it
+func (d *srvDiscovery) refresh() {
+	if d == nil {
+		return
+	}
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// run resolves right away, then again every interval and when refresh is
+// called, until ctx is done. Resolving here rather than in newProxy keeps a
+// slow DNS server from holding up startup and reloads.
+func (d *srvDiscovery) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.resolve(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.trigger:
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSRV serves lookupSRV from a set of records the test can swap
+type fakeSRV struct {
+	mu      sync.Mutex
+	records []*net.SRV
+	err     error
+	names   []string
+}
+
+func (f *fakeSRV) set(records []*net.SRV, err error) {
+	f.mu.Lock()
+	f.records, f.err = records, err
+	f.mu.Unlock()
+}
+
+func stubSRV(t *testing.T) *fakeSRV {
+	f := &fakeSRV{}
+	orig := lookupSRV
+	lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.names = append(f.names, name)
+		return f.records, f.err
+	}
+	t.Cleanup(func() { lookupSRV = orig })
+	return f
+}
+
+// srvRecord points an SRV record at the server at rawURL
+func srvRecord(t *testing.T, rawURL string) *net.SRV {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &net.SRV{Target: u.Hostname() + ".", Port: uint16(port)}
+}
+
+// waitForTargets waits until the balancer's only target is rawURL
+func waitForTargets(t *testing.T, lb *balancer, rawURL string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ups := lb.current()
+		if len(ups) == 1 && ups[0].url.String() == rawURL {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("targets were not resolved to %s", rawURL)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSRVDiscovery(t *testing.T) {
+	servers := make([]*httptest.Server, 2)
+	for i := range servers {
+		name := strconv.Itoa(i)
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+		defer servers[i].Close()
+	}
+	srv := stubSRV(t)
+	srv.set([]*net.SRV{srvRecord(t, servers[0].URL), srvRecord(t, servers[1].URL)}, nil)
+
+	p, err := newProxy(ServiceConfig{
+		Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://_orders._tcp.example.internal", Discovery: discoveryDNSSRV,
+	}, ServerConfig{}, http.DefaultTransport.(*http.Transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.discovery.resolve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.names) != 1 || srv.names[0] != "_orders._tcp.example.internal" {
+		t.Fatalf("looked up %v", srv.names)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		rw := httptest.NewRecorder()
+		p.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("got %d", rw.Code)
+		}
+		seen[rw.Header().Get("Upstream")] = true
+	}
+	if !seen["0"] || !seen["1"] {
+		t.Fatalf("expected both targets to serve, got %v", seen)
+	}
+
+	// a failed resolve keeps the last known targets
+	srv.set(nil, errors.New("no such host"))
+	if err := p.discovery.resolve(context.Background()); err == nil {
+		t.Fatal("expected resolve error")
+	}
+	if n := len(p.lb.current()); n != 2 {
+		t.Fatalf("expected 2 targets kept, got %d", n)
+	}
+
+	// targets that are still listed keep their health
+	var kept *upstream
+	for _, u := range p.lb.current() {
+		if u.url.String() == servers[1].URL {
+			kept = u
+		}
+	}
+	kept.markFailed()
+	srv.set([]*net.SRV{srvRecord(t, servers[1].URL)}, nil)
+	if err := p.discovery.resolve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ups := p.lb.current(); len(ups) != 1 || ups[0] != kept || !kept.recentlyFailed(time.Now()) {
+		t.Fatalf("expected the remaining upstream to be reused, got %v", ups)
+	}
+}
+
+func TestSRVDiscoveryRefreshesWhenTargetsFail(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close()
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer live.Close()
+
+	srv := stubSRV(t)
+	srv.set([]*net.SRV{srvRecord(t, deadURL)}, nil)
+	p, err := newProxy(ServiceConfig{
+		Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://_orders._tcp.example.internal",
+		Discovery: discoveryDNSSRV, DiscoveryInterval: "1h",
+	}, ServerConfig{}, http.DefaultTransport.(*http.Transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.discovery.run(ctx)
+	waitForTargets(t, p.lb, deadURL)
+
+	srv.set([]*net.SRV{srvRecord(t, live.URL)}, nil)
+	rw := httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+	if rw.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 from the dead target, got %d", rw.Code)
+	}
+
+	waitForTargets(t, p.lb, live.URL)
+	rw = httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d after re-resolve", rw.Code)
+	}
+}
+
+func TestSRVDiscoveryUnresolvedAtStart(t *testing.T) {
+	srv := stubSRV(t)
+	srv.set(nil, errors.New("no such host"))
+	p, err := newProxy(ServiceConfig{
+		Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://_orders._tcp.example.internal", Discovery: discoveryDNSSRV,
+	}, ServerConfig{}, http.DefaultTransport.(*http.Transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rw.Code)
+	}
+}
+
+func TestSRVDiscoveryDoesNotBlockBuild(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	release := make(chan struct{})
+	orig := lookupSRV
+	lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		select {
+		case <-release:
+			return []*net.SRV{srvRecord(t, upstream.URL)}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	t.Cleanup(func() { lookupSRV = orig })
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{{
+			Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://_orders._tcp.example.internal", Discovery: discoveryDNSSRV,
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	built := make(chan http.Handler, 1)
+	go func() {
+		r, err := buildRouter(ctx, cfg)
+		if err != nil {
+			t.Error(err)
+		}
+		built <- r
+	}()
+	var r http.Handler
+	select {
+	case r = <-built:
+	case <-time.After(time.Second):
+		t.Fatal("buildRouter waited for the SRV lookup")
+	}
+	if r == nil {
+		t.FailNow()
+	}
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the first resolve, got %d", rw.Code)
+	}
+
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+		if rw.Code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still %d after the lookup finished", rw.Code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadConfigInvalidDiscovery(t *testing.T) {
+	tests := map[string]string{
+		"unknown mode":     `discovery: "consul"`,
+		"target urls":      "discovery: dns-srv\n    target_urls: [\"http://a:80\"]",
+		"target weights":   "discovery: dns-srv\n    target_weights: [1]",
+		"bad interval":     "discovery: dns-srv\n    discovery_interval: \"0s\"",
+		"interval without": `discovery_interval: "10s"`,
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://_orders._tcp.example.internal"
+    `+extra+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid discovery")
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	errorFormatJSON    = "json"
+	errorFormatPlain   = "plain"
+	errorFormatProblem = "problem"
+)
+
+// errorFormatKey holds the server's error_format in the request context
+type errorFormatKey struct{}
+
+func validateErrorFormat(format string) error {
+	switch format {
+	case "", errorFormatJSON, errorFormatPlain, errorFormatProblem:
+		return nil
+	}
+	return fmt.Errorf("invalid error_format %q, want %q, %q or %q", format, errorFormatJSON, errorFormatPlain, errorFormatProblem)
+}
+
+// errorFormat makes the configured error_format known to writeError
+func errorFormat(format string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), errorFormatKey{}, format)))
+		})
+	}
+}
+
+// errorCode turns a status into a stable machine-readable code, e.g.
+// "too_many_requests" for 429
+func errorCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// problemDetails is an RFC 7807 problem, with code and request_id as
+// extension members
+type problemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError writes every gateway-generated error in the server's
+// error_format: {"error": {"code", "message", "request_id"}} by default,
+// application/problem+json for problem, or plain text
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	format, _ := r.Context().Value(errorFormatKey{}).(string)
+	if format == errorFormatPlain {
+		http.Error(w, message, status)
+		return
+	}
+	var body interface{} = errorBody{errorDetail{
+		Code:      errorCode(status),
+		Message:   message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}}
+	contentType := "application/json"
+	if format == errorFormatProblem {
+		body = problemDetails{
+			Type:      "about:blank",
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    message,
+			Instance:  r.URL.Path,
+			Code:      errorCode(status),
+			RequestID: middleware.GetReqID(r.Context()),
+		}
+		contentType = "application/problem+json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// notFound and methodNotAllowed answer requests no route matches
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "no route for "+r.URL.Path)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// setRetryAfter sets Retry-After to wait rounded up to whole seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
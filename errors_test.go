@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// decodeError parses a gateway error body written by writeError
+func decodeError(t *testing.T, rw *httptest.ResponseRecorder) errorDetail {
+	t.Helper()
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected error Content-Type %q", ct)
+	}
+	var body errorBody
+	if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Error
+}
+
+func TestJSONErrors(t *testing.T) {
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: "http://127.0.0.1:1", AuthRequired: true},
+			{Name: "down", PathPrefix: "/api/down", TargetURL: "http://127.0.0.1:1"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/api/private/x", http.StatusUnauthorized, "unauthorized"},
+		{"GET", "/api/down/x", http.StatusBadGateway, "bad_gateway"},
+		{"GET", "/nowhere", http.StatusNotFound, "not_found"},
+		{"POST", "/healthz", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if rw.Code != tt.status {
+			t.Fatalf("%s %s: got %d want %d", tt.method, tt.path, rw.Code, tt.status)
+		}
+		e := decodeError(t, rw)
+		if e.Code != tt.code || e.Message == "" {
+			t.Errorf("%s %s: unexpected error %+v", tt.method, tt.path, e)
+		}
+		if e.RequestID == "" || e.RequestID != rw.Header().Get(defaultRequestIDHeader) {
+			t.Errorf("%s %s: request_id %q does not match the response header %q", tt.method, tt.path, e.RequestID, rw.Header().Get(defaultRequestIDHeader))
+		}
+	}
+}
+
+func TestPlainErrors(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{ErrorFormat: errorFormatPlain},
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: "http://127.0.0.1:1", AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/private/x", nil))
+	if rw.Code != http.StatusUnauthorized || rw.Body.String() != "Missing Authorization Header\n" {
+		t.Fatalf("expected the plain text error, got %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestProblemErrors(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{ErrorFormat: errorFormatProblem},
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: "http://127.0.0.1:1", AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/private/x", nil))
+	if rw.Code != http.StatusUnauthorized || rw.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("unexpected response %d %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+	var p problemDetails
+	if err := json.NewDecoder(rw.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	want := problemDetails{
+		Type: "about:blank", Title: "Unauthorized", Status: http.StatusUnauthorized,
+		Detail: "Missing Authorization Header", Instance: "/api/private/x", Code: "unauthorized",
+		RequestID: rw.Header().Get(defaultRequestIDHeader),
+	}
+	if p != want {
+		t.Fatalf("got %+v want %+v", p, want)
+	}
+}
+
+// roundTripFunc is a fake transport
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestProxyErrorStatus(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	tests := []struct {
+		name    string
+		err     error
+		code    int
+		message string
+	}{
+		{"timeout", fmt.Errorf("read: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "upstream service orders timed out"},
+		{"connection refused", refused, http.StatusBadGateway, "upstream service unavailable"},
+		{"other", io.ErrUnexpectedEOF, http.StatusBadGateway, "upstream service error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newProxy(ServiceConfig{Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://orders:8080"},
+				ServerConfig{}, http.DefaultTransport.(*http.Transport))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.proxy.Transport = roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, tt.err })
+
+			rw := httptest.NewRecorder()
+			p.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+			if rw.Code != tt.code {
+				t.Fatalf("got %d want %d", rw.Code, tt.code)
+			}
+			if got := decodeError(t, rw); got.Message != tt.message {
+				t.Errorf("message %q want %q", got.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidErrorFormat(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  error_format: "xml"
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for unknown error_format")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+)
+
+const fallbackHeader = "X-Gateway-Fallback"
+
+// validateFallback checks fallback_url and fallback_on_status. The fallback
+// gets the same path the primary would, so its url carries no path.
+func (s ServiceConfig) validateFallback() error {
+	if s.FallbackURL == "" {
+		if len(s.FallbackOnStatus) > 0 {
+			return errors.New("fallback_on_status needs fallback_url")
+		}
+		return nil
+	}
+	if err := checkTargetURL(s.FallbackURL); err != nil {
+		return fmt.Errorf("fallback_url: %w", err)
+	}
+	if u, _ := url.Parse(s.FallbackURL); u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("fallback_url %q must not have a path", s.FallbackURL)
+	}
+	for _, code := range s.FallbackOnStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("fallback_on_status: invalid status code %d", code)
+		}
+	}
+	return nil
+}
+
+// fallbackTransport sends a request once more to the fallback when the
+// primary could not be reached or answered with one of statuses. Requests
+// whose body is too large to buffer go to the primary only.
+type fallbackTransport struct {
+	base     http.RoundTripper
+	service  string
+	fallback *url.URL
+	statuses []int
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isWebSocketUpgrade(req.Header) {
+		return t.base.RoundTrip(req)
+	}
+	req, ok := bufferBody(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if !t.shouldFallback(resp, err) {
+		return resp, err
+	}
+	if resp != nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	logger.Warn("primary upstream failed, using fallback", "service", t.service, "upstream", req.URL.Host, "fallback", t.fallback.Host, "path", req.URL.Path, "err", err, "status", statusOf(resp))
+
+	freq := req.Clone(req.Context())
+	freq.URL.Scheme = t.fallback.Scheme
+	freq.URL.Host = t.fallback.Host
+	freq.Host = t.fallback.Host
+	if req.GetBody != nil {
+		if freq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err = t.base.RoundTrip(freq)
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Set(fallbackHeader, "true")
+	return resp, nil
+}
+
+func (t *fallbackTransport) shouldFallback(resp *http.Response, err error) bool {
+	if err != nil {
+		return isDialError(err)
+	}
+	for _, s := range t.statuses {
+		if resp.StatusCode == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isDialError matches failures to connect at all, after which the primary
+// cannot have seen the request
+func isDialError(err error) bool {
+	if isTimeout(err) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFallbackURL(t *testing.T) {
+	var primaryStatus int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(primaryStatus)
+	}))
+	defer primary.Close()
+	var gotBody string
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Write([]byte("replica " + r.URL.Path))
+	}))
+	defer fallback.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "search", PathPrefix: "/api/search", TargetURL: primary.URL, FallbackURL: fallback.URL},
+			{Name: "down", PathPrefix: "/api/down", TargetURL: "http://127.0.0.1:1", FallbackURL: fallback.URL},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+		wantFallback             bool
+	}{
+		{"primary ok", "GET", "/api/search/q", "", http.StatusOK, false},
+		{"primary 503", "POST", "/api/search/q", `{"q":"shoes"}`, http.StatusServiceUnavailable, true},
+		{"primary 404", "GET", "/api/search/q", "", http.StatusNotFound, false},
+		{"primary unreachable", "GET", "/api/down/q", "", 0, true},
+		{"body too large", "POST", "/api/search/q", strings.Repeat("x", maxRetryBodyBytes+1), http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryStatus, gotBody = tt.status, ""
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if got := rw.Header().Get(fallbackHeader) == "true"; got != tt.wantFallback {
+				t.Fatalf("fallback used = %v want %v (status %d)", got, tt.wantFallback, rw.Code)
+			}
+			if !tt.wantFallback {
+				if tt.status != 0 && rw.Code != tt.status {
+					t.Fatalf("expected the primary's %d, got %d", tt.status, rw.Code)
+				}
+				return
+			}
+			if rw.Code != http.StatusOK || !strings.HasPrefix(rw.Body.String(), "replica /api/") {
+				t.Fatalf("expected the replica's answer, got %d %q", rw.Code, rw.Body.String())
+			}
+			if gotBody != tt.body {
+				t.Fatalf("fallback got body %q want %q", gotBody, tt.body)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidFallback(t *testing.T) {
+	tests := map[string]string{
+		"bad scheme":         `fallback_url: "ftp://replica"`,
+		"path":               `fallback_url: "http://replica/search"`,
+		"status without url": `fallback_on_status: [503]`,
+		"bad status":         "fallback_url: \"http://replica\"\n    fallback_on_status: [42]",
+	}
+	for name, field := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "search"
+    path_prefix: "/api/search"
+    target_url: "http://search:8080"
+    `+field+`
+`)
+			if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "service search") {
+				t.Fatalf("expected error naming the service, got %v", err)
+			}
+		})
+	}
+}
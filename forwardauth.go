@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultForwardAuthTimeout = 5 * time.Second
+
+// hopHeaders apply to a single connection and are not passed between the
+// client, the gateway and the forward auth service
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func (s ServiceConfig) forwardAuthTimeout() (time.Duration, error) {
+	if s.ForwardAuthTimeout == "" {
+		return defaultForwardAuthTimeout, nil
+	}
+	d, err := time.ParseDuration(s.ForwardAuthTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("auth: invalid forward_auth_timeout %q", s.ForwardAuthTimeout)
+	}
+	return d, nil
+}
+
+// validateForwardAuth checks the settings of auth: forward. Roles and scopes
+// are left to the auth service, which sees the whole request.
+func (s ServiceConfig) validateForwardAuth() error {
+	if s.ForwardAuthURL == "" {
+		return errors.New("auth: forward needs forward_auth_url")
+	}
+	u, err := url.Parse(s.ForwardAuthURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("auth: forward_auth_url must be an absolute http(s) URL, got %q", s.ForwardAuthURL)
+	}
+	if len(s.RequiredRoles) > 0 || len(s.RequiredScopes) > 0 || len(s.WriteScopes) > 0 {
+		return errors.New("auth: forward leaves roles and scopes to the auth service; drop required_roles, required_scopes and write_scopes")
+	}
+	// the cache can't tell which requests the auth service judged by
+	// credentials, so it might serve one user's response to another
+	if s.Cache != nil {
+		return errors.New("auth: forward can't be combined with cache")
+	}
+	_, err = s.forwardAuthTimeout()
+	return err
+}
+
+// forwardAuthMiddleware asks the service's forward_auth_url about every
+// request, sending its method, URI and headers but not its body. A 2xx answer
+// lets the request through with the copy_headers taken from the answer;
+// headers it leaves out are removed so clients can't supply them. Any other
+// answer, redirects included, is relayed to the client as is.
+func forwardAuthMiddleware(s ServiceConfig) (func(http.Handler) http.Handler, error) {
+	if err := s.validateForwardAuth(); err != nil {
+		return nil, err
+	}
+	timeout, _ := s.forwardAuthTimeout()
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp, err := checkForwardAuth(client, s.ForwardAuthURL, r)
+			if err != nil {
+				logger.Error("forward auth failed", "service", s.Name, "url", s.ForwardAuthURL, "err", err)
+				writeError(w, r, http.StatusServiceUnavailable, "forward auth unavailable")
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				logger.Warn("forward auth denied request", "service", s.Name, "status", resp.StatusCode, "path", r.URL.Path)
+				for k, v := range resp.Header {
+					w.Header()[k] = v
+				}
+				for _, h := range hopHeaders {
+					w.Header().Del(h)
+				}
+				w.WriteHeader(resp.StatusCode)
+				io.Copy(w, resp.Body)
+				return
+			}
+			for _, h := range s.CopyHeaders {
+				r.Header.Del(h)
+				for _, v := range resp.Header.Values(h) {
+					r.Header.Add(h, v)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// checkForwardAuth sends the check for r in the style of Traefik's forward
+// auth: the original headers plus X-Forwarded-Method, -Uri, -Host, -Proto and
+// -For describing the request
+func checkForwardAuth(client *http.Client, authURL string, r *http.Request) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, authURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Del("Content-Length")
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	req.Header.Set("X-Forwarded-For", clientIP(r))
+	return client.Do(req)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardAuth(t *testing.T) {
+	var checked http.Header
+	authSvc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checked = r.Header.Clone()
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			w.Header().Set("X-User-Id", "42")
+			w.Header().Set("X-Tenant", "acme")
+		case "Bearer other":
+			http.Error(w, "wrong method", http.StatusMethodNotAllowed)
+		case "":
+			w.Header().Set("Location", "https://login.example.com/?rd=/api/reports/x")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="reports"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"login_url": "https://login.example.com"}`))
+		}
+	}))
+	defer authSvc.Close()
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Services: []ServiceConfig{{
+			Name: "reports", PathPrefix: "/api/reports", TargetURL: upstream.URL, AuthRequired: true,
+			Auth: authForward, ForwardAuthURL: authSvc.URL + "/check", CopyHeaders: []string{"X-User-Id", "X-Tenant"},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+	send := func(token string) *httptest.ResponseRecorder {
+		got = nil
+		req := httptest.NewRequest("DELETE", "/api/reports/x?full=1", nil)
+		req.Header.Set("X-Tenant", "forged")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := send("good")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d want %d", rw.Code, http.StatusOK)
+	}
+	if checked.Get("X-Forwarded-Method") != "DELETE" || checked.Get("X-Forwarded-Uri") != "/api/reports/x?full=1" || checked.Get("Authorization") != "Bearer good" {
+		t.Errorf("auth service got method %q, uri %q, authorization %q", checked.Get("X-Forwarded-Method"), checked.Get("X-Forwarded-Uri"), checked.Get("Authorization"))
+	}
+	if got.Get("X-User-Id") != "42" || got.Get("X-Tenant") != "acme" {
+		t.Errorf("upstream got X-User-Id %q, X-Tenant %q", got.Get("X-User-Id"), got.Get("X-Tenant"))
+	}
+
+	rw = send("bad")
+	if rw.Code != http.StatusUnauthorized || got != nil {
+		t.Fatalf("denied: got %d, upstream called %v", rw.Code, got != nil)
+	}
+	if rw.Header().Get("WWW-Authenticate") != `Bearer realm="reports"` || rw.Body.String() != `{"login_url": "https://login.example.com"}` {
+		t.Errorf("expected the denial relayed as is, got %q %s", rw.Header().Get("WWW-Authenticate"), rw.Body)
+	}
+
+	// redirects to a login page reach the client instead of being followed
+	rw = send("")
+	if rw.Code != http.StatusFound || rw.Header().Get("Location") != "https://login.example.com/?rd=/api/reports/x" {
+		t.Fatalf("redirect: got %d to %q", rw.Code, rw.Header().Get("Location"))
+	}
+	if rw = send("other"); rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got %d want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+
+	authSvc.Close()
+	if rw = send("good"); rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("auth service down: got %d want %d", rw.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestLoadConfigInvalidForwardAuth(t *testing.T) {
+	tests := map[string]string{
+		"no url":        "auth_required: true\n    auth: forward",
+		"relative url":  "auth_required: true\n    auth: forward\n    forward_auth_url: \"/check\"",
+		"bad timeout":   "auth_required: true\n    auth: forward\n    forward_auth_url: \"http://auth:8080/check\"\n    forward_auth_timeout: \"soon\"",
+		"no auth":       "auth: forward\n    forward_auth_url: \"http://auth:8080/check\"",
+		"with roles":    "auth_required: true\n    auth: forward\n    forward_auth_url: \"http://auth:8080/check\"\n    required_roles: [\"admin\"]",
+		"with cache":    "auth_required: true\n    auth: forward\n    forward_auth_url: \"http://auth:8080/check\"\n    cache: {ttl: \"1m\"}",
+		"unknown mode":  "auth_required: true\n    auth: forwarded",
+		"zero timeout":  "auth_required: true\n    auth: forward\n    forward_auth_url: \"http://auth:8080/check\"\n    forward_auth_timeout: \"0s\"",
+		"no scheme url": "auth_required: true\n    auth: forward\n    forward_auth_url: \"auth:8080/check\"",
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "reports"
+    path_prefix: "/api/reports"
+    target_url: "http://reports:8080"
+    `+extra+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid forward auth")
+			}
+		})
+	}
+}
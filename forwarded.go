@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// forwardedHeaders are set by proxies in front of the gateway to describe
+// the original request
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+// trustForwardedHeaders reports whether forwarded headers sent by clients are
+// kept; it defaults to true
+func (c ServerConfig) trustForwardedHeaders() bool {
+	return c.TrustForwardedHeaders == nil || *c.TrustForwardedHeaders
+}
+
+// clientIPFromHeaders reports whether the client address is taken from
+// X-Real-IP or X-Forwarded-For. That needs trust_forwarded_headers set to
+// true explicitly, since whoever sets them can pick the address allow_ips,
+// deny_ips and the rate limits see.
+func (c ServerConfig) clientIPFromHeaders() bool {
+	return c.TrustForwardedHeaders != nil && *c.TrustForwardedHeaders
+}
+
+type peerAddrKey struct{}
+
+// forwarded remembers the address of the connection's peer before
+// middleware.RealIP replaces it, so the proxy appends the real hop to
+// X-Forwarded-For. Unless trusted, forwarded headers from the client are
+// dropped, which also keeps RealIP from taking the client IP from them.
+func forwarded(trust bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !trust {
+				for _, h := range forwardedHeaders {
+					r.Header.Del(h)
+				}
+			}
+			ctx := context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// restorePeerAddr puts back the peer address saved by forwarded, which the
+// reverse proxy appends to X-Forwarded-For
+func restorePeerAddr(r *http.Request) {
+	if addr, ok := r.Context().Value(peerAddrKey{}).(string); ok {
+		r.RemoteAddr = addr
+	}
+}
+
+// setForwardedHeaders describes the original request to the upstream. It
+// runs before the Host is rewritten; values set by a trusted proxy in front
+// of the gateway are kept.
+func setForwardedHeaders(req *http.Request) {
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name             string
+		trust            bool
+		in               map[string]string
+		xff, proto, host string
+	}{
+		{
+			name: "no incoming headers", trust: true,
+			xff: "192.0.2.1", proto: "http", host: "gateway.example.com",
+		},
+		{
+			name: "trusted", trust: true,
+			in:  map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "shop.example.com"},
+			xff: "203.0.113.7, 192.0.2.1", proto: "https", host: "shop.example.com",
+		},
+		{
+			name: "reset", trust: false,
+			in:  map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "shop.example.com"},
+			xff: "192.0.2.1", proto: "http", host: "gateway.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trust := tt.trust
+			cfg := &Config{
+				JWTSecret: "dummy",
+				Server:    ServerConfig{TrustForwardedHeaders: &trust},
+				Services:  []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL}},
+			}
+			r := mustBuildRouter(t, cfg)
+
+			req := httptest.NewRequest("GET", "http://gateway.example.com/api/orders/1", nil)
+			req.RemoteAddr = "192.0.2.1:4711"
+			for k, v := range tt.in {
+				req.Header.Set(k, v)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != http.StatusOK {
+				t.Fatalf("got %d", rw.Code)
+			}
+			if v := got.Get("X-Forwarded-For"); v != tt.xff {
+				t.Errorf("X-Forwarded-For = %q, want %q", v, tt.xff)
+			}
+			if v := got.Get("X-Forwarded-Proto"); v != tt.proto {
+				t.Errorf("X-Forwarded-Proto = %q, want %q", v, tt.proto)
+			}
+			if v := got.Get("X-Forwarded-Host"); v != tt.host {
+				t.Errorf("X-Forwarded-Host = %q, want %q", v, tt.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const grpcContentType = "application/grpc"
+
+// isGRPC reports whether r is a gRPC call, whose content type is
+// application/grpc or a subtype such as application/grpc+proto
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType)
+}
+
+func (s ServiceConfig) validateGRPC() error {
+	if !s.GRPC {
+		return nil
+	}
+	if s.WebSocket {
+		return errors.New("grpc and websocket cannot both be set")
+	}
+	// gRPC streams can't be buffered for a replay
+	if s.FallbackURL != "" {
+		return errors.New("fallback_url is not supported with grpc")
+	}
+	return nil
+}
+
+// grpcTransport speaks HTTP/2 to gRPC upstreams: h2c for http targets and
+// HTTP/2 over TLS for https ones. Trailers, which carry grpc-status, are
+// passed through by the ReverseProxy.
+type grpcTransport struct {
+	h2c, h2 *http2.Transport
+}
+
+func newGRPCTransport(tlsConfig *tls.Config) *grpcTransport {
+	return &grpcTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		h2: &http2.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
+func (t *grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		return t.h2.RoundTrip(req)
+	}
+	return t.h2c.RoundTrip(req)
+}
+
+// serveH2C lets clients speak HTTP/2 without TLS, as gRPC clients do over
+// plaintext; HTTP/1 requests pass through unchanged
+func serveH2C(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// rawCodec passes messages through as bytes so the echo server needs no
+// generated code
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error)      { return *v.(*[]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v interface{}) error { *v.(*[]byte) = data; return nil }
+func (rawCodec) Name() string                               { return "raw" }
+
+// startEchoServer serves every method by echoing the request message back,
+// reporting the caller's x-user-id in a trailer
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		stream.SetTrailer(metadata.Pairs("seen-user-id", strings.Join(md.Get("x-user-id"), ",")))
+		return stream.SendMsg(&msg)
+	}))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestGRPCProxy(t *testing.T) {
+	addr := startEchoServer(t)
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "echo", PathPrefix: "/echo.Echo", TargetURL: "http://" + addr, GRPC: true, AuthRequired: true},
+		},
+	}
+	gateway := httptest.NewServer(serveH2C(mustBuildRouter(t, cfg)))
+	defer gateway.Close()
+
+	conn, err := grpc.Dial(strings.TrimPrefix(gateway.URL, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	in, out := []byte("hello"), []byte(nil)
+	err = conn.Invoke(ctx, "/echo.Echo/Say", &in, &out)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token, got %v", err)
+	}
+
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"sub": "42"}))
+	var trailer metadata.MD
+	if err := conn.Invoke(authed, "/echo.Echo/Say", &in, &out, grpc.Trailer(&trailer)); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello" {
+		t.Fatalf("unexpected echo %q", out)
+	}
+	if got := trailer.Get("seen-user-id"); len(got) != 1 || got[0] != "42" {
+		t.Fatalf("expected the user id as metadata and the trailer passed back, got %v", trailer)
+	}
+}
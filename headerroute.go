@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// HeaderRoutesConfig lets callers holding role send a request to an alternate
+// target by naming it in header, e.g. X-Env: staging-pr-42
+type HeaderRoutesConfig struct {
+	Header string            `yaml:"header"`
+	Role   string            `yaml:"role"`
+	Routes map[string]string `yaml:"routes"`
+}
+
+func (c *HeaderRoutesConfig) validate() error {
+	if c.Header == "" {
+		return errors.New("header_routes: header must be set")
+	}
+	if c.Role == "" {
+		return errors.New("header_routes: role must be set")
+	}
+	if len(c.Routes) == 0 {
+		return errors.New("header_routes: routes must not be empty")
+	}
+	for value, target := range c.Routes {
+		if _, err := url.Parse(target); err != nil || target == "" {
+			return fmt.Errorf("header_routes: invalid target for %q: %q", value, target)
+		}
+	}
+	return nil
+}
+
+// headerRoutes resolves the alternate upstream a request asks for
+type headerRoutes struct {
+	header     string
+	role       string
+	rolesClaim string
+	upstreams  map[string]*upstream
+}
+
+func newHeaderRoutes(c HeaderRoutesConfig, rolesClaim string) (*headerRoutes, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	hr := &headerRoutes{
+		header:     c.Header,
+		role:       c.Role,
+		rolesClaim: rolesClaim,
+		upstreams:  make(map[string]*upstream, len(c.Routes)),
+	}
+	for value, target := range c.Routes {
+		u, err := newUpstream(target)
+		if err != nil {
+			return nil, fmt.Errorf("header_routes: %w", err)
+		}
+		hr.upstreams[value] = u
+	}
+	return hr, nil
+}
+
+// match returns the upstream named by the request's header, or nil when the
+// header is absent, unknown or the caller lacks the role; such requests go
+// to the service's normal upstreams
+func (hr *headerRoutes) match(r *http.Request) *upstream {
+	value := r.Header.Get(hr.header)
+	if value == "" {
+		return nil
+	}
+	u, ok := hr.upstreams[value]
+	if !ok {
+		return nil
+	}
+	claims, _ := r.Context().Value(userClaimsKey).(jwt.MapClaims)
+	for _, role := range claimRoles(claims, hr.rolesClaim) {
+		if role == hr.role {
+			return u
+		}
+	}
+	logger.WarnContext(r.Context(), "header route ignored, caller lacks role", "header", hr.header, "value", value, "role", hr.role, "sub", tokenSubject(r))
+	return nil
+}
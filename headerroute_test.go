@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestHeaderRoutes(t *testing.T) {
+	backends := map[string]string{}
+	for _, name := range []string{"main", "pr-42"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+		defer srv.Close()
+		backends[name] = srv.URL
+	}
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{
+				Name: "orders", PathPrefix: "/api/orders", TargetURL: backends["main"], AuthRequired: true,
+				HeaderRoutes: &HeaderRoutesConfig{
+					Header: "X-Env",
+					Role:   "qa",
+					Routes: map[string]string{"staging-pr-42": backends["pr-42"]},
+				},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name  string
+		roles []interface{}
+		env   string
+		want  string
+	}{
+		{"qa with known value", []interface{}{"qa"}, "staging-pr-42", "pr-42"},
+		{"qa with unknown value", []interface{}{"qa"}, "staging-pr-7", "main"},
+		{"qa without header", []interface{}{"qa"}, "", "main"},
+		{"user without role", []interface{}{"user"}, "staging-pr-42", "main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/orders/x", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"sub": "42", "roles": tt.roles}))
+			if tt.env != "" {
+				req.Header.Set("X-Env", tt.env)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d", rw.Code)
+			}
+			if got := rw.Header().Get("Upstream"); got != tt.want {
+				t.Fatalf("unexpected upstream: got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigHeaderRoutes(t *testing.T) {
+	tests := map[string]string{
+		"without auth": `
+    header_routes:
+      header: "X-Env"
+      role: "qa"
+      routes: {"staging": "http://staging:8080"}
+`,
+		"without role": `
+    auth_required: true
+    header_routes:
+      header: "X-Env"
+      routes: {"staging": "http://staging:8080"}
+`,
+	}
+	for name, routes := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    env_var: "TEST_HEADER_ROUTES_SERVICE_URL"
+`+routes)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid header_routes")
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// validateHeaderNames rejects names that cannot appear in an HTTP header
+func validateHeaderNames(field string, headers map[string]string) error {
+	for name := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("%s: invalid header name %q", field, name)
+		}
+	}
+	return nil
+}
+
+// responseHeaders merges the server's default_response_headers with the
+// service's add_response_headers, the service winning per header. An empty
+// value in add_response_headers drops a default.
+func (s ServiceConfig) responseHeaders(server ServerConfig) http.Header {
+	h := http.Header{}
+	for name, value := range server.DefaultResponseHeaders {
+		h.Set(name, value)
+	}
+	for name, value := range s.AddResponseHeaders {
+		if value == "" {
+			h.Del(name)
+			continue
+		}
+		h.Set(name, value)
+	}
+	return h
+}
+
+// setResponseHeaders adds headers to an upstream response. Headers the
+// upstream already sent are kept unless override is set.
+func setResponseHeaders(dst, headers http.Header, override bool) {
+	for name, values := range headers {
+		if !override && dst.Get(name) != "" {
+			continue
+		}
+		dst[name] = values
+	}
+}
+
+// RequestHeadersConfig edits the headers of requests sent to the upstream
+type RequestHeadersConfig struct {
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+	Remove []string          `yaml:"remove"`
+}
+
+// requestHeaderEdits is a validated RequestHeadersConfig
+type requestHeaderEdits struct {
+	set, add http.Header
+	remove   []string
+}
+
+// edits validates the config; a nil config yields nil edits
+func (c *RequestHeadersConfig) edits() (*requestHeaderEdits, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if err := validateHeaderNames("request_headers.set", c.Set); err != nil {
+		return nil, err
+	}
+	if err := validateHeaderNames("request_headers.add", c.Add); err != nil {
+		return nil, err
+	}
+	e := &requestHeaderEdits{set: http.Header{}, add: http.Header{}, remove: c.Remove}
+	for _, m := range []struct {
+		values map[string]string
+		dst    http.Header
+	}{{c.Set, e.set}, {c.Add, e.add}} {
+		for name, value := range m.values {
+			m.dst.Add(name, value)
+		}
+	}
+	for _, name := range c.Remove {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("request_headers.remove: invalid header name %q", name)
+		}
+	}
+	return e, nil
+}
+
+// apply removes, then sets, then adds headers
+func (e *requestHeaderEdits) apply(h http.Header) {
+	for _, name := range e.remove {
+		h.Del(name)
+	}
+	for name, values := range e.set {
+		h[name] = append([]string(nil), values...)
+	}
+	for name, values := range e.add {
+		h[name] = append(h[name], values...)
+	}
+}
+
+// ResponseHeadersConfig edits the headers of upstream responses, e.g. to hide
+// Server or X-Powered-By or to fill in a missing Cache-Control
+type ResponseHeadersConfig struct {
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
+}
+
+func (c *ResponseHeadersConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if err := validateHeaderNames("response_headers.set", c.Set); err != nil {
+		return err
+	}
+	for _, name := range c.Remove {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("response_headers.remove: invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// apply removes, then sets headers. Names are canonicalised, so removal
+// matches regardless of case. Only headers are touched; the body streams
+// through untouched.
+func (c *ResponseHeadersConfig) apply(h http.Header) {
+	if c == nil {
+		return
+	}
+	for _, name := range c.Remove {
+		h.Del(name)
+	}
+	for name, value := range c.Set {
+		h.Set(name, value)
+	}
+}
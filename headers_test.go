@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server: ServerConfig{DefaultResponseHeaders: map[string]string{
+			"X-Frame-Options":           "DENY",
+			"Strict-Transport-Security": "max-age=31536000",
+			"X-Content-Type-Options":    "nosniff",
+		}},
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL},
+			{
+				Name: "docs", PathPrefix: "/api/docs", TargetURL: upstream.URL, OverrideResponseHeaders: true,
+				AddResponseHeaders: map[string]string{"X-Content-Type-Options": "", "Cache-Control": "public, max-age=60"},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"/api/orders/1", map[string]string{
+			"X-Frame-Options":           "SAMEORIGIN",
+			"Strict-Transport-Security": "max-age=31536000",
+			"X-Content-Type-Options":    "nosniff",
+		}},
+		{"/api/docs/1", map[string]string{
+			"X-Frame-Options":           "DENY",
+			"Strict-Transport-Security": "max-age=31536000",
+			"X-Content-Type-Options":    "",
+			"Cache-Control":             "public, max-age=60",
+		}},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", tt.path, nil))
+		for name, want := range tt.want {
+			if got := rw.Header().Get(name); got != want {
+				t.Errorf("%s: %s = %q want %q", tt.path, name, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadConfigInvalidResponseHeaders(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    add_response_headers:
+      "X Bad": "1"
+`)
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "service orders") {
+		t.Fatalf("expected error naming the service, got %v", err)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{
+				Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL,
+				RequestHeaders: &RequestHeadersConfig{
+					Set:    map[string]string{"X-Internal-Caller": "gateway", "X-Internal-Token": "Bearer s3cret"},
+					Add:    map[string]string{"X-Tags": "gateway"},
+					Remove: []string{"x-debug"},
+				},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	req := httptest.NewRequest("GET", "/api/orders/1", nil)
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Internal-Caller", "spoofed")
+	req.Header.Set("X-Tags", "client")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Get("X-Debug") != "" {
+		t.Errorf("X-Debug was not removed")
+	}
+	if v := got.Values("X-Internal-Caller"); len(v) != 1 || v[0] != "gateway" {
+		t.Errorf("X-Internal-Caller = %v", v)
+	}
+	if v := got.Get("X-Internal-Token"); v != "Bearer s3cret" {
+		t.Errorf("X-Internal-Token = %q", v)
+	}
+	if v := got.Values("X-Tags"); len(v) != 2 || v[0] != "client" || v[1] != "gateway" {
+		t.Errorf("X-Tags = %v", v)
+	}
+}
+
+func TestLoadConfigRequestHeadersUnsetEnv(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    request_headers:
+      set:
+        X-Internal-Token: "${TEST_UNSET_GATEWAY_SECRET}"
+`)
+	_, err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "TEST_UNSET_GATEWAY_SECRET") {
+		t.Fatalf("expected error naming the unset variable, got %v", err)
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "legacy/1.0")
+		w.Header().Set("X-Powered-By", "PHP/5.6")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("second"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{{
+			Name: "images", PathPrefix: "/api/images", TargetURL: upstream.URL,
+			ResponseHeaders: &ResponseHeadersConfig{
+				Set:    map[string]string{"cache-control": "public, max-age=3600"},
+				Remove: []string{"server", "x-powered-by"},
+			},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/images/logo.png", nil))
+	for name, want := range map[string]string{"Server": "", "X-Powered-By": "", "Cache-Control": "public, max-age=3600"} {
+		if got := rw.Header().Get(name); got != want {
+			t.Errorf("%s = %q want %q", name, got, want)
+		}
+	}
+	if got := rw.Body.String(); got != "first second" {
+		t.Errorf("body = %q", got)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	maxHealthCheckTimeout      = 5 * time.Second
+)
+
+// checkHealth probes every upstream of the balancer at path once per interval
+// until ctx is done. Upstreams that fail a probe are skipped by next until a
+// later probe succeeds. Probes go through transport, nil meaning the default.
+func (b *balancer) checkHealth(ctx context.Context, transport http.RoundTripper, service, path string, interval time.Duration) {
+	timeout := interval
+	if timeout > maxHealthCheckTimeout {
+		timeout = maxHealthCheckTimeout
+	}
+	client := &http.Client{Timeout: timeout, Transport: transport}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, u := range b.all() {
+			wg.Add(1)
+			go func(u *upstream) {
+				defer wg.Done()
+				ok := probe(ctx, client, u, path)
+				if ctx.Err() != nil {
+					return
+				}
+				if was := u.healthy.Swap(ok); was != ok {
+					if ok {
+						logger.Info("upstream healthy", "service", service, "upstream", u.url.String())
+					} else {
+						logger.Warn("upstream unhealthy", "service", service, "upstream", u.url.String())
+					}
+				}
+			}(u)
+		}
+		wg.Wait()
+		if ctx.Err() == nil {
+			b.probed.Store(true)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe reports whether a GET of path on the upstream answers below 400
+func probe(ctx context.Context, client *http.Client, u *upstream, path string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url.JoinPath(path).String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode < http.StatusBadRequest
+}
+
+const (
+	defaultServiceHealthPath = "/healthz"
+	serviceHealthTimeout     = 2 * time.Second
+	serviceHealthCacheTTL    = 5 * time.Second
+)
+
+// healthAggregator serves /healthz/services. Results are cached for ttl and
+// concurrent callers share one round of probes, so the endpoint cannot be
+// used to multiply load onto upstreams.
+type healthAggregator struct {
+	services map[string]serviceTargets
+	client   *http.Client
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	status    map[string]string
+	allUp     bool
+}
+
+// serviceTargets are the upstreams of one service and the path they are probed at
+type serviceTargets struct {
+	lb     *balancer
+	path   string
+	client *http.Client
+}
+
+// newHealthAggregator probes through transport, nil meaning the default
+func newHealthAggregator(transport http.RoundTripper) *healthAggregator {
+	return &healthAggregator{
+		services: make(map[string]serviceTargets),
+		client:   &http.Client{Timeout: serviceHealthTimeout, Transport: transport},
+		ttl:      serviceHealthCacheTTL,
+		now:      time.Now,
+	}
+}
+
+// add registers a service; its health_check_path is probed, /healthz if
+// unset. A transport other than the aggregator's is used for this service's
+// probes only.
+func (h *healthAggregator) add(s ServiceConfig, lb *balancer, transport http.RoundTripper) {
+	path := s.HealthCheckPath
+	if path == "" {
+		path = defaultServiceHealthPath
+	}
+	client := h.client
+	if transport != nil && transport != h.client.Transport {
+		client = &http.Client{Timeout: serviceHealthTimeout, Transport: transport}
+	}
+	h.services[s.Name] = serviceTargets{lb: lb, path: path, client: client}
+}
+
+// check returns each service as "up" when at least one of its upstreams
+// answers the probe, and whether every service is up
+func (h *healthAggregator) check() (map[string]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status != nil && h.now().Sub(h.checkedAt) < h.ttl {
+		return h.status, h.allUp
+	}
+
+	// detached from any one caller so a client going away cannot spoil the cache
+	ctx, cancel := context.WithTimeout(context.Background(), serviceHealthTimeout)
+	defer cancel()
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		up = make(map[string]bool, len(h.services))
+	)
+	for name, st := range h.services {
+		up[name] = false
+		for _, u := range st.lb.all() {
+			wg.Add(1)
+			go func(name string, u *upstream, st serviceTargets) {
+				defer wg.Done()
+				if probe(ctx, st.client, u, st.path) {
+					mu.Lock()
+					up[name] = true
+					mu.Unlock()
+				}
+			}(name, u, st)
+		}
+	}
+	wg.Wait()
+
+	status := make(map[string]string, len(up))
+	allUp := true
+	for name, ok := range up {
+		status[name] = "up"
+		if !ok {
+			status[name] = "down"
+			allUp = false
+		}
+	}
+	h.status, h.allUp, h.checkedAt = status, allUp, h.now()
+	return status, allUp
+}
+
+func (h *healthAggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, allUp := h.check()
+	code := http.StatusOK
+	if !allUp {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// draining is set once shutdown starts, failing /readyz so load balancers
+// stop sending traffic before the listener closes
+var draining atomic.Bool
+
+// readiness serves /readyz from the state kept by the background health
+// checks, so it never sends probes of its own. Services without a
+// health_check_path cannot be judged and don't hold readiness back. When
+// checks is set only the services it names count.
+type readiness struct {
+	services map[string]*balancer
+	checks   map[string]bool
+}
+
+func newReadiness(checks []string) *readiness {
+	rd := &readiness{services: make(map[string]*balancer)}
+	if len(checks) > 0 {
+		rd.checks = make(map[string]bool, len(checks))
+		for _, name := range checks {
+			rd.checks[name] = true
+		}
+	}
+	return rd
+}
+
+// add registers a service; lb is nil when the service has no health checks
+func (rd *readiness) add(name string, lb *balancer) {
+	if rd.checks != nil && !rd.checks[name] {
+		return
+	}
+	rd.services[name] = lb
+}
+
+// validateReadinessChecks makes sure readiness_checks only names services
+// that are health checked, since nothing else could make them ready
+func (c *Config) validateReadinessChecks() error {
+	checked := make(map[string]bool, len(c.Services))
+	for _, s := range c.Services {
+		checked[s.Name] = s.HealthCheckPath != ""
+	}
+	for _, name := range c.Server.ReadinessChecks {
+		hc, ok := checked[name]
+		if !ok {
+			return fmt.Errorf("readiness_checks: unknown service %q", name)
+		}
+		if !hc {
+			return fmt.Errorf("readiness_checks: service %q needs a health_check_path", name)
+		}
+	}
+	return nil
+}
+
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "draining"})
+		return
+	}
+	services := make(map[string]string, len(rd.services))
+	ready := true
+	for name, lb := range rd.services {
+		switch {
+		case lb == nil:
+			services[name] = "unchecked"
+		case lb.ready():
+			services[name] = "ready"
+		default:
+			services[name] = "not ready"
+			ready = false
+		}
+	}
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "services": services})
+}
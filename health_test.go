@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckSkipsUnhealthyTargets(t *testing.T) {
+	var bHealthy atomic.Bool
+	bHealthy.Store(true)
+
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upstream", "a")
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !bHealthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Upstream", "b")
+	}))
+	defer b.Close()
+
+	lb, err := newBalancer([]string{a.URL, b.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lb.checkHealth(ctx, nil, "svc", "/healthz", 10*time.Millisecond)
+
+	waitFor := func(want map[string]bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			got := lb.healthStatus()
+			if got[a.URL] == want[a.URL] && got[b.URL] == want[b.URL] {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("health did not converge: got %v want %v", lb.healthStatus(), want)
+	}
+
+	bHealthy.Store(false)
+	waitFor(map[string]bool{a.URL: true, b.URL: false})
+	for i := 0; i < 3; i++ {
+		if u := lb.next(); u == nil || u.url.String() != a.URL {
+			t.Fatalf("expected only the healthy upstream to be selected, got %v", u)
+		}
+	}
+
+	bHealthy.Store(true)
+	waitFor(map[string]bool{a.URL: true, b.URL: true})
+}
+
+func TestAllUpstreamsUnhealthy(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "down", PathPrefix: "/api/down", TargetURL: down.URL, HealthCheckPath: "/healthz", HealthCheckInterval: "10ms"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/down/x", nil))
+		if rw.Code == http.StatusServiceUnavailable {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 503 once all upstreams are unhealthy, got %d", rw.Code)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServiceHealthEndpoint(t *testing.T) {
+	var probes atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			probes.Add(1)
+		}
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: up.URL},
+			{Name: "users", PathPrefix: "/api/users", TargetURL: down.URL},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	get := func() (int, map[string]string) {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/healthz/services", nil))
+		var body map[string]string
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rw.Code, body
+	}
+
+	code, body := get()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: got %d want %d", code, http.StatusServiceUnavailable)
+	}
+	if body["orders"] != "up" || body["users"] != "down" {
+		t.Fatalf("unexpected body %v", body)
+	}
+	get()
+	if n := probes.Load(); n != 1 {
+		t.Fatalf("expected cached result, upstream probed %d times", n)
+	}
+}
+
+func TestReadinessEndpoint(t *testing.T) {
+	var up atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, HealthCheckPath: "/healthz", HealthCheckInterval: "10ms"},
+			{Name: "users", PathPrefix: "/api/users", TargetURL: "http://127.0.0.1:1"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	get := func() (int, map[string]interface{}) {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+		var body map[string]interface{}
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rw.Code, body
+	}
+	waitFor := func(want int) map[string]interface{} {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			code, body := get()
+			if code == want {
+				return body
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("readiness did not converge: got %d %v want %d", code, body, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	body := waitFor(http.StatusServiceUnavailable)
+	services := body["services"].(map[string]interface{})
+	if services["orders"] != "not ready" || services["users"] != "unchecked" {
+		t.Fatalf("unexpected services %v", services)
+	}
+
+	up.Store(true)
+	body = waitFor(http.StatusOK)
+	if body["status"] != "ready" {
+		t.Fatalf("unexpected body %v", body)
+	}
+
+	rw := httptest.NewRecorder()
+	up.Store(false)
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/healthz", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected liveness to ignore upstreams, got %d", rw.Code)
+	}
+}
+
+func TestBalancerNotReadyBeforeFirstProbe(t *testing.T) {
+	lb, err := newBalancer([]string{"http://a:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb.ready() {
+		t.Fatal("expected balancer not to be ready before health checks ran")
+	}
+	lb.probed.Store(true)
+	if !lb.ready() {
+		t.Fatal("expected balancer with a healthy upstream to be ready")
+	}
+}
+
+func TestReadinessChecks(t *testing.T) {
+	var up atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{ReadinessChecks: []string{"orders"}},
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, HealthCheckPath: "/healthz", HealthCheckInterval: "10ms"},
+			{Name: "reports", PathPrefix: "/api/reports", TargetURL: down.URL, HealthCheckPath: "/healthz", HealthCheckInterval: "10ms"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	get := func() int {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+		return rw.Code
+	}
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the first probe, got %d", code)
+	}
+	up.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for get() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("expected ready once orders is up, ignoring reports")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	draining.Store(true)
+	t.Cleanup(func() { draining.Store(false) })
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+	if rw.Code != http.StatusServiceUnavailable || !strings.Contains(rw.Body.String(), "draining") {
+		t.Fatalf("expected 503 draining during shutdown, got %d %s", rw.Code, rw.Body)
+	}
+}
+
+func TestLoadConfigInvalidReadinessChecks(t *testing.T) {
+	tests := map[string]string{
+		"unknown service":  "[billing]",
+		"no health checks": "[users]",
+	}
+	for name, checks := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+server:
+  readiness_checks: `+checks+`
+services:
+  - name: "users"
+    path_prefix: "/api/users"
+    target_url: "http://users:8080"
+`)
+			if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "readiness_checks") {
+				t.Fatalf("expected readiness_checks error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultIntrospectionCacheTTL         = 30 * time.Second
+	defaultIntrospectionNegativeCacheTTL = 10 * time.Second
+	introspectionTimeout                 = 5 * time.Second
+)
+
+// IntrospectionConfig validates opaque tokens against an RFC 7662 endpoint,
+// authenticating with HTTP Basic client credentials
+type IntrospectionConfig struct {
+	URL              string `yaml:"url"`
+	ClientID         string `yaml:"client_id"`
+	ClientSecret     string `yaml:"client_secret"`
+	CacheTTL         string `yaml:"cache_ttl"`
+	NegativeCacheTTL string `yaml:"negative_cache_ttl"`
+}
+
+func (c *IntrospectionConfig) ttls() (active, inactive time.Duration, err error) {
+	active, inactive = defaultIntrospectionCacheTTL, defaultIntrospectionNegativeCacheTTL
+	if c.CacheTTL != "" {
+		if active, err = time.ParseDuration(c.CacheTTL); err != nil || active < 0 {
+			return 0, 0, fmt.Errorf("introspection: invalid cache_ttl %q", c.CacheTTL)
+		}
+	}
+	if c.NegativeCacheTTL != "" {
+		if inactive, err = time.ParseDuration(c.NegativeCacheTTL); err != nil || inactive < 0 {
+			return 0, 0, fmt.Errorf("introspection: invalid negative_cache_ttl %q", c.NegativeCacheTTL)
+		}
+	}
+	return active, inactive, nil
+}
+
+func (c *IntrospectionConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("introspection: url must be set")
+	}
+	if _, err := url.Parse(c.URL); err != nil {
+		return fmt.Errorf("introspection: invalid url: %w", err)
+	}
+	_, _, err := c.ttls()
+	return err
+}
+
+// introspector asks the introspection endpoint about tokens and caches the
+// answers, inactive ones included, keyed by the token's hash
+type introspector struct {
+	cfg         IntrospectionConfig
+	client      *http.Client
+	activeTTL   time.Duration
+	inactiveTTL time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	cache     map[[sha256.Size]byte]introspection
+	lastSweep time.Time
+}
+
+type introspection struct {
+	claims  jwt.MapClaims // nil when the token is not active
+	expires time.Time
+}
+
+func newIntrospector(c IntrospectionConfig) (*introspector, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	active, inactive, _ := c.ttls()
+	return &introspector{
+		cfg:         c,
+		client:      &http.Client{Timeout: introspectionTimeout},
+		activeTTL:   active,
+		inactiveTTL: inactive,
+		now:         time.Now,
+		cache:       make(map[[sha256.Size]byte]introspection),
+	}, nil
+}
+
+// introspect returns the claims of an active token, nil for an inactive one,
+// or an error when the endpoint could not be asked
+func (i *introspector) introspect(r *http.Request, token string) (jwt.MapClaims, error) {
+	key := sha256.Sum256([]byte(token))
+	now := i.now()
+	i.mu.Lock()
+	entry, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.claims, nil
+	}
+
+	claims, err := i.fetch(r, token)
+	if err != nil {
+		return nil, err
+	}
+	ttl := i.inactiveTTL
+	if claims != nil {
+		ttl = i.activeTTL
+	}
+	expires := now.Add(ttl)
+	// never trust a cached answer beyond the token's own expiry
+	if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expires) {
+		expires = time.Unix(int64(exp), 0)
+	}
+
+	i.mu.Lock()
+	i.sweep(now)
+	i.cache[key] = introspection{claims: claims, expires: expires}
+	i.mu.Unlock()
+	return claims, nil
+}
+
+// sweep drops expired answers at most once per TTL; i.mu must be held
+func (i *introspector) sweep(now time.Time) {
+	if now.Sub(i.lastSweep) < i.activeTTL {
+		return
+	}
+	i.lastSweep = now
+	for key, entry := range i.cache {
+		if !now.Before(entry.expires) {
+			delete(i.cache, key)
+		}
+	}
+}
+
+func (i *introspector) fetch(r *http.Request, token string) (jwt.MapClaims, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, i.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.cfg.ClientID), url.QueryEscape(i.cfg.ClientSecret))
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %s", resp.Status)
+	}
+	var claims jwt.MapClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("decoding introspection response: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, nil
+	}
+	// injectUserInfo identifies the user by sub; fall back to the username
+	if _, ok := claims["sub"]; !ok {
+		if username, ok := claims["username"]; ok {
+			claims["sub"] = username
+		}
+	}
+	return claims, nil
+}
+
+// introspectionMiddleware accepts tokens, taken from sources like JWTs,
+// that the introspection endpoint reports as active and stores its answer
+// as the request's claims
+func introspectionMiddleware(i *introspector, sources []tokenSource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, src, err := findToken(sources, r)
+			if err != nil {
+				writeError(w, r, http.StatusUnauthorized, "Invalid Authorization Header format")
+				return
+			}
+			if tok == "" {
+				writeError(w, r, http.StatusUnauthorized, missingTokenMessage(sources))
+				return
+			}
+			claims, err := i.introspect(r, tok)
+			if err != nil {
+				logger.Error("token introspection failed", "url", i.cfg.URL, "err", err)
+				writeError(w, r, http.StatusServiceUnavailable, "token introspection unavailable")
+				return
+			}
+			if claims == nil {
+				logger.Warn("inactive token", "path", r.URL.Path)
+				writeError(w, r, http.StatusUnauthorized, "Invalid Token")
+				return
+			}
+			ctx := context.WithValue(r.Context(), userClaimsKey, claims)
+			ctx = context.WithValue(ctx, tokenSourceKey{}, src)
+			r = r.WithContext(ctx)
+			if src.query != "" {
+				r.URL = withoutQueryParam(r.URL, src.query)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
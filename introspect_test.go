@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fakeIntrospectionEndpoint(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "gateway" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp := map[string]interface{}{"active": false}
+		switch r.PostFormValue("token") {
+		case "good":
+			resp = map[string]interface{}{"active": true, "username": "alice", "scope": "read write", "roles": []string{"admin"}}
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIntrospectionAuth(t *testing.T) {
+	var calls atomic.Int32
+	endpoint := fakeIntrospectionEndpoint(t, &calls)
+	var gotUser, gotRoles string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotRoles = r.Header.Get("X-User-Id"), r.Header.Get("X-User-Roles")
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Introspection: &IntrospectionConfig{URL: endpoint.URL, ClientID: "gateway", ClientSecret: "s3cret"},
+		Services: []ServiceConfig{
+			{Name: "opaque", PathPrefix: "/api/opaque", TargetURL: upstream.URL, AuthRequired: true, Auth: authIntrospection, RequiredRoles: []string{"admin"}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"active", "good", http.StatusOK},
+		{"inactive", "revoked", http.StatusUnauthorized},
+		{"endpoint error", "broken", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/opaque/x", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Fatalf("unexpected status: got %d want %d", rw.Code, tt.want)
+			}
+		})
+	}
+	if gotUser != "alice" || gotRoles != "admin" {
+		t.Fatalf("unexpected user headers %q %q", gotUser, gotRoles)
+	}
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/opaque/x", nil))
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rw.Code)
+	}
+}
+
+func TestIntrospectionTokenSources(t *testing.T) {
+	var calls atomic.Int32
+	endpoint := fakeIntrospectionEndpoint(t, &calls)
+	var gotCookie, gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCookie, gotQuery = r.Header.Get("Cookie"), r.URL.RawQuery
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Introspection: &IntrospectionConfig{URL: endpoint.URL, ClientID: "gateway", ClientSecret: "s3cret"},
+		TokenSources:  []string{"cookie:access_token", "query:access_token"},
+		Services: []ServiceConfig{
+			{Name: "opaque", PathPrefix: "/api/opaque", TargetURL: upstream.URL, AuthRequired: true, Auth: authIntrospection, StripAuthorization: true},
+			{Name: "maybe", PathPrefix: "/api/maybe", TargetURL: upstream.URL, AuthOptional: true, Auth: authIntrospection},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	send := func(target, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "access_token", Value: cookie})
+			req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := send("/api/opaque/x", "good"); rw.Code != http.StatusOK {
+		t.Fatalf("cookie token: got %d want %d", rw.Code, http.StatusOK)
+	}
+	if gotCookie != "theme=dark" {
+		t.Errorf("strip_authorization left cookies %q", gotCookie)
+	}
+	if rw := send("/api/opaque/x?access_token=good&page=2", ""); rw.Code != http.StatusOK {
+		t.Fatalf("query token: got %d want %d", rw.Code, http.StatusOK)
+	}
+	if gotQuery != "page=2" {
+		t.Errorf("query token reached the upstream: %q", gotQuery)
+	}
+	rw := send("/api/opaque/x", "")
+	if rw.Code != http.StatusUnauthorized || !strings.Contains(rw.Body.String(), "Missing Token") {
+		t.Fatalf("no token: got %d %q", rw.Code, rw.Body.String())
+	}
+	// a bad cookie token on an auth_optional service is still rejected
+	if rw := send("/api/maybe/x", "revoked"); rw.Code != http.StatusUnauthorized {
+		t.Fatalf("optional with inactive cookie token: got %d want %d", rw.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	var calls atomic.Int32
+	endpoint := fakeIntrospectionEndpoint(t, &calls)
+	i, err := newIntrospector(IntrospectionConfig{
+		URL: endpoint.URL, ClientID: "gateway", ClientSecret: "s3cret",
+		CacheTTL: "1m", NegativeCacheTTL: "10s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	i.now = func() time.Time { return now }
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for n := 0; n < 3; n++ {
+		if claims, err := i.introspect(req, "good"); err != nil || claims["sub"] != "alice" {
+			t.Fatalf("unexpected introspection %v %v", claims, err)
+		}
+		if claims, err := i.introspect(req, "revoked"); err != nil || claims != nil {
+			t.Fatalf("expected inactive token, got %v %v", claims, err)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected answers to be cached, endpoint called %d times", got)
+	}
+
+	now = now.Add(30 * time.Second)
+	i.introspect(req, "good")
+	i.introspect(req, "revoked")
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("expected only the negative entry to expire, endpoint called %d times", got)
+	}
+
+	for n := 0; n < 2; n++ {
+		if _, err := i.introspect(req, "broken"); err == nil {
+			t.Fatal("expected endpoint error")
+		}
+	}
+	if got := calls.Load(); got != 5 {
+		t.Fatalf("expected errors not to be cached, endpoint called %d times", got)
+	}
+}
+
+func TestIntrospectionConfigValidation(t *testing.T) {
+	path := writeConfig(t, `
+introspection:
+  url: "http://idp/introspect"
+  cache_ttl: "soon"
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for invalid cache_ttl")
+	}
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "opaque", PathPrefix: "/api/opaque", TargetURL: "http://a:8080", AuthRequired: true, Auth: authIntrospection},
+		},
+	}
+	if _, err := buildRouter(context.Background(), cfg); err == nil {
+		t.Fatal("expected error for introspection service without introspection config")
+	}
+}
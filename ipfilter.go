@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter matches client addresses against allow and deny lists of CIDR
+// ranges or single addresses. Deny wins over allow and an empty allow list
+// allows everyone.
+type ipFilter struct {
+	allow, deny []netip.Prefix
+}
+
+func newIPFilter(allow, deny []string) (*ipFilter, error) {
+	f := &ipFilter{}
+	var err error
+	if f.allow, err = parsePrefixes(allow); err != nil {
+		return nil, fmt.Errorf("allow_ips: %w", err)
+	}
+	if f.deny, err = parsePrefixes(deny); err != nil {
+		return nil, fmt.Errorf("deny_ips: %w", err)
+	}
+	return f, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			addr, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", e)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", e)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func (f *ipFilter) allowed(addr netip.Addr) bool {
+	// IPv4 clients on dual-stack listeners show up as ::ffff:a.b.c.d
+	addr = addr.Unmap()
+	for _, p := range f.deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIPs rejects clients, by the address clientIP returns, that the
+// filter does not allow with 403
+func filterIPs(f *ipFilter, service string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(clientIP(r))
+			if err != nil || !f.allowed(addr) {
+				logger.Warn("client address not allowed", "service", service, "client", clientIP(r), "path", r.URL.Path)
+				writeError(w, r, http.StatusForbidden, "access denied")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := newIPFilter(
+		[]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"},
+		[]string{"10.1.0.0/16", "2001:db8:bad::/48"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"10.2.3.4":          true,
+		"10.1.2.3":          false, // deny wins over allow
+		"192.0.2.7":         true,
+		"192.0.2.8":         false,
+		"::ffff:10.2.3.4":   true,
+		"2001:db8:1::1":     true,
+		"2001:db8:bad::1":   false,
+		"2001:db9::1":       false,
+		"fe80::1":           false,
+		"::ffff:10.1.255.1": false,
+	}
+	for ip, want := range tests {
+		if got := f.allowed(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("%s: got %v want %v", ip, got, want)
+		}
+	}
+
+	denyOnly, _ := newIPFilter(nil, []string{"203.0.113.0/24"})
+	if !denyOnly.allowed(netip.MustParseAddr("198.51.100.1")) {
+		t.Error("empty allow list must allow everyone not denied")
+	}
+	if denyOnly.allowed(netip.MustParseAddr("203.0.113.9")) {
+		t.Error("denied address was allowed")
+	}
+
+	if _, err := newIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	if _, err := newIPFilter(nil, []string{"not-an-ip"}); err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "admin", PathPrefix: "/api/admin", TargetURL: upstream.URL, AllowIPs: []string{"10.0.0.0/8", "fd00::/8"}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	for ip, want := range map[string]int{
+		"10.0.0.1":    http.StatusOK,
+		"fd12::1":     http.StatusOK,
+		"203.0.113.1": http.StatusForbidden,
+		"2001:db8::1": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/api/admin/x", nil)
+		req.RemoteAddr = net.JoinHostPort(ip, "4711")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != want {
+			t.Errorf("%s: got %d want %d", ip, rw.Code, want)
+		}
+	}
+}
+
+func TestIPFilterForwardedHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	yes, no := true, false
+	for name, tt := range map[string]struct {
+		trust *bool
+		want  int
+	}{
+		// a client outside allow_ips cannot pick an allowed address
+		"unset": {nil, http.StatusForbidden},
+		"false": {&no, http.StatusForbidden},
+		"true":  {&yes, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := &Config{
+				JWTSecret: "dummy",
+				Server:    ServerConfig{TrustForwardedHeaders: tt.trust},
+				Services: []ServiceConfig{
+					{Name: "admin", PathPrefix: "/api/admin", TargetURL: upstream.URL, AllowIPs: []string{"10.0.0.0/8"}},
+				},
+			}
+			r := mustBuildRouter(t, cfg)
+			req := httptest.NewRequest("GET", "/api/admin/x", nil)
+			req.RemoteAddr = "203.0.113.1:4711"
+			req.Header.Set("X-Real-IP", "10.0.0.1")
+			req.Header.Set("X-Forwarded-For", "10.0.0.1")
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Errorf("got %d want %d", rw.Code, tt.want)
+			}
+		})
+	}
+}
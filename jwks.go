@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval = 5 * time.Minute
+	// jwksMinRefetchInterval rate limits refetches triggered by unknown kids
+	jwksMinRefetchInterval = 10 * time.Second
+)
+
+// jwksCache holds the signing keys published at a JWKS url. Keys are fetched
+// on first use and refreshed in the background once older than the refresh
+// interval; an unknown kid forces a refetch at most once per
+// jwksMinRefetchInterval.
+type jwksCache struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	refreshing  bool
+}
+
+func newJWKSCache(url string, interval time.Duration) *jwksCache {
+	return &jwksCache{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key returns the verification key for kid. An empty kid matches the only
+// key in the set, if there is exactly one.
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.RLock()
+	k, ok := c.lookup(kid)
+	loaded := c.keys != nil
+	stale := time.Since(c.fetchedAt) > c.interval
+	c.mu.RUnlock()
+
+	if !loaded {
+		if err := c.refetch(); err != nil {
+			return nil, err
+		}
+		return c.keyFromCache(kid)
+	}
+	if stale {
+		go c.backgroundRefresh()
+	}
+	if ok {
+		return k, nil
+	}
+	// unknown kid: the IdP may have rotated keys
+	if err := c.refetch(); err != nil {
+		return nil, err
+	}
+	return c.keyFromCache(kid)
+}
+
+func (c *jwksCache) keyFromCache(kid string) (interface{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if k, ok := c.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no jwks key for kid %q", kid)
+}
+
+// lookup must be called with c.mu held
+func (c *jwksCache) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, true
+		}
+	}
+	k, ok := c.keys[kid]
+	return k, ok
+}
+
+// refetch fetches the key set unless another fetch was attempted recently
+func (c *jwksCache) refetch() error {
+	c.mu.Lock()
+	if time.Since(c.lastAttempt) < jwksMinRefetchInterval {
+		c.mu.Unlock()
+		return errors.New("jwks refetch rate limited")
+	}
+	c.lastAttempt = time.Now()
+	c.mu.Unlock()
+
+	return c.fetch()
+}
+
+func (c *jwksCache) backgroundRefresh() {
+	c.mu.Lock()
+	if c.refreshing {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing = true
+	c.lastAttempt = time.Now()
+	c.mu.Unlock()
+
+	if err := c.fetch(); err != nil {
+		logger.Warn("jwks background refresh failed", "url", c.url, "err", err)
+	}
+
+	c.mu.Lock()
+	c.refreshing = false
+	c.mu.Unlock()
+}
+
+func (c *jwksCache) fetch() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch jwks: unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			logger.Warn("skipping jwks key", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+	logger.Info("jwks refreshed", "url", c.url, "keys", len(keys))
+	return nil
+}
+
+// jwk is a single JSON Web Key (RFC 7517); only public RSA and EC keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestJWKSAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+				{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+			},
+		})
+	}))
+	defer idp.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Seen-User-Id", r.Header.Get("X-User-Id"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		JWKSURL:   idp.URL,
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		tok := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "42"})
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"rs256", sign(jwt.SigningMethodRS256, "rsa-1", rsaKey), http.StatusOK},
+		{"es256", sign(jwt.SigningMethodES256, "ec-1", ecKey), http.StatusOK},
+		{"hs256 with jwks precedence", signToken(t, "secret", jwt.MapClaims{"sub": "42"}), http.StatusUnauthorized},
+		{"unknown kid", sign(jwt.SigningMethodRS256, "rsa-2", rsaKey), http.StatusUnauthorized},
+		{"wrong key for kid", sign(jwt.SigningMethodES256, "rsa-1", ecKey), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/private/x", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Code; got != tt.want {
+				t.Fatalf("unexpected status: got %d want %d", got, tt.want)
+			}
+		})
+	}
+
+	// the unknown kid arrives inside the refetch window and must not hit the IdP
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("unexpected jwks fetches: got %d want 1", got)
+	}
+}
+
+func TestJWKSAllowedAlgs(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+				{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edPub)},
+			},
+		})
+	}))
+	defer idp.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		tok := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "42"})
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	rs256, ps256, eddsa := sign(jwt.SigningMethodRS256, "rsa-1", rsaKey), sign(jwt.SigningMethodPS256, "rsa-1", rsaKey), sign(jwt.SigningMethodEdDSA, "ed-1", edKey)
+
+	tests := []struct {
+		name  string
+		algs  []string
+		token string
+		want  int
+	}{
+		{"default rs256", nil, rs256, http.StatusOK},
+		{"default eddsa", nil, eddsa, http.StatusUnauthorized},
+		{"default ps256", nil, ps256, http.StatusUnauthorized},
+		{"listed eddsa", []string{"EdDSA", "PS256"}, eddsa, http.StatusOK},
+		{"listed ps256", []string{"EdDSA", "PS256"}, ps256, http.StatusOK},
+		{"unlisted rs256", []string{"EdDSA", "PS256"}, rs256, http.StatusUnauthorized},
+		{"eddsa with rsa key", []string{"EdDSA"}, sign(jwt.SigningMethodEdDSA, "rsa-1", edKey), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustBuildRouter(t, &Config{
+				JWKSURL:        idp.URL,
+				JWTAllowedAlgs: tt.algs,
+				Services:       []ServiceConfig{{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true}},
+			})
+			req := httptest.NewRequest("GET", "/api/private/x", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWKSCacheRotation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kid atomic.Value
+	kid.Store("old")
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": kid.Load().(string), "n": b64(key.N), "e": b64(big.NewInt(int64(key.E)))},
+			},
+		})
+	}))
+	defer idp.Close()
+
+	c := newJWKSCache(idp.URL, time.Hour)
+	if _, err := c.key("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	kid.Store("new")
+	if _, err := c.key("new"); err == nil {
+		t.Fatal("expected refetch inside the rate limit window to be refused")
+	}
+
+	c.mu.Lock()
+	c.lastAttempt = time.Now().Add(-jwksMinRefetchInterval)
+	c.mu.Unlock()
+	if _, err := c.key("new"); err != nil {
+		t.Fatalf("expected rotated key after refetch: %v", err)
+	}
+}
+
+func TestPerServiceJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "partner-1", "n": b64(key.N), "e": b64(big.NewInt(int64(key.E)))},
+			},
+		})
+	}))
+	defer idp.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// partner tokens come from another identity provider than customer ones
+	r := mustBuildRouter(t, &Config{
+		JWTSecret: "customer-secret",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+			{Name: "partners", PathPrefix: "/api/partners", TargetURL: upstream.URL, AuthRequired: true, JWKSURL: idp.URL, JWTAudience: "partner-api"},
+		},
+	})
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "p1", "aud": "partner-api"})
+	tok.Header["kid"] = "partner-1"
+	partner, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer := signToken(t, "customer-secret", jwt.MapClaims{"sub": "42", "aud": "partner-api"})
+
+	tests := []struct {
+		path, token string
+		want        int
+	}{
+		{"/api/partners/1", partner, http.StatusOK},
+		{"/api/partners/1", customer, http.StatusUnauthorized},
+		{"/api/orders/1", customer, http.StatusOK},
+		{"/api/orders/1", partner, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != tt.want {
+			t.Errorf("%s: got %d want %d", tt.path, rw.Code, tt.want)
+		}
+	}
+}
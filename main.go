@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -17,48 +19,435 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/golang-jwt/jwt/v4"
-	"github.com/rs/cors"
-	"gopkg.in/yaml.v3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Config structs
 type Config struct {
-	Server    ServerConfig    `yaml:"server"`
-	JWTSecret string          `yaml:"jwt_secret"`
-	Services  []ServiceConfig `yaml:"services"`
+	Server              ServerConfig         `yaml:"server"`
+	JWTSecret           string               `yaml:"jwt_secret"`
+	JWKSURL             string               `yaml:"jwt_jwks_url"`
+	JWKSRefreshInterval string               `yaml:"jwt_jwks_refresh_interval"`
+	RolesClaim          string               `yaml:"jwt_roles_claim"`
+	ClaimHeaders        map[string]string    `yaml:"claim_headers"`
+	Tenant              *TenantConfig        `yaml:"tenant"`
+	JWTIssuer           string               `yaml:"jwt_issuer"`
+	JWTAudience         string               `yaml:"jwt_audience"`
+	JWTLeeway           string               `yaml:"jwt_leeway"`
+	JWTAllowedAlgs      []string             `yaml:"jwt_allowed_algs"`
+	TokenSources        []string             `yaml:"token_sources"`
+	Introspection       *IntrospectionConfig `yaml:"introspection"`
+	Revocation          *RevocationConfig    `yaml:"revocation"`
+	Services            []ServiceConfig      `yaml:"services"`
+	Redirects           []RedirectConfig     `yaml:"redirects"`
+}
+
+// rolesClaim is the claim path roles are read from, e.g. "realm_access.roles"
+func (c *Config) rolesClaim() string {
+	if c.RolesClaim == "" {
+		return defaultRolesClaim
+	}
+	return c.RolesClaim
+}
+
+// jwtLeeway is the clock skew tolerated on exp, nbf and iat; zero if unset
+func (c *Config) jwtLeeway() (time.Duration, error) {
+	if c.JWTLeeway == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.JWTLeeway)
+	if err != nil {
+		return 0, fmt.Errorf("invalid jwt_leeway %q: %w", c.JWTLeeway, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("jwt_leeway must not be negative, got %q", c.JWTLeeway)
+	}
+	return d, nil
+}
+
+// defaultJWTAlgs are the signing algorithms accepted without
+// jwt_allowed_algs; EdDSA and the RSA-PSS variants must be listed to be used
+var defaultJWTAlgs = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// allowedAlgs returns the token signing algorithms accepted, by their alg
+// header value
+func (c *Config) allowedAlgs() ([]string, error) {
+	if len(c.JWTAllowedAlgs) == 0 {
+		return defaultJWTAlgs, nil
+	}
+	for _, alg := range c.JWTAllowedAlgs {
+		if strings.EqualFold(alg, "none") {
+			return nil, errors.New("jwt_allowed_algs: unsigned tokens (none) can't be allowed")
+		}
+		if jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("jwt_allowed_algs: unknown algorithm %q", alg)
+		}
+	}
+	return c.JWTAllowedAlgs, nil
+}
+
+func algAllowed(algs []string, alg string) bool {
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksRefreshInterval parses how often the JWKS key set is refreshed
+func (c *Config) jwksRefreshInterval() (time.Duration, error) {
+	if c.JWKSRefreshInterval == "" {
+		return defaultJWKSRefreshInterval, nil
+	}
+	d, err := time.ParseDuration(c.JWKSRefreshInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid jwt_jwks_refresh_interval %q: %w", c.JWKSRefreshInterval, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("jwt_jwks_refresh_interval must be positive, got %q", c.JWKSRefreshInterval)
+	}
+	return d, nil
 }
 
 type ServerConfig struct {
-	Port string `yaml:"port"`
+	Host                   string             `yaml:"host"`
+	Port                   string             `yaml:"port"`
+	RateLimit              *RateLimitConfig   `yaml:"rate_limit"`
+	MetricsPort            string             `yaml:"metrics_port"`
+	MetricsEnabled         *bool              `yaml:"metrics_enabled"`
+	CORS                   *CORSConfig        `yaml:"cors"`
+	TLS                    *TLSConfig         `yaml:"tls"`
+	MaxBodySize            string             `yaml:"max_body_size"`
+	MaxBodyBytes           int64              `yaml:"max_body_bytes"`
+	Tracing                *TracingConfig     `yaml:"tracing"`
+	ShutdownTimeout        string             `yaml:"shutdown_timeout"`
+	DrainDelay             string             `yaml:"drain_delay"`
+	Logging                *LoggingConfig     `yaml:"logging"`
+	Compression            *CompressionConfig `yaml:"compression"`
+	AdminToken             string             `yaml:"admin_token"`
+	RequestIDHeader        string             `yaml:"request_id_header"`
+	TrustRequestID         *bool              `yaml:"trust_request_id"`
+	ReadinessChecks        []string           `yaml:"readiness_checks"`
+	StripRequestHeaders    []string           `yaml:"strip_request_headers"`
+	DefaultResponseHeaders map[string]string  `yaml:"default_response_headers"`
+	ErrorFormat            string             `yaml:"error_format"`
+	Transport              *TransportConfig   `yaml:"transport"`
+	TrustForwardedHeaders  *bool              `yaml:"trust_forwarded_headers"`
+	TrailingSlash          string             `yaml:"trailing_slash"`
+}
+
+// metricsEnabled reports whether /metrics is served; it defaults to true
+func (c ServerConfig) metricsEnabled() bool {
+	return c.MetricsEnabled == nil || *c.MetricsEnabled
+}
+
+// listenAddr combines host and port into the address the server binds to.
+// port may be a bare port such as 8080, or an address with or without a host
+// such as :8080 or 127.0.0.1:8080; a host in both places must agree.
+func (c ServerConfig) listenAddr() (string, error) {
+	if c.Host == "" && c.Port == "" {
+		// net/http's default, :http or :https
+		return "", nil
+	}
+	host, port := "", c.Port
+	if h, p, err := net.SplitHostPort(c.Port); err == nil {
+		host, port = h, p
+	}
+	if c.Host != "" {
+		want := strings.TrimSuffix(strings.TrimPrefix(c.Host, "["), "]")
+		if host != "" && host != want {
+			return "", fmt.Errorf("host %q conflicts with the host in port %q", c.Host, c.Port)
+		}
+		host = want
+	}
+	if port == "" {
+		return "", fmt.Errorf("invalid port %q", c.Port)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return "", fmt.Errorf("invalid port %q", c.Port)
+	}
+	if strings.ContainsAny(host, " /[]") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// defaultShutdownTimeout applies when the server does not set shutdown_timeout
+const defaultShutdownTimeout = 5 * time.Second
+
+// shutdownTimeout is how long in-flight requests may take to finish on shutdown
+func (c ServerConfig) shutdownTimeout() (time.Duration, error) {
+	if c.ShutdownTimeout == "" {
+		return defaultShutdownTimeout, nil
+	}
+	d, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid shutdown_timeout %q", c.ShutdownTimeout)
+	}
+	return d, nil
+}
+
+// drainDelay is how long the gateway keeps serving after SIGTERM with its
+// health endpoints failing, so load balancers stop routing to it first
+func (c ServerConfig) drainDelay() (time.Duration, error) {
+	if c.DrainDelay == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.DrainDelay)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid drain_delay %q", c.DrainDelay)
+	}
+	return d, nil
 }
 
 type ServiceConfig struct {
-	Name         string `yaml:"name"`
-	PathPrefix   string `yaml:"path_prefix"`
-	TargetURL    string `yaml:"target_url"`
-	StripPrefix  string `yaml:"strip_prefix"`
-	AuthRequired bool   `yaml:"auth_required"`
-	EnvVar       string `yaml:"env_var"`
+	Name                    string                 `yaml:"name"`
+	PathPrefix              string                 `yaml:"path_prefix"`
+	Type                    string                 `yaml:"type"`
+	TargetURL               string                 `yaml:"target_url"`
+	TargetURLs              []string               `yaml:"target_urls"`
+	TargetWeights           []int                  `yaml:"target_weights"`
+	Discovery               string                 `yaml:"discovery"`
+	DiscoveryInterval       string                 `yaml:"discovery_interval"`
+	CanaryHeader            string                 `yaml:"canary_header"`
+	Canary                  *CanaryConfig          `yaml:"canary"`
+	Sticky                  *StickyConfig          `yaml:"sticky"`
+	HeaderRoutes            *HeaderRoutesConfig    `yaml:"header_routes"`
+	Match                   []MatchRuleConfig      `yaml:"match"`
+	StripPrefix             string                 `yaml:"strip_prefix"`
+	AuthRequired            bool                   `yaml:"auth_required"`
+	AuthOptional            bool                   `yaml:"auth_optional"`
+	StripAuthorization      bool                   `yaml:"strip_authorization"`
+	PublicPaths             []string               `yaml:"public_paths"`
+	RequireClientCert       bool                   `yaml:"require_client_cert"`
+	EnvVar                  string                 `yaml:"env_var"`
+	Timeout                 string                 `yaml:"timeout"`
+	MaxConcurrent           int                    `yaml:"max_concurrent"`
+	MaxConcurrentWait       string                 `yaml:"max_concurrent_wait"`
+	RequiredRoles           []string               `yaml:"required_roles"`
+	RequiredScopes          []string               `yaml:"required_scopes"`
+	WriteScopes             []string               `yaml:"write_scopes"`
+	ClaimHeaders            map[string]string      `yaml:"claim_headers"`
+	AllowedTenants          []string               `yaml:"allowed_tenants"`
+	RateLimit               *RateLimitConfig       `yaml:"rate_limit"`
+	HealthCheckPath         string                 `yaml:"health_check_path"`
+	HealthCheckInterval     string                 `yaml:"health_check_interval"`
+	Retries                 int                    `yaml:"retries"`
+	RetryBackoff            string                 `yaml:"retry_backoff"`
+	RetryOnStatus           []int                  `yaml:"retry_on_status"`
+	CircuitBreaker          *CircuitBreakerConfig  `yaml:"circuit_breaker"`
+	RetryNonIdempotent      bool                   `yaml:"retry_non_idempotent"`
+	CORS                    *CORSConfig            `yaml:"cors"`
+	WebSocket               bool                   `yaml:"websocket"`
+	Rewrite                 *RewriteConfig         `yaml:"rewrite"`
+	Rewrites                []RewriteConfig        `yaml:"rewrites"`
+	RequireAllRoles         bool                   `yaml:"require_all_roles"`
+	Auth                    string                 `yaml:"auth"`
+	JWTSecret               string                 `yaml:"jwt_secret"`
+	JWKSURL                 string                 `yaml:"jwt_jwks_url"`
+	JWTIssuer               string                 `yaml:"jwt_issuer"`
+	JWTAudience             string                 `yaml:"jwt_audience"`
+	APIKey                  *APIKeyConfig          `yaml:"api_key"`
+	BasicAuth               *BasicAuthConfig       `yaml:"basic_auth"`
+	ForwardAuthURL          string                 `yaml:"forward_auth_url"`
+	CopyHeaders             []string               `yaml:"copy_headers"`
+	ForwardAuthTimeout      string                 `yaml:"forward_auth_timeout"`
+	MaxBodySize             string                 `yaml:"max_body_size"`
+	MaxBodyBytes            int64                  `yaml:"max_body_bytes"`
+	AllowIPs                []string               `yaml:"allow_ips"`
+	DenyIPs                 []string               `yaml:"deny_ips"`
+	Cache                   *CacheConfig           `yaml:"cache"`
+	Compression             *CompressionConfig     `yaml:"compression"`
+	AllowedMethods          []string               `yaml:"allowed_methods"`
+	AddResponseHeaders      map[string]string      `yaml:"add_response_headers"`
+	OverrideResponseHeaders bool                   `yaml:"override_response_headers"`
+	FallbackURL             string                 `yaml:"fallback_url"`
+	FallbackOnStatus        []int                  `yaml:"fallback_on_status"`
+	GRPC                    bool                   `yaml:"grpc"`
+	Status                  int                    `yaml:"status"`
+	ContentType             string                 `yaml:"content_type"`
+	Body                    string                 `yaml:"body"`
+	BodyFile                string                 `yaml:"body_file"`
+	RequestHeaders          *RequestHeadersConfig  `yaml:"request_headers"`
+	ResponseHeaders         *ResponseHeadersConfig `yaml:"response_headers"`
+	Transform               *TransformConfig       `yaml:"transform"`
+	UpstreamTLS             *UpstreamTLSConfig     `yaml:"upstream_tls"`
 }
 
-var logger *slog.Logger
+// targets returns every upstream url of the service; target_url is kept as
+// the single-upstream shorthand
+func (s ServiceConfig) targets() []string {
+	if len(s.TargetURLs) > 0 {
+		return s.TargetURLs
+	}
+	if s.TargetURL == "" {
+		return nil
+	}
+	return []string{s.TargetURL}
+}
 
-// read config file and apply env overrides
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+// defaultUpstreamTimeout applies when a service does not set timeout
+const defaultUpstreamTimeout = 30 * time.Second
+
+// upstreamTimeout parses the per-request deadline for calls to the service.
+// Zero disables the deadline, e.g. for streaming endpoints.
+func (s ServiceConfig) upstreamTimeout() (time.Duration, error) {
+	if s.Timeout == "" {
+		return defaultUpstreamTimeout, nil
+	}
+	d, err := time.ParseDuration(s.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %w", s.Timeout, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("timeout must not be negative, got %q", s.Timeout)
+	}
+	return d, nil
+}
+
+// healthCheckInterval parses how often upstreams are probed
+func (s ServiceConfig) healthCheckInterval() (time.Duration, error) {
+	if s.HealthCheckInterval == "" {
+		return defaultHealthCheckInterval, nil
+	}
+	d, err := time.ParseDuration(s.HealthCheckInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid health_check_interval %q: %w", s.HealthCheckInterval, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("health_check_interval must be positive, got %q", s.HealthCheckInterval)
+	}
+	return d, nil
+}
+
+// retryBackoff parses the pause between retry attempts
+func (s ServiceConfig) retryBackoff() (time.Duration, error) {
+	if s.RetryBackoff == "" {
+		return defaultRetryBackoff, nil
+	}
+	d, err := time.ParseDuration(s.RetryBackoff)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return 0, fmt.Errorf("invalid retry_backoff %q: %w", s.RetryBackoff, err)
 	}
+	if d < 0 {
+		return 0, fmt.Errorf("retry_backoff must not be negative, got %q", s.RetryBackoff)
+	}
+	return d, nil
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config yaml: %w", err)
+func (s ServiceConfig) validateRetries() error {
+	if s.Retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", s.Retries)
 	}
+	if _, err := s.retryBackoff(); err != nil {
+		return err
+	}
+	for _, code := range s.RetryOnStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("retry_on_status: invalid status code %d", code)
+		}
+	}
+	return nil
+}
+
+var logger = slog.Default()
+
+// read config file, or the files path names, and apply env overrides
+func loadConfig(path string) (*Config, error) {
+	c, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := *c
 
 	// Environment overrides
 	if secret := os.Getenv("JWT_SECRET"); secret != "" {
 		cfg.JWTSecret = secret
 	}
+	if jwksURL := os.Getenv("JWT_JWKS_URL"); jwksURL != "" {
+		cfg.JWKSURL = jwksURL
+	}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		cfg.Server.AdminToken = token
+	}
+	if secret := os.Getenv("INTROSPECTION_CLIENT_SECRET"); secret != "" && cfg.Introspection != nil {
+		cfg.Introspection.ClientSecret = secret
+	}
+	if _, err := cfg.jwksRefreshInterval(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.jwtLeeway(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.allowedAlgs(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.tokenSources(); err != nil {
+		return nil, err
+	}
+	if err := validateClaimHeaders(cfg.ClaimHeaders, false); err != nil {
+		return nil, err
+	}
+	if err := cfg.Tenant.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Introspection != nil {
+		if err := cfg.Introspection.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Revocation != nil {
+		if err := cfg.Revocation.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Server.RateLimit != nil {
+		if err := cfg.Server.RateLimit.validate(); err != nil {
+			return nil, fmt.Errorf("server: %w", err)
+		}
+	}
+	if cfg.Server.CORS != nil {
+		if err := cfg.Server.CORS.validate(); err != nil {
+			return nil, fmt.Errorf("server: %w", err)
+		}
+	}
+	if cfg.Server.TLS != nil {
+		if err := cfg.Server.TLS.validate(); err != nil {
+			return nil, fmt.Errorf("server: %w", err)
+		}
+	}
+	if err := cfg.Server.Tracing.validate(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if _, err := cfg.Server.shutdownTimeout(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if _, err := cfg.Server.drainDelay(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if err := validateHeaderNames("default_response_headers", cfg.Server.DefaultResponseHeaders); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if _, err := cfg.Server.listenAddr(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if err := validateErrorFormat(cfg.Server.ErrorFormat); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if err := validateTrailingSlash(cfg.Server.TrailingSlash); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if err := cfg.Server.Transport.validate(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if err := cfg.Server.Logging.validate(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	if err := cfg.Server.Compression.validate(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
 
 	for i := range cfg.Services {
 		env := cfg.Services[i].EnvVar
@@ -67,210 +456,1248 @@ func loadConfig(path string) (*Config, error) {
 			n := strings.ToUpper(strings.ReplaceAll(cfg.Services[i].Name, "-", "_"))
 			env = n + "_SERVICE_URL"
 		}
-		if v := os.Getenv(env); v != "" {
-			cfg.Services[i].TargetURL = v
+		if v := os.Getenv(env); v != "" && !cfg.Services[i].static() {
+			// a comma separated value overrides the whole target list
+			cfg.Services[i].TargetURL, cfg.Services[i].TargetURLs = "", nil
+			if urls := strings.Split(v, ","); len(urls) > 1 {
+				for _, u := range urls {
+					if u = strings.TrimSpace(u); u != "" {
+						cfg.Services[i].TargetURLs = append(cfg.Services[i].TargetURLs, u)
+					}
+				}
+			} else {
+				cfg.Services[i].TargetURL = v
+			}
 			logger.Info("service url overridden from env", "service", cfg.Services[i].Name, "var", env)
 		}
+		if cfg.Services[i].TargetURL != "" && len(cfg.Services[i].TargetURLs) > 0 {
+			return nil, fmt.Errorf("service %s: set either target_url or target_urls, not both", cfg.Services[i].Name)
+		}
+		if err := cfg.Services[i].validateWeights(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateStatic(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if c := cfg.Services[i].Canary; c != nil {
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if err := cfg.Services[i].Compression.validate(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if c := cfg.Services[i].Cache; c != nil {
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if hr := cfg.Services[i].HeaderRoutes; hr != nil {
+			if !cfg.Services[i].authenticates() {
+				return nil, fmt.Errorf("service %s: header_routes needs auth_required or auth_optional", cfg.Services[i].Name)
+			}
+			if err := hr.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if err := cfg.Services[i].validateMatch(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if len(cfg.Services[i].RequiredRoles) > 0 && !cfg.Services[i].AuthRequired {
+			return nil, fmt.Errorf("service %s: required_roles needs auth_required: true", cfg.Services[i].Name)
+		}
+		if k := cfg.Services[i].APIKey; k != nil {
+			k.loadEnvKeys()
+		}
+		if err := cfg.Services[i].validateAuth(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if cfg.Services[i].RequireAllRoles && len(cfg.Services[i].RequiredRoles) == 0 {
+			return nil, fmt.Errorf("service %s: require_all_roles needs required_roles", cfg.Services[i].Name)
+		}
+		if err := cfg.Services[i].validateScopes(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateClaimHeaders(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateTenants(cfg.Tenant); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if _, err := cfg.Services[i].upstreamTimeout(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateMaxConcurrent(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateRetries(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateFallback(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateGRPC(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if _, err := cfg.Services[i].healthCheckInterval(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if cb := cfg.Services[i].CircuitBreaker; cb != nil {
+			if err := cb.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if rl := cfg.Services[i].RateLimit; rl != nil {
+			if err := rl.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if c := cfg.Services[i].CORS; c != nil {
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if _, err := cfg.Services[i].maxBodySize(cfg.Server); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if _, err := newIPFilter(cfg.Services[i].AllowIPs, cfg.Services[i].DenyIPs); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if _, err := cfg.Services[i].rewriteRules(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if _, err := cfg.Services[i].allowedMethods(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := validateHeaderNames("add_response_headers", cfg.Services[i].AddResponseHeaders); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if _, err := cfg.Services[i].RequestHeaders.edits(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].ResponseHeaders.validate(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if t := cfg.Services[i].Transform; t != nil {
+			if err := t.validate(); err != nil {
+				return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+			}
+		}
+		if err := cfg.Services[i].UpstreamTLS.validate(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].Sticky.validate(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateDiscovery(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validatePublicPaths(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateJWT(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+		if err := cfg.Services[i].validateClientCert(cfg.Server); err != nil {
+			return nil, fmt.Errorf("service %s: %w", cfg.Services[i].Name, err)
+		}
+	}
+	if err := cfg.validateRedirects(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateReadinessChecks(); err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	problems, warnings := checkServices(cfg.Services)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	for _, w := range warnings {
+		logger.Warn("config warning", "warning", w)
 	}
 
 	return &cfg, nil
 }
 
-func newProxy(targetURL, stripPrefix string) (*httputil.ReverseProxy, error) {
-	target, err := url.Parse(targetURL)
+// serviceProxy forwards requests for one service to its upstreams
+type serviceProxy struct {
+	name    string
+	lb      *balancer
+	breaker *circuitBreaker
+	proxy   *httputil.ReverseProxy
+	// stickyHeader names the request header whose value pins the upstream
+	stickyHeader string
+	// canaryWeight is the percentage of requests sent to lb.canary
+	canaryWeight int
+	// sticky pins clients to an upstream by cookie
+	sticky *stickySessions
+	// discovery re-resolves the upstreams of dns-srv services
+	discovery *srvDiscovery
+	// routes overrides the upstream for callers asking for one by header
+	routes *headerRoutes
+	// matches sends requests with given header values to other targets
+	matches *matchRules
+	// transport is the shared upstream transport, or the service's own with
+	// upstream_tls
+	transport *http.Transport
+}
+
+// breakerSnapshot reports the service's circuit breaker, if it has one
+func (p *serviceProxy) breakerSnapshot() (breakerSnapshot, bool) {
+	if p.breaker == nil {
+		return breakerSnapshot{}, false
+	}
+	return p.breaker.snapshot(), true
+}
+
+func (p *serviceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key string
+	if p.stickyHeader != "" {
+		key = r.Header.Get(p.stickyHeader)
+	}
+	var u *upstream
+	if p.routes != nil {
+		u = p.routes.match(r)
+	}
+	if u == nil && p.matches != nil {
+		u = p.matches.match(r)
+	}
+	if u == nil && p.lb.canary != nil {
+		backend := backendStable
+		if inCanary(canaryKey(r, p.stickyHeader), p.canaryWeight) {
+			// an unusable canary hands its traffic back to the stable upstreams
+			if u = p.lb.canaryUpstream(); u != nil {
+				backend = backendCanary
+			}
+		}
+		w.Header().Set(backendHeader, backend)
+		backendRequestsTotal.WithLabelValues(p.name, backend).Inc()
+	}
+	if u == nil && p.sticky != nil {
+		u = p.sticky.pinned(r, p.lb)
+	}
+	if u == nil {
+		if u = p.lb.pick(key); u != nil && p.sticky != nil {
+			p.sticky.pin(w, u)
+		}
+	}
+	if u == nil {
+		p.discovery.refresh()
+		writeError(w, r, http.StatusServiceUnavailable, "no healthy upstream available")
+		return
+	}
+	if p.breaker != nil {
+		if ok, wait := p.breaker.allow(); !ok {
+			setRetryAfter(w, wait)
+			writeError(w, r, http.StatusServiceUnavailable, "service temporarily unavailable")
+			return
+		}
+	}
+	logUpstream(r, u.url.String())
+	r = r.WithContext(context.WithValue(r.Context(), upstreamKey, u))
+	restorePeerAddr(r)
+	p.proxy.ServeHTTP(w, r)
+}
+
+// newProxy builds the proxy of a service; transport is shared by all of them
+// unless the service sets upstream_tls
+func newProxy(s ServiceConfig, server ServerConfig, transport *http.Transport) (*serviceProxy, error) {
+	var (
+		lb        *balancer
+		discovery *srvDiscovery
+		err       error
+	)
+	if s.discovers() {
+		// without targets yet the service answers 503 until the first
+		// resolve in discovery.run succeeds
+		lb = &balancer{}
+		if discovery, err = newSRVDiscovery(s, lb); err != nil {
+			return nil, err
+		}
+	} else if lb, err = newBalancer(s.targets()); err != nil {
+		return nil, err
+	}
+	if len(s.TargetWeights) > 0 {
+		if err := s.validateWeights(); err != nil {
+			return nil, err
+		}
+		lb.setWeights(s.TargetWeights)
+	}
+	var canaryWeight int
+	if s.Canary != nil {
+		if err := s.Canary.validate(); err != nil {
+			return nil, err
+		}
+		if lb.canary, err = newUpstream(s.Canary.TargetURL); err != nil {
+			return nil, err
+		}
+		canaryWeight = s.Canary.Weight
+	}
+	var sticky *stickySessions
+	if s.Sticky != nil {
+		if sticky, err = newStickySessions(s.Sticky, s.PathPrefix); err != nil {
+			return nil, err
+		}
+	}
+	var matches *matchRules
+	if len(s.Match) > 0 {
+		if matches, err = newMatchRules(s); err != nil {
+			return nil, err
+		}
+	}
+	timeout, err := s.upstreamTimeout()
 	if err != nil {
-		return nil, fmt.Errorf("invalid target url: %w", err)
+		return nil, err
+	}
+	var breaker *circuitBreaker
+	if s.CircuitBreaker != nil {
+		if breaker, err = newCircuitBreaker(s.Name, *s.CircuitBreaker); err != nil {
+			return nil, err
+		}
+	}
+	rewrites, err := s.rewriteRules()
+	if err != nil {
+		return nil, err
+	}
+	headerEdits, err := s.RequestHeaders.edits()
+	if err != nil {
+		return nil, err
+	}
+	var transform *transformer
+	if s.Transform != nil {
+		if transform, err = newTransformer(s.Transform); err != nil {
+			return nil, err
+		}
 	}
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	orig := proxy.Director
+	proxy := &httputil.ReverseProxy{}
 	proxy.Director = func(req *http.Request) {
-		// keep user headers
-		sub := req.Header.Get("X-User-Subject")
-		userId := req.Header.Get("X-User-Id")
-		roles := req.Header.Get("X-User-Roles")
+		u := req.Context().Value(upstreamKey).(*upstream)
+		u.direct(req)
+		setForwardedHeaders(req)
+		req.Host = u.url.Host
+		// only the path changes; the query string is kept as is
+		rewriteURL(req.URL, s.StripPrefix, rewrites)
+		// without the Upgrade header the proxy treats the request as plain
+		// HTTP, so only websocket services get an upgraded connection
+		if !s.WebSocket || !isWebSocketUpgrade(req.Header) {
+			req.Header.Del("Upgrade")
+		}
+		// the gateway verified the token; the upstream has no use for it
+		if s.StripAuthorization {
+			req.Header.Del("Authorization")
+			if src, ok := requestTokenSource(req); ok && src.cookie != "" {
+				removeCookie(req, src.cookie)
+			}
+		}
+		if headerEdits != nil {
+			headerEdits.apply(req.Header)
+		}
+		if transform != nil {
+			transform.transformRequest(req)
+		}
+	}
 
-		orig(req)
-		req.Host = target.Host
-		if sub != "" {
-			req.Header.Set("X-User-Subject", sub)
+	if s.UpstreamTLS != nil {
+		if transport, err = newServiceTransport(transport, s.UpstreamTLS); err != nil {
+			return nil, err
 		}
-		if userId != "" {
-			req.Header.Set("X-User-Id", userId)
+	}
+	proxy.Transport = transport
+	if s.GRPC {
+		if err := s.validateGRPC(); err != nil {
+			return nil, err
+		}
+		proxy.Transport = newGRPCTransport(transport.TLSClientConfig)
+		// streamed messages must reach the client as they arrive
+		proxy.FlushInterval = -1
+	}
+	if s.Retries > 0 {
+		backoff, err := s.retryBackoff()
+		if err != nil {
+			return nil, err
 		}
-		if roles != "" {
-			req.Header.Set("X-User-Roles", roles)
+		statuses := s.RetryOnStatus
+		if len(statuses) == 0 {
+			statuses = defaultRetryStatuses
 		}
-		if stripPrefix != "" {
-			req.URL.Path = strings.TrimPrefix(req.URL.Path, stripPrefix)
+		proxy.Transport = &retryTransport{
+			base:          proxy.Transport,
+			service:       s.Name,
+			retries:       s.Retries,
+			backoff:       backoff,
+			statuses:      statuses,
+			nonIdempotent: s.RetryNonIdempotent,
 		}
 	}
+	if s.FallbackURL != "" {
+		if err := s.validateFallback(); err != nil {
+			return nil, err
+		}
+		statuses := s.FallbackOnStatus
+		if len(statuses) == 0 {
+			statuses = defaultRetryStatuses
+		}
+		// validated above
+		fallback, _ := url.Parse(s.FallbackURL)
+		proxy.Transport = &fallbackTransport{
+			base:     proxy.Transport,
+			service:  s.Name,
+			fallback: fallback,
+			statuses: statuses,
+		}
+	}
+	// the deadline wraps retries and the fallback so every attempt shares one
+	// overall budget
+	if timeout > 0 {
+		proxy.Transport = &deadlineTransport{base: proxy.Transport, timeout: timeout}
+	}
+	proxy.Transport = &tracingTransport{base: proxy.Transport, service: s.Name}
 
+	responseHeaders := s.responseHeaders(server)
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		logger.Info("response from downstream", "service", targetURL, "status", resp.Status, "path", resp.Request.URL.Path)
+		ctx := resp.Request.Context()
+		dropUpstreamRequestID(resp)
+		setResponseHeaders(resp.Header, responseHeaders, s.OverrideResponseHeaders)
+		logger.InfoContext(ctx, "response from downstream", "service", s.Name, "upstream", resp.Request.URL.Host, "status", resp.Status, "path", resp.Request.URL.Path, "request_id", middleware.GetReqID(ctx))
+		s.ResponseHeaders.apply(resp.Header)
+		if transform != nil {
+			transform.transformResponse(resp)
+		}
+		if breaker != nil {
+			breaker.record(resp.StatusCode < http.StatusInternalServerError)
+		}
 		return nil
 	}
 
-	return proxy, nil
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		trace.SpanFromContext(r.Context()).RecordError(err)
+		// the client sent too much; neither the upstream nor the breaker is at fault
+		if limit, ok := bodyTooLarge(err); ok {
+			if breaker != nil {
+				breaker.release()
+			}
+			writeBodyTooLarge(w, r, limit)
+			return
+		}
+		if !errors.Is(err, context.Canceled) {
+			if u, ok := r.Context().Value(upstreamKey).(*upstream); ok {
+				u.markFailed()
+			}
+			if lb.exhausted() {
+				discovery.refresh()
+			}
+		}
+		if breaker != nil {
+			if errors.Is(err, context.Canceled) {
+				breaker.release()
+			} else {
+				breaker.record(false)
+			}
+		}
+		// the client went away or shutdown cut the request off; the upstream
+		// isn't at fault and nobody is left to read a response
+		if errors.Is(err, context.Canceled) {
+			logger.InfoContext(r.Context(), "downstream request cancelled", "service", s.Name, "upstream", r.URL.Host, "path", r.URL.Path, "request_id", middleware.GetReqID(r.Context()))
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if isTimeout(err) {
+			upstreamErrorsTotal.WithLabelValues(s.Name, s.PathPrefix, "timeout").Inc()
+			logger.WarnContext(r.Context(), "downstream timed out", "service", s.Name, "upstream", r.URL.Host, "path", r.URL.Path, "timeout", timeout, "request_id", middleware.GetReqID(r.Context()), "err", err)
+			writeError(w, r, http.StatusGatewayTimeout, fmt.Sprintf("upstream service %s timed out", s.Name))
+			return
+		}
+		upstreamErrorsTotal.WithLabelValues(s.Name, s.PathPrefix, "error").Inc()
+		// nothing is listening, or the upstream cannot be reached at all
+		if isDialError(err) {
+			logger.ErrorContext(r.Context(), "downstream connection failed", "service", s.Name, "upstream", r.URL.Host, "path", r.URL.Path, "request_id", middleware.GetReqID(r.Context()), "err", err)
+			writeError(w, r, http.StatusBadGateway, "upstream service unavailable")
+			return
+		}
+		logger.ErrorContext(r.Context(), "downstream request failed", "service", s.Name, "upstream", r.URL.Host, "path", r.URL.Path, "request_id", middleware.GetReqID(r.Context()), "err", err)
+		writeError(w, r, http.StatusBadGateway, "upstream service error")
+	}
+
+	return &serviceProxy{
+		name:         s.Name,
+		lb:           lb,
+		breaker:      breaker,
+		proxy:        proxy,
+		stickyHeader: s.CanaryHeader,
+		canaryWeight: canaryWeight,
+		sticky:       sticky,
+		matches:      matches,
+		discovery:    discovery,
+		transport:    transport,
+	}, nil
 }
 
 // auth
 type contextKey string
 
-const userClaimsKey contextKey = "userClaims"
+const (
+	userClaimsKey contextKey = "userClaims"
+	upstreamKey   contextKey = "upstream"
+)
+
+// newKeyFunc selects the verification key for a token. When jwt_jwks_url is
+// set it takes precedence and only RSA, ECDSA and EdDSA tokens from the key
+// set are accepted; otherwise HMAC tokens are verified with jwt_secret. Which
+// algorithms of these are allowed is left to the parser.
+func newKeyFunc(cfg *Config) (jwt.Keyfunc, error) {
+	secret := []byte(cfg.JWTSecret)
+	var jwks *jwksCache
+	if cfg.JWKSURL != "" {
+		interval, err := cfg.jwksRefreshInterval()
+		if err != nil {
+			return nil, err
+		}
+		jwks = newJWKSCache(cfg.JWKSURL, interval)
+	}
+
+	return func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if jwks != nil {
+				return nil, fmt.Errorf("unexpected signing method: %v (jwt_jwks_url takes precedence over jwt_secret, only RSA/ECDSA tokens are accepted)", token.Header["alg"])
+			}
+			if len(secret) == 0 {
+				return nil, fmt.Errorf("unexpected signing method: %v (jwt_secret is not configured)", token.Header["alg"])
+			}
+			return secret, nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+			if jwks == nil {
+				return nil, fmt.Errorf("unexpected signing method: %v (jwt_jwks_url is not configured)", token.Header["alg"])
+			}
+			kid, _ := token.Header["kid"].(string)
+			return jwks.key(kid)
+		}
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}, nil
+}
+
+// newAuthMiddleware verifies bearer tokens with the jwt settings of cfg and
+// rejects those in revocations
+func newAuthMiddleware(cfg *Config, revocations revocationStore) (func(http.Handler) http.Handler, error) {
+	keyFunc, err := newKeyFunc(cfg)
+	if err != nil {
+		return nil, err
+	}
+	leeway, err := cfg.jwtLeeway()
+	if err != nil {
+		return nil, err
+	}
+	algs, err := cfg.allowedAlgs()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := cfg.tokenSources()
+	if err != nil {
+		return nil, err
+	}
+	return authMiddleware(authOptions{
+		keyFunc:     keyFunc,
+		issuer:      cfg.JWTIssuer,
+		audience:    cfg.JWTAudience,
+		leeway:      leeway,
+		algs:        algs,
+		sources:     sources,
+		revocations: revocations,
+	}), nil
+}
+
+// authOptions controls how authMiddleware verifies bearer tokens
+type authOptions struct {
+	keyFunc jwt.Keyfunc
+	// issuer and audience are only checked when set
+	issuer   string
+	audience string
+	// leeway is the clock skew tolerated when checking exp, nbf and iat
+	leeway time.Duration
+	// algs are the accepted alg header values
+	algs []string
+	// sources are tried in order for the token; nil means the Authorization header
+	sources []tokenSource
+	// revocations, if set, lists tokens rejected despite a valid signature
+	revocations revocationStore
+}
 
-func authMiddleware(secret []byte) func(http.Handler) http.Handler {
+func authMiddleware(opts authOptions) func(http.Handler) http.Handler {
+	// exp, nbf and iat are checked below, with the leeway
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods(opts.algs))
+	sources := opts.sources
+	if len(sources) == 0 {
+		sources = []tokenSource{{}}
+	}
+	var failures *rateLimiter
+	for _, src := range sources {
+		if src.query != "" {
+			failures = newQueryTokenFailureLimiter()
+		}
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			auth := r.Header.Get("Authorization")
-			if auth == "" {
-				http.Error(w, "Missing Authorization Header", http.StatusUnauthorized)
+			tok, src, err := findToken(sources, r)
+			if err != nil {
+				writeError(w, r, http.StatusUnauthorized, "Invalid Authorization Header format")
 				return
 			}
-			tok, found := strings.CutPrefix(auth, "Bearer ")
-			if !found {
-				http.Error(w, "Invalid Authorization Header format", http.StatusUnauthorized)
+			if tok == "" {
+				writeError(w, r, http.StatusUnauthorized, missingTokenMessage(sources))
 				return
 			}
-			p, err := jwt.Parse(tok, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			ip := "ip:" + clientIP(r)
+			if src.query != "" {
+				if wait := failures.wait(ip); wait > 0 {
+					logger.Warn("token rejected", "reason", "too many failed query tokens", "remote_addr", r.RemoteAddr)
+					setRetryAfter(w, wait)
+					writeError(w, r, http.StatusTooManyRequests, "too many failed token attempts")
+					return
+				}
+			}
+			reject := func(msg string) {
+				if src.query != "" {
+					failures.allow(ip)
 				}
-				return secret, nil
-			})
+				writeError(w, r, http.StatusUnauthorized, msg)
+			}
+			p, err := parser.Parse(tok, opts.keyFunc)
 			if err != nil {
-				logger.Warn("error parsing token", "err", err)
-				http.Error(w, "Invalid Token", http.StatusUnauthorized)
+				var alg string
+				if p != nil {
+					alg, _ = p.Header["alg"].(string)
+				}
+				switch {
+				case strings.EqualFold(alg, "none"):
+					logger.Warn("token rejected", "reason", "unsigned token (alg none)", "remote_addr", r.RemoteAddr)
+				case alg != "" && !algAllowed(opts.algs, alg):
+					logger.Warn("token rejected", "reason", "signing algorithm not allowed", "alg", alg, "allowed", opts.algs)
+				default:
+					logger.Warn("error parsing token", "err", err)
+				}
+				reject("Invalid Token")
 				return
 			}
 			if claims, ok := p.Claims.(jwt.MapClaims); ok && p.Valid {
+				now := time.Now()
+				if !claims.VerifyExpiresAt(now.Add(-opts.leeway).Unix(), false) {
+					logger.Warn("token rejected", "reason", "expired", "exp", claims["exp"], "leeway", opts.leeway)
+					logTimeClaim(r, claims, "exp", now, opts.leeway)
+					reject("Token Expired")
+					return
+				}
+				var early string
+				switch {
+				case !claims.VerifyNotBefore(now.Add(opts.leeway).Unix(), false):
+					early = "nbf"
+				case !claims.VerifyIssuedAt(now.Add(opts.leeway).Unix(), false):
+					early = "iat"
+				}
+				if early != "" {
+					logger.Warn("token rejected", "reason", "not yet valid", "nbf", claims["nbf"], "iat", claims["iat"], "leeway", opts.leeway)
+					logTimeClaim(r, claims, early, now, opts.leeway)
+					reject("Token Not Yet Valid")
+					return
+				}
+				// the reason is only logged so clients can't probe which check failed
+				if opts.issuer != "" && !claims.VerifyIssuer(opts.issuer, true) {
+					logger.Warn("token rejected", "reason", "issuer mismatch", "iss", claims["iss"], "expected", opts.issuer)
+					reject("Invalid Token")
+					return
+				}
+				if opts.audience != "" && !claims.VerifyAudience(opts.audience, true) {
+					logger.Warn("token rejected", "reason", "audience mismatch", "aud", claims["aud"], "expected", opts.audience)
+					reject("Invalid Token")
+					return
+				}
+				if opts.revocations != nil && opts.revocations.revoked(claims, now) {
+					logger.Warn("token rejected", "reason", "revoked", "jti", claims["jti"], "sub", claims["sub"])
+					reject("Token Revoked")
+					return
+				}
 				ctx := context.WithValue(r.Context(), userClaimsKey, claims)
-				next.ServeHTTP(w, r.WithContext(ctx))
+				ctx = context.WithValue(ctx, tokenSourceKey{}, src)
+				r = r.WithContext(ctx)
+				// keep the token out of upstream access logs
+				if src.query != "" {
+					r.URL = withoutQueryParam(r.URL, src.query)
+				}
+				next.ServeHTTP(w, r)
 				return
 			}
-			http.Error(w, "Invalid Token", http.StatusUnauthorized)
+			reject("Invalid Token")
 		})
 	}
 }
 
-func injectUserInfo(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if claims, ok := r.Context().Value(userClaimsKey).(jwt.MapClaims); ok {
-			if sub, exists := claims["sub"]; exists {
-				userIdStr := fmt.Sprintf("%v", sub)
-				// Set both headers for compatibility with different services
-				r.Header.Set("X-User-Subject", userIdStr)
-				r.Header.Set("X-User-Id", userIdStr)
-			}
-			if roles, exists := claims["roles"]; exists {
-				if rs, ok := roles.([]interface{}); ok {
-					var parts []string
-					for _, r := range rs {
-						parts = append(parts, fmt.Sprintf("%v", r))
-					}
-					r.Header.Set("X-User-Roles", strings.Join(parts, ","))
+// logTimeClaim logs at debug level which time claim failed and by how much
+// it misses now, beyond the leeway. A few seconds point at a clock running
+// ahead on the issuer rather than at a genuinely expired token.
+func logTimeClaim(r *http.Request, claims jwt.MapClaims, name string, now time.Time, leeway time.Duration) {
+	if !logger.Enabled(r.Context(), slog.LevelDebug) {
+		return
+	}
+	v, ok := claims[name].(float64)
+	if !ok {
+		logger.DebugContext(r.Context(), "token time claim invalid", "claim", name, "value", claims[name])
+		return
+	}
+	// claims have whole seconds, so compare whole seconds
+	off := time.Duration(int64(v)-now.Unix()) * time.Second
+	if name == "exp" {
+		off = -off
+	}
+	logger.DebugContext(r.Context(), "token time claim failed", "claim", name, "value", time.Unix(int64(v), 0).UTC().Format(time.RFC3339), "now", now.UTC().Format(time.RFC3339), "off_by", off.String(), "leeway", leeway.String())
+}
+
+// userHeaders carry identity to upstreams and may only be set by the gateway
+var userHeaders = []string{"X-User-Subject", "X-User-Id", "X-User-Roles", clientIDHeader, clientCNHeader, clientSANHeader}
+
+// stripRequestHeaders drops client-supplied identity headers, and the extra
+// headers configured in strip_request_headers, at the edge so upstreams can
+// trust them
+func stripRequestHeaders(extra []string) func(http.Handler) http.Handler {
+	headers := append(append([]string{}, userHeaders...), extra...)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, h := range headers {
+				r.Header.Del(h)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// injectUserInfo passes the verified identity upstream: sub and roles as the
+// X-User-* headers, then the claims of claimHeaders, which may replace them
+func injectUserInfo(rolesClaim string, claimHeaders []claimHeader) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := r.Context().Value(userClaimsKey).(jwt.MapClaims); ok {
+				if sub, exists := claims["sub"]; exists {
+					userIdStr := fmt.Sprintf("%v", sub)
+					// Set both headers for compatibility with different services
+					r.Header.Set("X-User-Subject", userIdStr)
+					r.Header.Set("X-User-Id", userIdStr)
+					logSubject(r, userIdStr)
 				}
+				if roles := claimRoles(claims, rolesClaim); len(roles) > 0 {
+					r.Header.Set("X-User-Roles", strings.Join(roles, ","))
+				}
+				setClaimHeaders(r.Header, claims, claimHeaders)
+				logger.Info("injecting user info headers", "sub", r.Header.Get("X-User-Subject"), "user-id", r.Header.Get("X-User-Id"))
 			}
-			logger.Info("injecting user info headers", "sub", r.Header.Get("X-User-Subject"), "user-id", r.Header.Get("X-User-Id"))
-		}
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 func main() {
-	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid LOG_LEVEL %q, want debug, info, warn or error\n", v)
+			os.Exit(1)
+		}
+	}
+	logger = slog.New(traceLogHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})})
 	slog.SetDefault(logger)
 
 	// Command line flags
-	cfgPath := flag.String("config", "config.yaml", "Path to configuration yaml")
+	cfgPath := flag.String("config", "config.yaml", "Path to configuration yaml, a directory of *.yaml files or a glob")
 	overridePort := flag.String("port", "", "Optional: override server port (e.g. :8080)")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "How often to check the config file for changes; 0 disables watching")
+	validateOnly := flag.Bool("validate", false, "Check the config, print a report and exit non-zero if it has errors")
+	printRoutesOnly := flag.Bool("print-routes", false, "Print the routes the config registers and exit")
+	routesFormat := flag.String("routes-format", "table", "Output of -print-routes: table or json")
 	flag.Parse()
 
+	if *validateOnly {
+		if !validateConfigFile(*cfgPath, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg, err := loadConfig(*cfgPath)
 	if err != nil {
 		logger.Error("failed to load config", "error", err)
 		os.Exit(1)
 	}
 
+	if *printRoutesOnly {
+		if *routesFormat != "table" && *routesFormat != "json" {
+			fmt.Fprintf(os.Stderr, "invalid -routes-format %q, want table or json\n", *routesFormat)
+			os.Exit(1)
+		}
+		if err := printRoutes(cfg, os.Stdout, *routesFormat == "json"); err != nil {
+			logger.Error("failed to print routes", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Port override from flags
 	if *overridePort != "" {
 		cfg.Server.Port = *overridePort
 	}
+	addr, err := cfg.Server.listenAddr()
+	if err != nil {
+		logger.Error("invalid listen address", "err", err)
+		os.Exit(1)
+	}
 
-	r := buildRouter(cfg)
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Server.Tracing)
+	if err != nil {
+		logger.Error("failed to configure tracing", "err", err)
+		os.Exit(1)
+	}
 
+	routerCtx, cancelRouter := context.WithCancel(context.Background())
+	r, err := buildRouter(routerCtx, cfg)
+	if err != nil {
+		logger.Error("failed to build router", "err", err)
+		os.Exit(1)
+	}
+	handler := &routerSwitch{}
+	handler.store(r, cancelRouter)
+
+	conns := newConnTracker()
+	// requests derive their context from baseCtx so a shutdown that runs out
+	// of time can cancel them, aborting their upstream calls
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	srv := &http.Server{
-		Addr:    cfg.Server.Port,
-		Handler: r,
+		Addr:        addr,
+		Handler:     serveH2C(handler),
+		ConnState:   conns.track,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	var httpSrv *http.Server
+	var certs *certReloader
+	if t := cfg.Server.TLS; t != nil {
+		certs, err = newCertReloader(t)
+		if err != nil {
+			logger.Error("failed to configure tls", "err", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig = certs.tlsConfig()
+		if err := t.applyClientAuth(srv.TLSConfig); err != nil {
+			logger.Error("failed to configure tls", "err", err)
+			os.Exit(1)
+		}
+		if t.HTTPPort != "" {
+			httpSrv = &http.Server{Addr: t.HTTPPort, Handler: handler}
+			if t.RedirectHTTP {
+				httpSrv.Handler = httpsRedirect(addr)
+			}
+		}
 	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var metricsSrv *http.Server
+	if cfg.Server.metricsEnabled() && cfg.Server.MetricsPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		metricsSrv = &http.Server{Addr: cfg.Server.MetricsPort, Handler: mux}
+		go func() {
+			logger.Info("metrics listening", "addr", metricsSrv.Addr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("metrics listen error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	go func() {
-		logger.Info("api-gateway listening", "addr", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("api-gateway listening", "addr", srv.Addr, "tls", srv.TLSConfig != nil)
+		var err error
+		if srv.TLSConfig != nil {
+			// the certificate is already loaded into srv.TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("listen error", "err", err)
 			os.Exit(1)
 		}
 	}()
+	if httpSrv != nil {
+		go func() {
+			logger.Info("api-gateway http listening", "addr", httpSrv.Addr, "redirect", cfg.Server.TLS.RedirectHTTP)
+			if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("http listen error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// a nil channel never fires, leaving SIGHUP as the only reload trigger
+	var changed, certChanged, keyChanged <-chan struct{}
+	if *watchInterval > 0 {
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		changed = watchFile(watchCtx, *cfgPath, *watchInterval)
+		if certs != nil {
+			certChanged = watchFile(watchCtx, certs.certFile, *watchInterval)
+			keyChanged = watchFile(watchCtx, certs.keyFile, *watchInterval)
+		}
+	}
+	reloadCerts := func() {
+		if certs == nil {
+			return
+		}
+		if err := certs.reload(); err != nil {
+			logger.Error("certificate reload failed, keeping current certificate", "err", err)
+			return
+		}
+		logger.Info("certificate reloaded", "cert_file", certs.certFile)
+	}
 
-	<-quit
+	for running := true; running; {
+		select {
+		case <-hup:
+			logger.Info("reloading config", "path", *cfgPath, "trigger", "SIGHUP")
+			if err := reloadRouter(*cfgPath, handler); err != nil {
+				logger.Error("config reload failed, keeping current config", "err", err)
+			}
+			reloadCerts()
+		case <-changed:
+			logger.Info("reloading config", "path", *cfgPath, "trigger", "file change")
+			if err := reloadRouter(*cfgPath, handler); err != nil {
+				logger.Error("config reload failed, keeping current config", "err", err)
+			}
+		case <-certChanged:
+			reloadCerts()
+		case <-keyChanged:
+			reloadCerts()
+		case <-quit:
+			running = false
+		}
+	}
 	logger.Info("shutting down server...")
+	draining.Store(true)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// validated by loadConfig
+	if delay, _ := cfg.Server.drainDelay(); delay > 0 {
+		logger.Info("draining before shutdown", "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-quit:
+			logger.Info("second signal, skipping the rest of the drain delay")
+		}
+	}
+	shutdownTimeout, _ := cfg.Server.shutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(ctx)
+	}
+	if httpSrv != nil {
+		httpSrv.Shutdown(ctx)
+	}
+	forced, err := shutdownServer(ctx, srv, conns, cancelRequests)
+	if forced > 0 {
+		logger.Warn("shutdown timed out, closed busy connections", "connections", forced, "timeout", shutdownTimeout)
+	}
+	// flush spans of the requests that just finished
+	if terr := shutdownTracing(ctx); terr != nil {
+		logger.Error("failed to flush traces", "err", terr)
+	}
+	if err != nil {
 		logger.Error("server forced shutdown", "err", err)
 		os.Exit(1)
 	}
 	logger.Info("server exiting")
 }
 
-// buildRouter constructs a Chi router for the gateway — useful for testing
-func buildRouter(cfg *Config) chi.Router {
+// buildRouter constructs a Chi router for the gateway — useful for testing.
+// Background work such as health checks runs until ctx is cancelled.
+func buildRouter(ctx context.Context, cfg *Config) (chi.Router, error) {
 	r := chi.NewRouter()
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(countActive)
+	r.Use(requestID(cfg.Server.RequestIDHeader, cfg.Server.trustRequestID()))
+	r.Use(errorFormat(cfg.Server.ErrorFormat))
+	r.Use(forwarded(cfg.Server.trustForwardedHeaders()))
+	if cfg.Server.clientIPFromHeaders() {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(accessLog(cfg.Server.Logging, cfg.apiKeyHeaders()))
 	r.Use(middleware.Recoverer)
+	// headers filled from claims must not be settable by clients either
+	strip := append(append([]string{}, cfg.Server.StripRequestHeaders...), cfg.claimHeaderNames()...)
+	r.Use(stripRequestHeaders(strip))
+	if cfg.Tenant != nil {
+		r.Use(takeTenantHeader(cfg.Tenant.header()))
+	}
+	r.Use(trailingSlash(cfg.Server.TrailingSlash))
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
 
-	// CORS
-	corsMw := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-User-Subject", "X-User-Id", "X-User-Roles"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	})
-	r.Use(corsMw.Handler)
-
-	// health
+	// liveness; readiness is served by /readyz. Both fail once shutdown
+	// starts so the gateway is taken out of rotation while it drains.
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("draining"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
-	authMw := authMiddleware([]byte(cfg.JWTSecret))
+	revocations, err := newRevocationStore(ctx, cfg.Revocation)
+	if err != nil {
+		return nil, err
+	}
+	authMw, err := newAuthMiddleware(cfg, revocations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure token verification: %w", err)
+	}
+	// validated by newAuthMiddleware
+	sources, _ := cfg.tokenSources()
+	// metrics are registered outside the service groups so they never require auth
+	if cfg.Server.metricsEnabled() && cfg.Server.MetricsPort == "" {
+		r.Handle("/metrics", promhttp.Handler())
+	}
+
+	var introspect func(http.Handler) http.Handler
+	if cfg.Introspection != nil {
+		i, err := newIntrospector(*cfg.Introspection)
+		if err != nil {
+			return nil, err
+		}
+		introspect = introspectionMiddleware(i, sources)
+	}
+
+	transport, err := newUpstreamTransport(cfg.Server.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+	// connections of a replaced router are not reused
+	go func() {
+		<-ctx.Done()
+		transport.CloseIdleConnections()
+	}()
+
+	health := newHealthAggregator(transport)
+	r.Handle("/healthz/services", health)
+	ready := newReadiness(cfg.Server.ReadinessChecks)
+	r.Handle("/readyz", ready)
+	admin := &adminAPI{cfg: cfg, revocations: revocations}
+	if cfg.Server.AdminToken != "" {
+		r.Mount("/admin", admin.routes(cfg.Server.AdminToken))
+	}
+
+	for _, rd := range cfg.Redirects {
+		if err := rd.validate(); err != nil {
+			return nil, fmt.Errorf("redirect %s: %w", rd.FromPrefix, err)
+		}
+		h := redirect(rd)
+		r.Handle(rd.FromPrefix, h)
+		r.Handle(strings.TrimSuffix(rd.FromPrefix, "/")+"/*", h)
+	}
 
 	for _, s := range cfg.Services {
-		proxy, err := newProxy(s.TargetURL, s.StripPrefix)
+		if err := s.validateAuth(); err != nil {
+			return nil, fmt.Errorf("service %s: %w", s.Name, err)
+		}
+		if s.authenticates() && s.authMode() == authIntrospection && introspect == nil {
+			return nil, fmt.Errorf("service %s: auth: introspection needs a top-level introspection block", s.Name)
+		}
+		var h http.Handler
+		if s.static() {
+			if h, err = staticHandler(s); err != nil {
+				return nil, fmt.Errorf("service %s: %w", s.Name, err)
+			}
+			admin.add(s, nil)
+		} else {
+			proxy, err := newProxy(s, cfg.Server, transport)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create proxy for service %s: %w", s.Name, err)
+			}
+			if s.HealthCheckPath != "" {
+				interval, err := s.healthCheckInterval()
+				if err != nil {
+					return nil, fmt.Errorf("service %s: %w", s.Name, err)
+				}
+				go proxy.lb.checkHealth(ctx, proxy.transport, s.Name, s.HealthCheckPath, interval)
+				ready.add(s.Name, proxy.lb)
+			} else {
+				ready.add(s.Name, nil)
+			}
+			if proxy.discovery != nil {
+				go proxy.discovery.run(ctx)
+			}
+			if proxy.transport != transport {
+				go func() {
+					<-ctx.Done()
+					proxy.transport.CloseIdleConnections()
+				}()
+			}
+			health.add(s, proxy.lb, proxy.transport)
+			admin.add(s, proxy)
+			if s.HeaderRoutes != nil {
+				if !s.authenticates() {
+					return nil, fmt.Errorf("service %s: header_routes needs auth_required or auth_optional", s.Name)
+				}
+				if proxy.routes, err = newHeaderRoutes(*s.HeaderRoutes, cfg.rolesClaim()); err != nil {
+					return nil, fmt.Errorf("service %s: %w", s.Name, err)
+				}
+			}
+			h = proxy
+		}
+		maxBody, err := s.maxBodySize(cfg.Server)
 		if err != nil {
-			logger.Error("failed to create proxy", "service", s.Name, "err", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("service %s: %w", s.Name, err)
+		}
+		concurrentWait, err := s.maxConcurrentWait()
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", s.Name, err)
+		}
+		var ipf *ipFilter
+		if len(s.AllowIPs) > 0 || len(s.DenyIPs) > 0 {
+			if ipf, err = newIPFilter(s.AllowIPs, s.DenyIPs); err != nil {
+				return nil, fmt.Errorf("service %s: %w", s.Name, err)
+			}
+		}
+		methods, err := s.allowedMethods()
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", s.Name, err)
+		}
+		header := s.credentialHeader()
+		credentialsSent := func(r *http.Request) bool { return r.Header.Get(header) != "" }
+		if s.authMode() == authJWT || s.authMode() == authIntrospection {
+			credentialsSent = func(r *http.Request) bool { return hasToken(sources, r) }
+		}
+		jwtMw := authMw
+		if s.hasOwnJWT() {
+			if jwtMw, err = newAuthMiddleware(cfg.jwtConfig(s), revocations); err != nil {
+				return nil, fmt.Errorf("service %s: failed to configure token verification: %w", s.Name, err)
+			}
+		}
+		var apiKeyMw func(http.Handler) http.Handler
+		if s.authenticates() && s.authMode() == authAPIKey {
+			if apiKeyMw, err = apiKeyMiddleware(*s.APIKey, cfg.rolesClaim()); err != nil {
+				return nil, fmt.Errorf("service %s: %w", s.Name, err)
+			}
+		}
+		var basicMw func(http.Handler) http.Handler
+		if s.authenticates() && s.authMode() == authBasic {
+			if basicMw, err = basicAuthMiddleware(*s.BasicAuth); err != nil {
+				return nil, fmt.Errorf("service %s: %w", s.Name, err)
+			}
+		}
+		var forwardMw func(http.Handler) http.Handler
+		if s.authenticates() && s.authMode() == authForward {
+			if forwardMw, err = forwardAuthMiddleware(s); err != nil {
+				return nil, fmt.Errorf("service %s: %w", s.Name, err)
+			}
+		}
+		if s.Cache != nil {
+			cache, err := newResponseCache(s.Name, *s.Cache)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", s.Name, err)
+			}
+			h = cacheResponses(cache)(h)
+		}
+		rl := s.RateLimit
+		if rl == nil {
+			rl = cfg.Server.RateLimit
+		}
+		compression := s.Compression
+		if compression == nil {
+			compression = cfg.Server.Compression
+		}
+		corsCfg := s.CORS
+		if corsCfg == nil {
+			corsCfg = cfg.Server.CORS
+		}
+		if corsCfg == nil {
+			corsCfg = &defaultCORS
 		}
-		h := http.Handler(proxy)
 		r.Group(func(r2 chi.Router) {
-			if s.AuthRequired {
-				r2.Use(authMw)
-				r2.Use(injectUserInfo)
+			r2.Use(logService(s.Name))
+			r2.Use(traceRequests(s))
+			// CORS runs first so preflight requests are answered without auth
+			if corsCfg.enabled() {
+				r2.Use(corsHandler(corsMethods(*corsCfg, methods)))
+			}
+			if compression.enabled() {
+				r2.Use(compress(*compression))
+			}
+			if cfg.Server.metricsEnabled() {
+				r2.Use(instrument(s))
+			}
+			if len(methods) > 0 {
+				r2.Use(allowMethods(methods, s.Name))
+			}
+			if ipf != nil {
+				r2.Use(filterIPs(ipf, s.Name))
+			}
+			if s.RequireClientCert {
+				r2.Use(requireClientCert(s.Name))
+			}
+			// per-user limits need the verified token, so they run after auth
+			byUser := rl != nil && rl.byUser() && s.authenticates()
+			if rl != nil && !byUser {
+				r2.Use(rateLimit(newRateLimiter(*rl), false))
+			}
+			if maxBody > 0 {
+				r2.Use(limitBody(maxBody))
+			}
+			if s.authenticates() {
+				var mw func(http.Handler) http.Handler
+				switch s.authMode() {
+				case authAPIKey:
+					mw = apiKeyMw
+				case authIntrospection:
+					mw = introspect
+				case authBasic:
+					mw = basicMw
+				case authForward:
+					mw = forwardMw
+				default:
+					mw = jwtMw
+				}
+				if s.AuthOptional {
+					mw = optionalAuth(credentialsSent, mw)
+				}
+				chain := chi.Chain(mw)
+				if byUser {
+					chain = append(chain, rateLimit(newRateLimiter(*rl), true))
+				}
+				if len(s.RequiredRoles) > 0 {
+					chain = append(chain, requireRoles(s.RequiredRoles, s.RequireAllRoles, cfg.rolesClaim()))
+				}
+				if len(s.RequiredScopes) > 0 || len(s.WriteScopes) > 0 {
+					chain = append(chain, requireScopes(s.RequiredScopes, s.WriteScopes))
+				}
+				chain = append(chain, injectUserInfo(cfg.rolesClaim(), newClaimHeaders(cfg.claimHeaders(s))))
+				if cfg.Tenant != nil {
+					chain = append(chain, enforceTenant(cfg.Tenant, s.AllowedTenants))
+				}
+				if len(s.PublicPaths) > 0 {
+					r2.Use(skipForPublic(s.PublicPaths, chain.Handler))
+				} else {
+					r2.Use(chain...)
+				}
+			}
+			// only requests that passed every check take a slot
+			if s.MaxConcurrent > 0 {
+				r2.Use(limitConcurrency(s.Name, s.MaxConcurrent, concurrentWait))
 			}
 			// Register both prefix and wildcard form to match both exact and nested paths
 			r2.Handle(s.PathPrefix, h)
 			r2.Handle(s.PathPrefix+"/*", h)
 		})
-		logger.Info("registered service", "name", s.Name, "prefix", s.PathPrefix, "target", s.TargetURL)
+		logger.Info("registered service", "name", s.Name, "prefix", s.PathPrefix, "targets", s.targets())
 	}
-	return r
+	return r, nil
 }
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func TestHealthz(t *testing.T) {
@@ -12,7 +22,7 @@ func TestHealthz(t *testing.T) {
 		JWTSecret: "dummy",
 		Services:  []ServiceConfig{},
 	}
-	r := buildRouter(cfg)
+	r := mustBuildRouter(t, cfg)
 	req := httptest.NewRequest("GET", "/healthz", nil)
 	rw := httptest.NewRecorder()
 
@@ -22,3 +32,556 @@ func TestHealthz(t *testing.T) {
 		t.Fatalf("unexpected status: got %d want %d", got, want)
 	}
 }
+
+func TestUpstreamTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "slow", PathPrefix: "/api/slow", TargetURL: upstream.URL, Timeout: "50ms"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	req := httptest.NewRequest("GET", "/api/slow/thing", nil)
+	rw := httptest.NewRecorder()
+
+	r.ServeHTTP(rw, req)
+
+	if got, want := rw.Code, http.StatusGatewayTimeout; got != want {
+		t.Fatalf("unexpected status: got %d want %d", got, want)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+	if body := rw.Body.String(); !strings.Contains(body, "slow") {
+		t.Fatalf("error body does not name the service: %s", body)
+	}
+}
+
+func TestUpstreamTimeoutDisabled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	path := writeConfig(t, `
+services:
+  - name: "stream"
+    path_prefix: "/api/stream"
+    target_url: "`+upstream.URL+`"
+    env_var: "TEST_STREAM_SERVICE_URL"
+    timeout: 0
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mustBuildRouter(t, cfg)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/stream/events", nil))
+
+	if got, want := rw.Code, http.StatusOK; got != want {
+		t.Fatalf("unexpected status: got %d want %d", got, want)
+	}
+}
+
+func TestLoadConfigInvalidTimeout(t *testing.T) {
+	for _, timeout := range []string{"soon", "-5s"} {
+		path := writeConfig(t, `
+services:
+  - name: "slow"
+    path_prefix: "/api/slow"
+    target_url: "http://localhost:9999"
+    timeout: "`+timeout+`"
+`)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("timeout %q: expected error", timeout)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestForgedUserHeadersStripped(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Seen-User-Id", r.Header.Get("X-User-Id"))
+		w.Header().Set("Seen-User-Roles", r.Header.Get("X-User-Roles"))
+		w.Header().Set("Seen-Tenant", r.Header.Get("X-Tenant-Id"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{StripRequestHeaders: []string{"x-tenant-id"}},
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "public", PathPrefix: "/api/public", TargetURL: upstream.URL},
+			{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/public/x", nil)
+		req.Header.Set("X-User-Id", "1")
+		req.Header.Set("X-User-Roles", "admin")
+		req.Header.Set("X-Tenant-Id", "other")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+
+		if got := rw.Header().Get("Seen-Tenant"); got != "" {
+			t.Fatalf("header listed in strip_request_headers reached upstream: %q", got)
+		}
+		if got := rw.Header().Get("Seen-User-Id"); got != "" {
+			t.Fatalf("forged X-User-Id reached upstream: %q", got)
+		}
+		if got := rw.Header().Get("Seen-User-Roles"); got != "" {
+			t.Fatalf("forged X-User-Roles reached upstream: %q", got)
+		}
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/private/x", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"sub": "42"}))
+		req.Header.Set("X-User-Id", "1")
+		req.Header.Set("X-User-Roles", "admin")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+
+		if got, want := rw.Code, http.StatusOK; got != want {
+			t.Fatalf("unexpected status: got %d want %d", got, want)
+		}
+		if got, want := rw.Header().Get("Seen-User-Id"), "42"; got != want {
+			t.Fatalf("unexpected X-User-Id: got %q want %q", got, want)
+		}
+		if got := rw.Header().Get("Seen-User-Roles"); got != "" {
+			t.Fatalf("forged X-User-Roles reached upstream: %q", got)
+		}
+	})
+}
+
+func signToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func mustBuildRouter(t *testing.T, cfg *Config) http.Handler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := buildRouter(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestIssuerAudienceValidation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret:   "secret",
+		JWTIssuer:   "https://idp.example.com",
+		JWTAudience: "gateway",
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		wantCode int
+		wantMsg  string
+	}{
+		{"valid string aud", jwt.MapClaims{"iss": "https://idp.example.com", "aud": "gateway"}, http.StatusOK, ""},
+		{"valid array aud", jwt.MapClaims{"iss": "https://idp.example.com", "aud": []string{"other", "gateway"}}, http.StatusOK, ""},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example.com", "aud": "gateway"}, http.StatusUnauthorized, "Invalid Token"},
+		{"missing issuer", jwt.MapClaims{"aud": "gateway"}, http.StatusUnauthorized, "Invalid Token"},
+		{"wrong audience", jwt.MapClaims{"iss": "https://idp.example.com", "aud": []string{"other"}}, http.StatusUnauthorized, "Invalid Token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/private/x", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", tt.claims))
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Code; got != tt.wantCode {
+				t.Fatalf("unexpected status: got %d want %d", got, tt.wantCode)
+			}
+			if tt.wantMsg != "" {
+				if got := decodeError(t, rw).Message; got != tt.wantMsg {
+					t.Fatalf("unexpected message: got %q want %q", got, tt.wantMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		JWTLeeway: "30s",
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		wantCode int
+		wantMsg  string
+	}{
+		{"expired inside leeway", jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()}, http.StatusOK, ""},
+		{"expired outside leeway", jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, http.StatusUnauthorized, "Token Expired"},
+		{"nbf inside leeway", jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix()}, http.StatusOK, ""},
+		{"nbf outside leeway", jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}, http.StatusUnauthorized, "Token Not Yet Valid"},
+		{"iat outside leeway", jwt.MapClaims{"iat": now.Add(time.Minute).Unix()}, http.StatusUnauthorized, "Token Not Yet Valid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/private/x", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", tt.claims))
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Code; got != tt.wantCode {
+				t.Fatalf("unexpected status: got %d want %d", got, tt.wantCode)
+			}
+			if tt.wantMsg != "" {
+				if got := decodeError(t, rw).Message; got != tt.wantMsg {
+					t.Fatalf("unexpected message: got %q want %q", got, tt.wantMsg)
+				}
+			}
+		})
+	}
+
+	t.Run("no leeway", func(t *testing.T) {
+		r := mustBuildRouter(t, &Config{JWTSecret: "secret", Services: cfg.Services})
+		req := httptest.NewRequest("GET", "/api/private/x", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()}))
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != http.StatusUnauthorized {
+			t.Fatalf("expected an expired token to be rejected without leeway, got %d", rw.Code)
+		}
+	})
+}
+
+func TestJWTTimeClaimDebugLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	r := mustBuildRouter(t, &Config{
+		JWTSecret: "secret",
+		JWTLeeway: "1s",
+		Services:  []ServiceConfig{{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true}},
+	})
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		claim  string
+	}{
+		{"expired", jwt.MapClaims{"exp": now.Add(-time.Hour).Unix()}, "exp"},
+		{"issued ahead", jwt.MapClaims{"iat": now.Add(time.Hour).Unix(), "nbf": now.Add(-time.Hour).Unix()}, "iat"},
+		{"not before", jwt.MapClaims{"nbf": now.Add(time.Hour).Unix(), "iat": now.Unix()}, "nbf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogs(t)
+			req := httptest.NewRequest("GET", "/api/private/x", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", tt.claims))
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry map[string]interface{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var e map[string]interface{}
+				if json.Unmarshal([]byte(line), &e) == nil && e["msg"] == "token time claim failed" {
+					entry = e
+				}
+			}
+			if entry == nil {
+				t.Fatalf("no debug entry logged in %s", buf)
+			}
+			if entry["level"] != "DEBUG" || entry["claim"] != tt.claim || entry["leeway"] != "1s" {
+				t.Errorf("unexpected entry %v", entry)
+			}
+			off, _ := time.ParseDuration(fmt.Sprint(entry["off_by"]))
+			if off < time.Hour-2*time.Second || off > time.Hour+2*time.Second {
+				t.Errorf("off_by = %v, want about 1h", entry["off_by"])
+			}
+		})
+	}
+}
+
+func TestJWTAllowedAlgs(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	services := []ServiceConfig{{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true}}
+	sign := func(method jwt.SigningMethod, key interface{}) string {
+		s, err := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "42"}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	none := sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name    string
+		algs    []string
+		token   string
+		want    int
+		wantLog string
+	}{
+		{"default hs512", nil, sign(jwt.SigningMethodHS512, []byte("secret")), http.StatusOK, ""},
+		{"listed hs256", []string{"HS256"}, sign(jwt.SigningMethodHS256, []byte("secret")), http.StatusOK, ""},
+		{"unlisted hs512", []string{"HS256"}, sign(jwt.SigningMethodHS512, []byte("secret")), http.StatusUnauthorized, "signing algorithm not allowed"},
+		{"none by default", nil, none, http.StatusUnauthorized, "unsigned token (alg none)"},
+		{"upper case none", nil, strings.Replace(none, strings.Split(none, ".")[0], base64url(`{"alg":"NONE","typ":"JWT"}`), 1), http.StatusUnauthorized, "unsigned token (alg none)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustBuildRouter(t, &Config{JWTSecret: "secret", JWTAllowedAlgs: tt.algs, Services: services})
+			buf := captureLogs(t)
+			req := httptest.NewRequest("GET", "/api/private/x", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+			if tt.wantLog != "" && !strings.Contains(buf.String(), `"reason":"`+tt.wantLog+`"`) {
+				t.Errorf("expected a %q log entry, got %s", tt.wantLog, buf)
+			}
+		})
+	}
+}
+
+func base64url(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func TestLoadConfigInvalidAllowedAlgs(t *testing.T) {
+	for name, algs := range map[string]string{"none": "[HS256, none]", "unknown": "[HS257]"} {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, "jwt_secret: \"secret\"\njwt_allowed_algs: "+algs+"\nservices: []\n")
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid jwt_allowed_algs")
+			}
+		})
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	var gotUser string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = r.Header.Get("X-User-Id")
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "catalog", PathPrefix: "/api/catalog", TargetURL: upstream.URL, AuthOptional: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name     string
+		auth     string
+		wantCode int
+		wantUser string
+	}{
+		{"anonymous", "", http.StatusOK, ""},
+		{"valid token", "Bearer " + signToken(t, "secret", jwt.MapClaims{"sub": "42"}), http.StatusOK, "42"},
+		{"expired token", "Bearer " + signToken(t, "secret", jwt.MapClaims{"sub": "42", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized, ""},
+		{"wrong secret", "Bearer " + signToken(t, "other", jwt.MapClaims{"sub": "42"}), http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser = ""
+			req := httptest.NewRequest("GET", "/api/catalog/items", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			req.Header.Set("X-User-Id", "forged")
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Code; got != tt.wantCode {
+				t.Fatalf("unexpected status: got %d want %d", got, tt.wantCode)
+			}
+			if gotUser != tt.wantUser {
+				t.Fatalf("unexpected X-User-Id: got %q want %q", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestStripAuthorization(t *testing.T) {
+	var gotAuth, gotUser string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotUser = r.Header.Get("Authorization"), r.Header.Get("X-User-Id")
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true, StripAuthorization: true},
+			{Name: "billing", PathPrefix: "/api/billing", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	auth := "Bearer " + signToken(t, "secret", jwt.MapClaims{"sub": "42"})
+
+	for path, want := range map[string]string{"/api/orders/1": "", "/api/billing/1": auth} {
+		gotAuth, gotUser = "", ""
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", auth)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got %d", path, rw.Code)
+		}
+		if gotAuth != want {
+			t.Errorf("%s: upstream got Authorization %q want %q", path, gotAuth, want)
+		}
+		if gotUser != "42" {
+			t.Errorf("%s: upstream got X-User-Id %q", path, gotUser)
+		}
+	}
+}
+
+func TestOptionalAuthValidation(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "catalog"
+    path_prefix: "/api/catalog"
+    target_url: "http://catalog:8080"
+    auth_required: true
+    auth_optional: true
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error when both auth_required and auth_optional are set")
+	}
+}
+
+func TestPerServiceJWT(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "customer-secret",
+		JWTIssuer: "shop",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+			{Name: "admin", PathPrefix: "/api/admin", TargetURL: upstream.URL, AuthRequired: true, JWTSecret: "admin-secret"},
+			{Name: "backoffice", PathPrefix: "/api/backoffice", TargetURL: upstream.URL, AuthRequired: true, JWTIssuer: "staff"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	customer := signToken(t, "customer-secret", jwt.MapClaims{"sub": "42", "iss": "shop"})
+	admin := signToken(t, "admin-secret", jwt.MapClaims{"sub": "1", "iss": "shop"})
+	staff := signToken(t, "customer-secret", jwt.MapClaims{"sub": "7", "iss": "staff"})
+	tests := []struct {
+		path, token string
+		want        int
+	}{
+		{"/api/orders/1", customer, http.StatusOK},
+		{"/api/orders/1", admin, http.StatusUnauthorized},
+		{"/api/admin/1", admin, http.StatusOK},
+		{"/api/admin/1", customer, http.StatusUnauthorized},
+		// the secret falls back to the top-level one, the issuer is the service's
+		{"/api/backoffice/1", staff, http.StatusOK},
+		{"/api/backoffice/1", customer, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != tt.want {
+			t.Errorf("%s: got %d want %d", tt.path, rw.Code, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigPerServiceJWTNeedsAuth(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "admin"
+    path_prefix: "/api/admin"
+    target_url: "http://admin:8080"
+    jwt_secret: "admin-secret"
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for jwt_secret on a service without auth")
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		host, port string
+		want       string
+		wantErr    bool
+	}{
+		{port: ":8080", want: ":8080"},
+		{port: "0.0.0.0:8080", want: "0.0.0.0:8080"},
+		{port: "8080", want: ":8080"},
+		{host: "127.0.0.1", port: ":8080", want: "127.0.0.1:8080"},
+		{host: "127.0.0.1", port: "8080", want: "127.0.0.1:8080"},
+		{host: "127.0.0.1", port: "127.0.0.1:8080", want: "127.0.0.1:8080"},
+		{host: "::1", port: "8080", want: "[::1]:8080"},
+		{host: "[::1]", port: ":8080", want: "[::1]:8080"},
+		{host: "localhost", port: "8080", want: "localhost:8080"},
+		{},
+		{host: "127.0.0.1", port: "0.0.0.0:8080", wantErr: true},
+		{host: "127.0.0.1", wantErr: true},
+		{port: ":99999", wantErr: true},
+		{port: "localhost:", wantErr: true},
+		{host: "bad host", port: "8080", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ServerConfig{Host: tt.host, Port: tt.port}.listenAddr()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("host %q port %q: expected error, got %q", tt.host, tt.port, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("host %q port %q: got %q, %v want %q", tt.host, tt.port, got, err, tt.want)
+		}
+	}
+}
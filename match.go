@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MatchRuleConfig sends requests carrying all of headers, each with exactly
+// the given value, to target_url. weight, a percentage defaulting to 100,
+// sends only that share of matching callers there, e.g. for an A/B test.
+type MatchRuleConfig struct {
+	Headers   map[string]string `yaml:"headers"`
+	TargetURL string            `yaml:"target_url"`
+	Weight    *int              `yaml:"weight"`
+}
+
+func (c MatchRuleConfig) validate() error {
+	if len(c.Headers) == 0 {
+		return errors.New("headers must not be empty")
+	}
+	for name := range c.Headers {
+		if name == "" {
+			return errors.New("header names must not be empty")
+		}
+	}
+	if c.TargetURL == "" {
+		return errors.New("target_url must be set")
+	}
+	if _, err := url.Parse(c.TargetURL); err != nil {
+		return fmt.Errorf("invalid target_url: %w", err)
+	}
+	if w := c.weight(); w < 0 || w > 100 {
+		return fmt.Errorf("weight must be between 0 and 100, got %d", w)
+	}
+	return nil
+}
+
+func (c MatchRuleConfig) weight() int {
+	if c.Weight == nil {
+		return 100
+	}
+	return *c.Weight
+}
+
+func (s ServiceConfig) validateMatch() error {
+	for i, rule := range s.Match {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("match[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+type matchRule struct {
+	headers  http.Header
+	weight   int
+	upstream *upstream
+}
+
+// matchRules picks an alternate upstream by request headers. Rules are tried
+// in order and the first that applies wins; without one the request goes to
+// the service's normal upstreams.
+type matchRules struct {
+	rules []matchRule
+	// stickyHeader keeps a caller in the same weighted bucket, as for canaries
+	stickyHeader string
+}
+
+func newMatchRules(s ServiceConfig) (*matchRules, error) {
+	if err := s.validateMatch(); err != nil {
+		return nil, err
+	}
+	m := &matchRules{stickyHeader: s.CanaryHeader}
+	for i, c := range s.Match {
+		u, err := newUpstream(c.TargetURL)
+		if err != nil {
+			return nil, fmt.Errorf("match[%d]: %w", i, err)
+		}
+		headers := make(http.Header, len(c.Headers))
+		for name, value := range c.Headers {
+			headers.Set(name, value)
+		}
+		m.rules = append(m.rules, matchRule{headers: headers, weight: c.weight(), upstream: u})
+	}
+	return m, nil
+}
+
+func (m *matchRules) match(r *http.Request) *upstream {
+	for i, rule := range m.rules {
+		if !rule.matches(r.Header) {
+			continue
+		}
+		// each rule buckets callers on its own, so two rules at 50 do not
+		// pick out the same half
+		if rule.weight < 100 && !inCanary(fmt.Sprintf("match%d:%s", i, canaryKey(r, m.stickyHeader)), rule.weight) {
+			continue
+		}
+		return rule.upstream
+	}
+	return nil
+}
+
+func (rule matchRule) matches(h http.Header) bool {
+	for name := range rule.headers {
+		if h.Get(name) != rule.headers.Get(name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRouting(t *testing.T) {
+	backends := map[string]string{}
+	for _, name := range []string{"main", "beta", "eu-beta"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+		defer srv.Close()
+		backends[name] = srv.URL
+	}
+
+	cfg := &Config{
+		Services: []ServiceConfig{{
+			Name: "orders", PathPrefix: "/api/orders", TargetURL: backends["main"],
+			Match: []MatchRuleConfig{
+				{Headers: map[string]string{"X-Beta": "true", "X-Region": "eu"}, TargetURL: backends["eu-beta"]},
+				{Headers: map[string]string{"x-beta": "true"}, TargetURL: backends["beta"]},
+			},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"first rule", map[string]string{"X-Beta": "true", "X-Region": "eu"}, "eu-beta"},
+		{"second rule", map[string]string{"X-Beta": "true", "X-Region": "us"}, "beta"},
+		{"other value", map[string]string{"X-Beta": "false"}, "main"},
+		{"no headers", nil, "main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/orders/x", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if got := rw.Header().Get("Upstream"); got != tt.want {
+				t.Fatalf("unexpected upstream: got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchWeight(t *testing.T) {
+	weight := 30
+	m, err := newMatchRules(ServiceConfig{
+		CanaryHeader: "X-User-Key",
+		Match:        []MatchRuleConfig{{Headers: map[string]string{"X-Beta": "true"}, TargetURL: "http://beta:8080", Weight: &weight}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for i := 0; i < 1000; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Beta", "true")
+		req.Header.Set("X-User-Key", fmt.Sprintf("user-%d", i))
+		first := m.match(req)
+		if first != m.match(req) {
+			t.Fatalf("user-%d switched targets between requests", i)
+		}
+		if first != nil {
+			n++
+		}
+	}
+	if n < 230 || n > 370 {
+		t.Fatalf("expected about 30%% of matching callers on the rule's target, got %d of 1000", n)
+	}
+}
+
+func TestLoadConfigInvalidMatch(t *testing.T) {
+	tests := map[string]string{
+		"no headers": `[{target_url: "http://beta:8080"}]`,
+		"no target":  `[{headers: {X-Beta: "true"}}]`,
+		"bad weight": `[{headers: {X-Beta: "true"}, target_url: "http://beta:8080", weight: 150}]`,
+	}
+	for name, match := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    match: `+match+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid match")
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// allowedMethods normalises allowed_methods to upper case without
+// duplicates; an empty result allows every method
+func (s ServiceConfig) allowedMethods() ([]string, error) {
+	var methods []string
+	seen := map[string]bool{}
+	for _, m := range s.AllowedMethods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m == "" || strings.ContainsAny(m, " \t,") {
+			return nil, fmt.Errorf("allowed_methods: invalid method %q", m)
+		}
+		if !seen[m] {
+			seen[m] = true
+			methods = append(methods, m)
+		}
+	}
+	return methods, nil
+}
+
+// isPreflight reports whether r is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsMethods narrows the methods a CORS policy advertises to those the
+// service accepts, so browsers don't attempt requests that would get 405
+func corsMethods(c CORSConfig, methods []string) CORSConfig {
+	if len(methods) == 0 {
+		return c
+	}
+	advertised := c.AllowedMethods
+	if len(advertised) == 0 {
+		advertised = defaultCORS.AllowedMethods
+	}
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	var narrowed []string
+	for _, m := range advertised {
+		if allowed[strings.ToUpper(m)] {
+			narrowed = append(narrowed, m)
+		}
+	}
+	if len(narrowed) == 0 {
+		narrowed = methods
+	}
+	c.AllowedMethods = narrowed
+	return c
+}
+
+// allowMethods rejects requests whose method is not in methods with 405,
+// listing the allowed ones in the Allow header. CORS preflights pass even
+// when OPTIONS is not listed.
+func allowMethods(methods []string, service string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	allow := strings.Join(methods, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed[r.Method] && !isPreflight(r) {
+				logger.Warn("method not allowed", "service", service, "method", r.Method, "path", r.URL.Path)
+				w.Header().Set("Allow", allow)
+				writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
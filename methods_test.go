@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllowedMethods(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "catalog", PathPrefix: "/api/catalog", TargetURL: upstream.URL, AllowedMethods: []string{"get", "HEAD", "GET"}},
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/catalog/1", http.StatusOK},
+		{"HEAD", "/api/catalog/1", http.StatusOK},
+		{"POST", "/api/catalog/1", http.StatusMethodNotAllowed},
+		{"DELETE", "/api/catalog", http.StatusMethodNotAllowed},
+		{"DELETE", "/api/orders/1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		calls = 0
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if rw.Code != tt.want {
+			t.Fatalf("%s %s: got %d want %d", tt.method, tt.path, rw.Code, tt.want)
+		}
+		if tt.want == http.StatusMethodNotAllowed {
+			if calls != 0 {
+				t.Errorf("%s %s: rejected request reached the upstream", tt.method, tt.path)
+			}
+			if got := rw.Header().Get("Allow"); got != "GET, HEAD" {
+				t.Errorf("%s %s: Allow = %q", tt.method, tt.path, got)
+			}
+		}
+	}
+}
+
+func TestLoadConfigInvalidAllowedMethods(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "catalog"
+    path_prefix: "/api/catalog"
+    target_url: "http://catalog:8080"
+    allowed_methods: ["GET", "PUT, PATCH"]
+`)
+	_, err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "service catalog") {
+		t.Fatalf("expected error naming the service, got %v", err)
+	}
+}
+
+func TestAllowedMethodsPreflight(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer upstream.Close()
+
+	disabled := false
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "reports", PathPrefix: "/api/reports", TargetURL: upstream.URL, AllowedMethods: []string{"GET", "HEAD"}},
+			{Name: "legacy", PathPrefix: "/api/legacy", TargetURL: upstream.URL, AllowedMethods: []string{"GET"}, CORS: &CORSConfig{Enabled: &disabled}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	preflight := func(path, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := preflight("/api/reports/daily", "GET")
+	if rw.Code >= 300 || rw.Header().Get("Access-Control-Allow-Methods") != "GET" {
+		t.Fatalf("expected the preflight for GET to succeed, got %d %q", rw.Code, rw.Header().Get("Access-Control-Allow-Methods"))
+	}
+	if rw = preflight("/api/reports/daily", "POST"); rw.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Fatalf("expected POST not to be advertised, got %q", rw.Header().Get("Access-Control-Allow-Methods"))
+	}
+
+	calls = 0
+	if rw = preflight("/api/legacy/x", "GET"); rw.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the preflight to reach the upstream handling CORS, got %d after %d calls", rw.Code, calls)
+	}
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("OPTIONS", "/api/legacy/x", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected a plain OPTIONS request to get 405, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are labelled by the configured service name and path prefix rather
+// than the request path to keep cardinality bounded. They are registered once
+// so a config reload keeps accumulating into the same series.
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_requests_total",
+		Help: "Requests handled by the gateway per service.",
+	}, []string{"service", "prefix", "method", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_request_duration_seconds",
+		Help:    "Time to serve requests per service, including the upstream call.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "prefix", "method", "status"})
+
+	// httpRequestsTotal and httpRequestDuration use the conventional names
+	// and break requests down by the exact status code
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requests handled by the gateway per service and status code.",
+	}, []string{"service", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to serve requests per service, including the upstream call.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method"})
+
+	upstreamErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_upstream_errors_total",
+		Help: "Failed upstream round trips per service by reason.",
+	}, []string{"service", "prefix", "reason"})
+
+	backendRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_backend_requests_total",
+		Help: "Requests of services with a canary by the backend chosen.",
+	}, []string{"service", "backend"})
+
+	cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_requests_total",
+		Help: "Cache lookups of services with a response cache by result.",
+	}, []string{"service", "result"})
+
+	activeRequestsGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gateway_active_requests",
+		Help: "Requests currently being served by the gateway.",
+	}, func() float64 { return float64(activeRequests.Load()) })
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, httpRequestsTotal, httpRequestDuration, upstreamErrorsTotal, backendRequestsTotal, cacheRequestsTotal, activeRequestsGauge)
+}
+
+// statusClass collapses a status code to "2xx", "4xx", ...
+func statusClass(code int) string {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// statusCode formats a status code, counting a response that never wrote
+// one as 200
+func statusCode(code int) string {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code)
+}
+
+// instrument records request count and latency for a service route group
+func instrument(s ServiceConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			elapsed := time.Since(start).Seconds()
+			status := statusClass(ww.Status())
+			requestsTotal.WithLabelValues(s.Name, s.PathPrefix, r.Method, status).Inc()
+			requestDuration.WithLabelValues(s.Name, s.PathPrefix, r.Method, status).Observe(elapsed)
+			httpRequestsTotal.WithLabelValues(s.Name, r.Method, statusCode(ww.Status())).Inc()
+			httpRequestDuration.WithLabelValues(s.Name, r.Method).Observe(elapsed)
+		})
+	}
+}
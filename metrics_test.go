@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServiceMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "metered", PathPrefix: "/api/metered", TargetURL: upstream.URL},
+			{Name: "down", PathPrefix: "/api/down", TargetURL: "http://127.0.0.1:1"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	ok := requestsTotal.WithLabelValues("metered", "/api/metered", "GET", "2xx")
+	notFound := requestsTotal.WithLabelValues("metered", "/api/metered", "GET", "4xx")
+	upstreamErrs := upstreamErrorsTotal.WithLabelValues("down", "/api/down", "error")
+	okBefore, notFoundBefore, errsBefore := testutil.ToFloat64(ok), testutil.ToFloat64(notFound), testutil.ToFloat64(upstreamErrs)
+	codeOK := httpRequestsTotal.WithLabelValues("metered", "GET", "200")
+	code404 := httpRequestsTotal.WithLabelValues("metered", "GET", "404")
+	codeOKBefore, code404Before := testutil.ToFloat64(codeOK), testutil.ToFloat64(code404)
+
+	for _, path := range []string{"/api/metered/a", "/api/metered/b/c", "/api/metered/missing", "/api/down/x"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if got := testutil.ToFloat64(ok) - okBefore; got != 2 {
+		t.Fatalf("unexpected 2xx count: got %v want 2", got)
+	}
+	if got := testutil.ToFloat64(notFound) - notFoundBefore; got != 1 {
+		t.Fatalf("unexpected 4xx count: got %v want 1", got)
+	}
+	if got := testutil.ToFloat64(upstreamErrs) - errsBefore; got != 1 {
+		t.Fatalf("unexpected upstream error count: got %v want 1", got)
+	}
+	if got := testutil.ToFloat64(codeOK) - codeOKBefore; got != 2 {
+		t.Fatalf("unexpected 200 count: got %v want 2", got)
+	}
+	if got := testutil.ToFloat64(code404) - code404Before; got != 1 {
+		t.Fatalf("unexpected 404 count: got %v want 1", got)
+	}
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected /metrics status: got %d want %d", rw.Code, http.StatusOK)
+	}
+	body := rw.Body.String()
+	for _, series := range []string{
+		`gateway_requests_total{method="GET",prefix="/api/metered",service="metered",status="2xx"}`,
+		`http_requests_total{method="GET",service="metered",status="404"}`,
+		`http_request_duration_seconds_count{method="GET",service="metered"}`,
+	} {
+		if !strings.Contains(body, series) {
+			t.Fatalf("/metrics is missing %s:\n%s", series, body)
+		}
+	}
+}
+
+func TestMetricsOnSeparatePort(t *testing.T) {
+	cfg := &Config{JWTSecret: "dummy", Server: ServerConfig{MetricsPort: ":9090"}}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("/metrics should not be on the main router: got %d", rw.Code)
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	disabled := false
+	cfg := &Config{JWTSecret: "dummy", Server: ServerConfig{MetricsEnabled: &disabled}}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("/metrics should not be served when disabled: got %d", rw.Code)
+	}
+}
+
+func TestMetricsWithoutAuth(t *testing.T) {
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "private", PathPrefix: "/api/private", TargetURL: "http://127.0.0.1:1", AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("/metrics must not require auth: got %d", rw.Code)
+	}
+}
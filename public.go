@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// validatePublicPaths checks public_paths: each is a path, possibly with
+// path.Match globs such as /api/users/*/avatar, or ends in /* to cover
+// everything below it, and lies under the service's path_prefix
+func (s ServiceConfig) validatePublicPaths() error {
+	if len(s.PublicPaths) == 0 {
+		return nil
+	}
+	if !s.authenticates() {
+		return errors.New("public_paths needs auth_required or auth_optional")
+	}
+	prefix := strings.TrimSuffix(s.PathPrefix, "/")
+	for _, p := range s.PublicPaths {
+		base := strings.TrimSuffix(p, "/*")
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("public_paths: %q must start with /", p)
+		}
+		if _, err := path.Match(base, ""); err != nil {
+			return fmt.Errorf("public_paths: %q: %w", p, err)
+		}
+		if base != prefix && !strings.HasPrefix(base, prefix+"/") {
+			return fmt.Errorf("public_paths: %q is outside path_prefix %q", p, s.PathPrefix)
+		}
+	}
+	return nil
+}
+
+// isPublic reports whether the request path matches one of paths. The path
+// is cleaned first so dot segments cannot climb out of a public subtree.
+func isPublic(paths []string, r *http.Request) bool {
+	p := path.Clean(r.URL.Path)
+	for _, pattern := range paths {
+		if matchPublicPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPublicPath matches p against pattern with path.Match, where a
+// trailing /* stands for any number of further segments
+func matchPublicPath(pattern, p string) bool {
+	base, below := strings.CutSuffix(pattern, "/*")
+	if !below {
+		ok, _ := path.Match(pattern, p)
+		return ok
+	}
+	// split off as many segments as base has, and require something after
+	n := strings.Count(base, "/")
+	parts := strings.SplitN(p, "/", n+2)
+	if len(parts) < n+2 {
+		return false
+	}
+	ok, _ := path.Match(base, strings.Join(parts[:n+1], "/"))
+	return ok
+}
+
+// skipForPublic runs auth, the service's authentication chain, except for
+// requests to public paths, which go straight to next. It sees the full
+// request path, before strip_prefix applies.
+func skipForPublic(paths []string, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authed := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isPublic(paths, r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authed.ServeHTTP(w, r)
+		})
+	}
+}
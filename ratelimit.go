@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// rate limit keys: per client address or per authenticated user
+const (
+	rateLimitByIP   = "ip"
+	rateLimitByUser = "user"
+)
+
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+	Key               string  `yaml:"key"`
+}
+
+func (c *RateLimitConfig) validate() error {
+	if c.RequestsPerSecond <= 0 {
+		return errors.New("rate_limit.requests_per_second must be positive")
+	}
+	if c.Burst < 0 {
+		return errors.New("rate_limit.burst must not be negative")
+	}
+	switch c.Key {
+	case "", rateLimitByIP, rateLimitByUser:
+	default:
+		return fmt.Errorf("rate_limit.key must be %q or %q, got %q", rateLimitByIP, rateLimitByUser, c.Key)
+	}
+	return nil
+}
+
+// byUser reports whether requests are limited per token subject
+func (c *RateLimitConfig) byUser() bool {
+	return c.Key == rateLimitByUser
+}
+
+// rateLimitNow is the clock of limiters created by buildRouter; tests replace
+// it to drive refills and eviction without sleeping
+var rateLimitNow = time.Now
+
+// rateLimiter is a set of token buckets, one per client key
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	// idle is how long a bucket takes to refill completely; an idle bucket is
+	// indistinguishable from a new one and can be dropped
+	idle time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(c RateLimitConfig) *rateLimiter {
+	burst := float64(c.Burst)
+	if burst == 0 {
+		// without an explicit burst allow one second worth of requests
+		burst = math.Max(1, math.Ceil(c.RequestsPerSecond))
+	}
+	return &rateLimiter{
+		rate:    c.RequestsPerSecond,
+		burst:   burst,
+		idle:    time.Duration(burst / c.RequestsPerSecond * float64(time.Second)),
+		now:     rateLimitNow,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// quota describes a client's bucket after a call to allow
+type quota struct {
+	limit     int
+	remaining int
+	// wait is how long until the next token, zero when one is available
+	wait time.Duration
+	// reset is how long until the bucket is full again
+	reset time.Duration
+}
+
+// allow takes a token from the bucket for key. When the bucket is empty it
+// reports in q.wait how long until the next token is available.
+func (l *rateLimiter) allow(key string) (ok bool, q quota) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		q.wait = l.duration(1 - b.tokens)
+	}
+	q.limit = int(l.burst)
+	q.remaining = int(b.tokens)
+	q.reset = l.duration(l.burst - b.tokens)
+	return ok, q
+}
+
+// wait reports how long until key's bucket has a token, without taking one;
+// zero means allow would succeed now
+func (l *rateLimiter) wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, found := l.buckets[key]
+	if !found {
+		return 0
+	}
+	tokens := math.Min(l.burst, b.tokens+l.now().Sub(b.last).Seconds()*l.rate)
+	if tokens >= 1 {
+		return 0
+	}
+	return l.duration(1 - tokens)
+}
+
+// duration returns how long it takes to refill n tokens
+func (l *rateLimiter) duration(n float64) time.Duration {
+	return time.Duration(n / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have been idle long enough to be full again. It
+// runs at most once per idle period so allow stays cheap; l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit rejects clients that exceed the limiter's rate with 429 and a
+// Retry-After header. Clients are keyed by clientIP, or
+// with byUser by the sub claim of the verified token when there is one. Every
+// response carries the X-RateLimit-* headers.
+func rateLimit(l *rateLimiter, byUser bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + clientIP(r)
+			if byUser {
+				if sub := tokenSubject(r); sub != "" {
+					key = "user:" + sub
+				}
+			}
+			ok, q := l.allow(key)
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(q.limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(q.remaining))
+			h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(q.reset.Seconds()))))
+			if !ok {
+				setRetryAfter(w, q.wait)
+				writeError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenSubject returns the sub claim stored by authMiddleware, if any
+func tokenSubject(r *http.Request) string {
+	claims, ok := r.Context().Value(userClaimsKey).(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// clientIP returns the client address without port. With forwarded headers
+// trusted explicitly, middleware.RealIP has already replaced RemoteAddr with
+// the forwarded client address when present.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(RateLimitConfig{RequestsPerSecond: 2, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	ok, q := l.allow("a")
+	if ok {
+		t.Fatal("expected request beyond burst to be limited")
+	}
+	if q.wait != 500*time.Millisecond {
+		t.Fatalf("unexpected wait: got %v want %v", q.wait, 500*time.Millisecond)
+	}
+	if q.remaining != 0 || q.reset != time.Second {
+		t.Fatalf("unexpected quota: remaining %d reset %v", q.remaining, q.reset)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("buckets must be per key")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("expected a token after refill")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Server:    ServerConfig{RateLimit: &RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1}},
+		Services: []ServiceConfig{
+			{Name: "limited", PathPrefix: "/api/limited", TargetURL: upstream.URL},
+			{Name: "generous", PathPrefix: "/api/generous", TargetURL: upstream.URL, RateLimit: &RateLimitConfig{RequestsPerSecond: 100}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	do := func(path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":4711"
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do("/api/limited/x", "10.0.0.1"); rw.Code != http.StatusOK {
+		t.Fatalf("first request: got %d want %d", rw.Code, http.StatusOK)
+	}
+	rw := do("/api/limited/x", "10.0.0.1")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: got %d want %d", rw.Code, http.StatusTooManyRequests)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("unexpected Retry-After: got %q want %q", got, "10")
+	}
+	if rw := do("/api/limited/x", "10.0.0.2"); rw.Code != http.StatusOK {
+		t.Fatalf("other client: got %d want %d", rw.Code, http.StatusOK)
+	}
+	// a forwarded address from an untrusted peer does not start a new bucket
+	req := httptest.NewRequest("GET", "/api/limited/x", nil)
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("X-Real-IP", "10.0.0.3")
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed X-Real-IP: got %d want %d", rw.Code, http.StatusTooManyRequests)
+	}
+	for i := 0; i < 3; i++ {
+		if rw := do("/api/generous/x", "10.0.0.1"); rw.Code != http.StatusOK {
+			t.Fatalf("service override: got %d want %d", rw.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(RateLimitConfig{RequestsPerSecond: 1, Burst: 5})
+	l.now = func() time.Time { return now }
+
+	l.allow("a")
+	now = now.Add(3 * time.Second)
+	l.allow("b")
+	if len(l.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(l.buckets))
+	}
+
+	// a has been idle for the 5s it takes to refill, b only for 2s
+	now = now.Add(2 * time.Second)
+	l.allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("expected idle bucket to be evicted")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Fatal("recently used bucket was evicted")
+	}
+}
+
+func TestRateLimitFakeClock(t *testing.T) {
+	now := time.Unix(0, 0)
+	rateLimitNow = func() time.Time { return now }
+	t.Cleanup(func() { rateLimitNow = time.Now })
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "limited", PathPrefix: "/api/limited", TargetURL: upstream.URL, RateLimit: &RateLimitConfig{RequestsPerSecond: 50, Burst: 100}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	do := func() int {
+		req := httptest.NewRequest("GET", "/api/limited/x", nil)
+		req.RemoteAddr = "10.0.0.1:4711"
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	for i := 0; i < 100; i++ {
+		if code := do(); code != http.StatusOK {
+			t.Fatalf("request %d within burst: got %d", i, code)
+		}
+	}
+	if code := do(); code != http.StatusTooManyRequests {
+		t.Fatalf("request beyond burst: got %d want %d", code, http.StatusTooManyRequests)
+	}
+	now = now.Add(20 * time.Millisecond)
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("request after refill: got %d want %d", code, http.StatusOK)
+	}
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	const secret = "per-user-secret"
+	cfg := &Config{
+		JWTSecret: secret,
+		Services: []ServiceConfig{
+			{
+				Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true,
+				RateLimit: &RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1, Key: "user"},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	do := func(sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/orders/x", nil)
+		req.RemoteAddr = "10.0.0.1:4711"
+		req.Header.Set("Authorization", "Bearer "+signToken(t, secret, jwt.MapClaims{"sub": sub}))
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do("alice"); rw.Code != http.StatusOK {
+		t.Fatalf("alice first request: got %d", rw.Code)
+	}
+	// same address, different user
+	if rw := do("bob"); rw.Code != http.StatusOK {
+		t.Fatalf("bob first request: got %d", rw.Code)
+	}
+	rw := do("alice")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("alice second request: got %d want %d", rw.Code, http.StatusTooManyRequests)
+	}
+	for header, want := range map[string]string{
+		"X-RateLimit-Limit":     "1",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     "10",
+		"Retry-After":           "10",
+	} {
+		if got := rw.Header().Get(header); got != want {
+			t.Errorf("%s: got %q want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectConfig sends requests under FromPrefix to To. With PreservePath
+// the part of the path after the prefix is appended to To.
+type RedirectConfig struct {
+	FromPrefix   string `yaml:"from_prefix"`
+	To           string `yaml:"to"`
+	Status       int    `yaml:"status"`
+	PreservePath bool   `yaml:"preserve_path"`
+}
+
+func (c RedirectConfig) status() int {
+	if c.Status == 0 {
+		return http.StatusFound
+	}
+	return c.Status
+}
+
+func (c RedirectConfig) validate() error {
+	if !strings.HasPrefix(c.FromPrefix, "/") {
+		return fmt.Errorf("from_prefix %q must start with /", c.FromPrefix)
+	}
+	switch c.status() {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("status must be 301, 302, 307 or 308, got %d", c.Status)
+	}
+	u, err := url.Parse(c.To)
+	if err != nil {
+		return fmt.Errorf("invalid to %q: %w", c.To, err)
+	}
+	if u.IsAbs() {
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("to %q must be an http or https url or a path", c.To)
+		}
+		return nil
+	}
+	// "//host" would send clients to another site with the current scheme
+	if !strings.HasPrefix(c.To, "/") || strings.HasPrefix(c.To, "//") {
+		return fmt.Errorf("to %q must be an http or https url or a path", c.To)
+	}
+	return nil
+}
+
+// validateRedirects checks every redirect and that none takes the prefix of
+// another redirect or a service
+func (c *Config) validateRedirects() error {
+	taken := make(map[string]string)
+	for _, s := range c.Services {
+		taken[s.PathPrefix] = "service " + s.Name
+	}
+	for _, rd := range c.Redirects {
+		if err := rd.validate(); err != nil {
+			return fmt.Errorf("redirect %s: %w", rd.FromPrefix, err)
+		}
+		if owner, ok := taken[rd.FromPrefix]; ok {
+			return fmt.Errorf("redirect %s: from_prefix is already used by %s", rd.FromPrefix, owner)
+		}
+		taken[rd.FromPrefix] = "another redirect"
+	}
+	return nil
+}
+
+// location builds the redirect target for r, carrying over its query string
+func (c RedirectConfig) location(r *http.Request) string {
+	loc, query, _ := strings.Cut(c.To, "?")
+	if c.PreservePath {
+		rest := strings.TrimPrefix(r.URL.EscapedPath(), strings.TrimSuffix(c.FromPrefix, "/"))
+		loc = strings.TrimSuffix(loc, "/") + rest
+	}
+	if query != "" && r.URL.RawQuery != "" {
+		query += "&"
+	}
+	if query += r.URL.RawQuery; query != "" {
+		loc += "?" + query
+	}
+	return loc
+}
+
+// redirect answers every request with a redirect to c.To
+func redirect(c RedirectConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, c.location(r), c.status())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Redirects: []RedirectConfig{
+			{FromPrefix: "/docs", To: "https://docs.example.com/gateway", Status: http.StatusMovedPermanently},
+			{FromPrefix: "/api/v1", To: "/api/v2", Status: http.StatusPermanentRedirect, PreservePath: true},
+			{FromPrefix: "/old-shop/", To: "https://shop.example.com/?ref=gateway", PreservePath: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/docs", http.StatusMovedPermanently, "https://docs.example.com/gateway"},
+		{"/docs/auth?lang=en", http.StatusMovedPermanently, "https://docs.example.com/gateway?lang=en"},
+		{"/api/v1/users/42?fields=name", http.StatusPermanentRedirect, "/api/v2/users/42?fields=name"},
+		{"/api/v1", http.StatusPermanentRedirect, "/api/v2"},
+		{"/old-shop/items/7?q=1", http.StatusFound, "https://shop.example.com/items/7?ref=gateway&q=1"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", tt.path, nil))
+		if rw.Code != tt.status || rw.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d %q want %d %q", tt.path, rw.Code, rw.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+func TestLoadConfigInvalidRedirects(t *testing.T) {
+	tests := map[string]string{
+		"relative prefix":   `{from_prefix: "docs", to: "https://docs.example.com"}`,
+		"bad status":        `{from_prefix: "/docs", to: "https://docs.example.com", status: 200}`,
+		"bad scheme":        `{from_prefix: "/docs", to: "ftp://docs.example.com"}`,
+		"protocol relative": `{from_prefix: "/docs", to: "//evil.example.com"}`,
+		"relative target":   `{from_prefix: "/docs", to: "docs"}`,
+		"service prefix":    `{from_prefix: "/api/users", to: "/api/v2/users"}`,
+	}
+	for name, redirect := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+redirects:
+  - `+redirect+`
+services:
+  - name: "users"
+    path_prefix: "/api/users"
+    target_url: "http://users:8080"
+`)
+			if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "redirect") {
+				t.Fatalf("expected redirect error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// routerSwitch serves through the most recently stored router. Requests
+// already being handled finish on the router they started with.
+type routerSwitch struct {
+	current atomic.Pointer[http.Handler]
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// store swaps in h and stops the background work of the router it replaces
+func (s *routerSwitch) store(h http.Handler, cancel context.CancelFunc) {
+	s.current.Store(&h)
+
+	s.mu.Lock()
+	prev := s.cancel
+	s.cancel = cancel
+	s.mu.Unlock()
+	if prev != nil {
+		prev()
+	}
+}
+
+func (s *routerSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.current.Load()).ServeHTTP(w, r)
+}
+
+// reloadRouter rebuilds the router from the config at path and swaps it in.
+// On any error the current router is left in place.
+func reloadRouter(path string, s *routerSwitch) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := buildRouter(ctx, cfg)
+	if err != nil {
+		cancel()
+		return err
+	}
+	s.store(r, cancel)
+	logger.Info("config reloaded", "services", len(cfg.Services))
+	return nil
+}
+
+// watchFile polls the file at path every interval and signals on the
+// returned channel when its size or modification time changes. A directory
+// or glob, as accepted by -config, is watched file by file. Changes that
+// happen while a signal is still pending are coalesced into it.
+func watchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		last, _ := configStamp(path)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			stamp, err := configStamp(path)
+			if err != nil {
+				// the file may be mid-replace by an editor; try again next tick
+				continue
+			}
+			if stamp == last {
+				continue
+			}
+			last = stamp
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReloadRouter(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upstream", name)
+		}))
+	}
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	defer b.Close()
+
+	config := func(target string) string {
+		return `
+services:
+  - name: "svc"
+    path_prefix: "/api/svc"
+    target_url: "` + target + `"
+    env_var: "TEST_RELOAD_SERVICE_URL"
+`
+	}
+	path := writeConfig(t, config(a.URL))
+
+	handler := &routerSwitch{}
+	if err := reloadRouter(path, handler); err != nil {
+		t.Fatal(err)
+	}
+	upstreamFor := func() string {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/api/svc/x", nil))
+		return rw.Header().Get("Upstream")
+	}
+	if got := upstreamFor(); got != "a" {
+		t.Fatalf("unexpected upstream: got %q want %q", got, "a")
+	}
+
+	if err := os.WriteFile(path, []byte(config(b.URL)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadRouter(path, handler); err != nil {
+		t.Fatal(err)
+	}
+	if got := upstreamFor(); got != "b" {
+		t.Fatalf("unexpected upstream after reload: got %q want %q", got, "b")
+	}
+
+	if err := os.WriteFile(path, []byte("services: [not yaml"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadRouter(path, handler); err == nil {
+		t.Fatal("expected reload of invalid config to fail")
+	}
+	if got := upstreamFor(); got != "b" {
+		t.Fatalf("invalid config replaced router: got upstream %q want %q", got, "b")
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := writeConfig(t, "services: []\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := watchFile(ctx, path, 10*time.Millisecond)
+
+	select {
+	case <-changed:
+		t.Fatal("unexpected change signal for an untouched file")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, []byte("server:\n  port: \":9000\"\nservices: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected a change signal after rewriting the config")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds ids taken from clients, which end up in logs
+	maxRequestIDLength = 128
+)
+
+// trustRequestID reports whether ids sent by clients are reused; it defaults to true
+func (c ServerConfig) trustRequestID() bool {
+	return c.TrustRequestID == nil || *c.TrustRequestID
+}
+
+// validRequestID accepts printable ASCII ids of a sane length
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDHeaderKey struct{}
+
+// requestID gives every request an id, reusing a valid one the client sent
+// in header when trusted. The id is stored where middleware.GetReqID finds
+// it, forwarded to upstreams and echoed on the response.
+func requestID(header string, trust bool) func(http.Handler) http.Handler {
+	if header == "" {
+		header = defaultRequestIDHeader
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !trust || !validRequestID(id) {
+				id = newRequestID()
+				r.Header.Set(header, id)
+			}
+			w.Header().Set(header, id)
+			ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+			ctx = context.WithValue(ctx, requestIDHeaderKey{}, header)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// dropUpstreamRequestID removes the request id header from an upstream
+// response; requestID already set the gateway's id on the client response and
+// the proxy would add the upstream's value next to it
+func dropUpstreamRequestID(resp *http.Response) {
+	if header, ok := resp.Request.Context().Value(requestIDHeaderKey{}).(string); ok {
+		resp.Header.Del(header)
+	}
+}
+
+// newRequestID returns a random UUID (version 4)
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+	return string(out[:])
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDForwarded(t *testing.T) {
+	var gotID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get("X-Correlation-ID")
+		w.Header().Set("X-Correlation-ID", "upstream-generated")
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{RequestIDHeader: "X-Correlation-ID"},
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL},
+			{Name: "private", PathPrefix: "/api/private", TargetURL: upstream.URL, AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/orders/1", nil))
+	echoed := rw.Header().Values("X-Correlation-ID")
+	if len(echoed) != 1 || echoed[0] != gotID {
+		t.Fatalf("expected the forwarded id %q echoed once, got %v", gotID, echoed)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(gotID) {
+		t.Fatalf("expected a generated UUID, got %q", gotID)
+	}
+
+	req := httptest.NewRequest("GET", "/api/orders/1", nil)
+	req.Header.Set("X-Correlation-ID", "client-id-1")
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if gotID != "client-id-1" || rw.Header().Get("X-Correlation-ID") != "client-id-1" {
+		t.Fatalf("expected the client id to be reused, upstream got %q, client got %q", gotID, rw.Header().Get("X-Correlation-ID"))
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/private/1", nil))
+	if rw.Code != http.StatusUnauthorized || rw.Header().Get("X-Correlation-ID") == "" {
+		t.Fatalf("expected gateway errors to carry the id, got %d %q", rw.Code, rw.Header().Get("X-Correlation-ID"))
+	}
+}
+
+func TestRequestIDUntrusted(t *testing.T) {
+	var gotID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get("X-Request-ID")
+	}))
+	defer upstream.Close()
+
+	trust := false
+	tests := map[string]struct {
+		server ServerConfig
+		id     string
+	}{
+		"not trusted":   {ServerConfig{TrustRequestID: &trust}, "client-id-1"},
+		"too long":      {ServerConfig{}, strings.Repeat("a", maxRequestIDLength+1)},
+		"not printable": {ServerConfig{}, "id with spaces"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := mustBuildRouter(t, &Config{
+				Server:    tt.server,
+				JWTSecret: "dummy",
+				Services:  []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL}},
+			})
+			req := httptest.NewRequest("GET", "/api/orders/1", nil)
+			req.Header.Set("X-Request-ID", tt.id)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if gotID == tt.id || gotID == "" {
+				t.Fatalf("expected the client id to be replaced, upstream got %q", gotID)
+			}
+			if rw.Header().Get("X-Request-ID") != gotID {
+				t.Fatalf("expected the upstream id %q on the response, got %q", gotID, rw.Header().Get("X-Request-ID"))
+			}
+		})
+	}
+}
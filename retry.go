@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryBackoff = 50 * time.Millisecond
+	maxRetryBackoff     = 2 * time.Second
+	// maxRetryBodyBytes caps how much of a request body is buffered for
+	// replay; larger bodies are streamed through without retries
+	maxRetryBodyBytes = 1 << 20
+)
+
+// defaultRetryStatuses are retried when a service sets retries but not
+// retry_on_status
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// retryTransport retries failed round trips that are safe to replay. It runs
+// before the response is handed to the ReverseProxy, so nothing has been
+// written to the client yet when a retry happens.
+type retryTransport struct {
+	base          http.RoundTripper
+	service       string
+	retries       int
+	backoff       time.Duration
+	statuses      []int
+	nonIdempotent bool
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.eligible(req) {
+		return t.base.RoundTrip(req)
+	}
+	req, ok := bufferBody(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt > t.retries || !t.shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		wait := t.backoffFor(attempt)
+		logger.Warn("retrying downstream request", "service", t.service, "upstream", req.URL.Host, "path", req.URL.Path, "attempt", attempt, "backoff", wait, "err", err, "status", statusOf(resp))
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// eligible reports whether the method may be retried: idempotent methods,
+// anything with a replayable body, and everything when retry_non_idempotent
+// is set
+func (t *retryTransport) eligible(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return t.nonIdempotent || req.GetBody != nil
+}
+
+// backoffFor doubles the configured backoff with every attempt
+func (t *retryTransport) backoffFor(attempt int) time.Duration {
+	d := t.backoff << (attempt - 1)
+	if d > maxRetryBackoff || d < 0 {
+		return maxRetryBackoff
+	}
+	return d
+}
+
+func (t *retryTransport) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return isRetryableError(err)
+	}
+	for _, s := range t.statuses {
+		if resp.StatusCode == s {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferBody makes the request body replayable by reading it into memory.
+// It reports false when the body is larger than maxRetryBodyBytes, in which
+// case the returned request streams the body unchanged.
+func bufferBody(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, true
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, maxRetryBodyBytes+1))
+	req = req.Clone(req.Context())
+	if err != nil || len(buf) > maxRetryBodyBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return req, false
+	}
+	req.Body.Close()
+	req.ContentLength = int64(len(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, true
+}
+
+// isRetryableError matches failures that happen before the upstream could
+// have acted on the request: refused dials and dropped connections
+func isRetryableError(err error) bool {
+	if isTimeout(err) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
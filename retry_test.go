@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryOnStatus(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "flaky", PathPrefix: "/api/flaky", TargetURL: upstream.URL, Retries: 2, RetryBackoff: "1ms"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	t.Run("idempotent", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/flaky/x", nil))
+
+		if got, want := rw.Code, http.StatusOK; got != want {
+			t.Fatalf("unexpected status: got %d want %d", got, want)
+		}
+		if got := atomic.LoadInt32(&hits); got != 3 {
+			t.Fatalf("unexpected attempts: got %d want 3", got)
+		}
+	})
+
+	t.Run("non-replayable body", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("POST", "/api/flaky/x", strings.NewReader(`{"a":1}`)))
+
+		if got, want := rw.Code, http.StatusServiceUnavailable; got != want {
+			t.Fatalf("unexpected status: got %d want %d", got, want)
+		}
+		if got := atomic.LoadInt32(&hits); got != 1 {
+			t.Fatalf("unexpected attempts: got %d want 1", got)
+		}
+	})
+}
+
+func TestRetryOnConnectionRefused(t *testing.T) {
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "down", PathPrefix: "/api/down", TargetURL: "http://127.0.0.1:1", Retries: 3, RetryBackoff: "1ms"},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/down/x", nil))
+	if got, want := rw.Code, http.StatusBadGateway; got != want {
+		t.Fatalf("unexpected status after exhausting retries: got %d want %d", got, want)
+	}
+}
+
+func TestLoadConfigInvalidRetries(t *testing.T) {
+	for name, fields := range map[string]string{
+		"negative retries": "retries: -1",
+		"bad backoff":      "retries: 1\n    retry_backoff: \"later\"",
+		"bad status":       "retries: 1\n    retry_on_status: [42]",
+	} {
+		path := writeConfig(t, `
+services:
+  - name: "svc"
+    path_prefix: "/api/svc"
+    target_url: "http://localhost:9999"
+    `+fields+`
+`)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "idem", PathPrefix: "/api/idem", TargetURL: upstream.URL, Retries: 1, RetryBackoff: "1ms"},
+			{Name: "any", PathPrefix: "/api/any", TargetURL: upstream.URL, Retries: 1, RetryBackoff: "1ms", RetryNonIdempotent: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	for _, tc := range []struct{ method, path string }{
+		{"PUT", "/api/idem/x"},
+		{"POST", "/api/any/x"},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			atomic.StoreInt32(&hits, 0)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"a":1}`)))
+
+			if got, want := rw.Code, http.StatusOK; got != want {
+				t.Fatalf("unexpected status: got %d want %d", got, want)
+			}
+			if got, want := rw.Body.String(), `{"a":1}`; got != want {
+				t.Fatalf("body not replayed: got %q want %q", got, want)
+			}
+			if got := atomic.LoadInt32(&hits); got != 2 {
+				t.Fatalf("unexpected attempts: got %d want 2", got)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffGrows(t *testing.T) {
+	rt := &retryTransport{backoff: 100 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		10: maxRetryBackoff,
+	} {
+		if got := rt.backoffFor(attempt); got != want {
+			t.Errorf("attempt %d: got %v want %v", attempt, got, want)
+		}
+	}
+}
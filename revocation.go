@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultRevocationRefreshInterval = 30 * time.Second
+	revocationTimeout                = 5 * time.Second
+)
+
+// revocation revokes one token by jti, or every token of a subject issued
+// up to revoked_at, until expires_at
+type revocation struct {
+	JTI       string    `json:"jti,omitempty"`
+	Sub       string    `json:"sub,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (e revocation) validate() error {
+	if (e.JTI == "") == (e.Sub == "") {
+		return errors.New("set either jti or sub")
+	}
+	if e.ExpiresAt.IsZero() {
+		return errors.New("expires_at must be set")
+	}
+	return nil
+}
+
+// revocationStore holds revoked tokens for authMiddleware to reject
+type revocationStore interface {
+	// revoked reports whether the token with claims is revoked at now
+	revoked(claims jwt.MapClaims, now time.Time) bool
+	// add revokes a token or subject
+	add(ctx context.Context, e revocation) error
+}
+
+// runtimeRevocations keeps entries added through the admin API when no
+// revocation backend is configured. It outlives router rebuilds, so a config
+// reload does not forget them.
+var runtimeRevocations = newMemoryRevocations()
+
+// memoryRevocations is a revocationStore in process memory. Expired entries
+// are dropped as new ones arrive, so the list stays bounded.
+type memoryRevocations struct {
+	mu   sync.RWMutex
+	jtis map[string]revocation
+	subs map[string]revocation
+}
+
+func newMemoryRevocations() *memoryRevocations {
+	return &memoryRevocations{jtis: make(map[string]revocation), subs: make(map[string]revocation)}
+}
+
+func (m *memoryRevocations) revoked(claims jwt.MapClaims, now time.Time) bool {
+	jti, _ := claims["jti"].(string)
+	sub, _ := claims["sub"].(string)
+	m.mu.RLock()
+	byJTI, jtiOK := m.jtis[jti]
+	bySub, subOK := m.subs[sub]
+	m.mu.RUnlock()
+	if jti != "" && jtiOK && now.Before(byJTI.ExpiresAt) {
+		return true
+	}
+	if sub != "" && subOK && now.Before(bySub.ExpiresAt) {
+		// tokens issued after the revocation, e.g. on logging in again, pass
+		iat, ok := claims["iat"].(float64)
+		return !ok || int64(iat) <= bySub.RevokedAt.Unix()
+	}
+	return false
+}
+
+func (m *memoryRevocations) add(_ context.Context, e revocation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(e)
+	m.sweep(time.Now())
+	return nil
+}
+
+// replace swaps in entries, as last read from a backend
+func (m *memoryRevocations) replace(entries []revocation) {
+	jtis, subs := make(map[string]revocation), make(map[string]revocation)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jtis, m.subs = jtis, subs
+	for _, e := range entries {
+		m.put(e)
+	}
+	m.sweep(time.Now())
+}
+
+// put records e, keeping the later expiry of two entries for the same token
+// or subject; m.mu must be held
+func (m *memoryRevocations) put(e revocation) {
+	entries, key := m.jtis, e.JTI
+	if e.Sub != "" {
+		entries, key = m.subs, e.Sub
+	}
+	if prev, ok := entries[key]; ok && prev.ExpiresAt.After(e.ExpiresAt) {
+		e.ExpiresAt = prev.ExpiresAt
+	}
+	entries[key] = e
+}
+
+// sweep drops expired entries; m.mu must be held
+func (m *memoryRevocations) sweep(now time.Time) {
+	for _, entries := range []map[string]revocation{m.jtis, m.subs} {
+		for key, e := range entries {
+			if !now.Before(e.ExpiresAt) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// RevocationConfig keeps revoked tokens in a file or behind a URL instead of
+// process memory, so they survive restarts and are shared between gateways
+type RevocationConfig struct {
+	// File holds one JSON entry per line; added entries are appended
+	File string `yaml:"file"`
+	// URL answers GET with a JSON array of entries and accepts added ones by POST
+	URL             string `yaml:"url"`
+	RefreshInterval string `yaml:"refresh_interval"`
+}
+
+func (c *RevocationConfig) validate() error {
+	if (c.File == "") == (c.URL == "") {
+		return errors.New("revocation: set either file or url")
+	}
+	if c.URL != "" {
+		if _, err := url.Parse(c.URL); err != nil {
+			return fmt.Errorf("revocation: invalid url: %w", err)
+		}
+	}
+	_, err := c.refreshInterval()
+	return err
+}
+
+func (c *RevocationConfig) refreshInterval() (time.Duration, error) {
+	if c.RefreshInterval == "" {
+		return defaultRevocationRefreshInterval, nil
+	}
+	d, err := time.ParseDuration(c.RefreshInterval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("revocation: invalid refresh_interval %q", c.RefreshInterval)
+	}
+	return d, nil
+}
+
+// syncedRevocations mirrors a file or URL backend in memory, re-reading it
+// every interval. Added entries are written to the backend first.
+type syncedRevocations struct {
+	*memoryRevocations
+	source   string
+	interval time.Duration
+	load     func(ctx context.Context) ([]revocation, error)
+	store    func(ctx context.Context, e revocation) error
+}
+
+// newRevocationStore returns the store of c, loaded once, or the in-memory
+// runtimeRevocations when c is nil. A synced store is refreshed until ctx ends.
+func newRevocationStore(ctx context.Context, c *RevocationConfig) (revocationStore, error) {
+	if c == nil {
+		return runtimeRevocations, nil
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	interval, _ := c.refreshInterval()
+	s := &syncedRevocations{memoryRevocations: newMemoryRevocations(), interval: interval}
+	if c.File != "" {
+		s.source, s.load, s.store = c.File, fileRevocations(c.File), appendRevocation(c.File)
+	} else {
+		client := &http.Client{Timeout: revocationTimeout}
+		s.source, s.load, s.store = c.URL, fetchRevocations(client, c.URL), postRevocation(client, c.URL)
+	}
+	// a backend that is down at startup is retried by run; nothing is revoked until then
+	s.refresh(ctx)
+	go s.run(ctx)
+	return s, nil
+}
+
+func (s *syncedRevocations) add(ctx context.Context, e revocation) error {
+	if err := s.store(ctx, e); err != nil {
+		return err
+	}
+	return s.memoryRevocations.add(ctx, e)
+}
+
+func (s *syncedRevocations) refresh(ctx context.Context) {
+	entries, err := s.load(ctx)
+	if err != nil {
+		// keep the last known entries rather than letting revoked tokens in
+		logger.Warn("revocation refresh failed", "source", s.source, "err", err)
+		return
+	}
+	s.replace(entries)
+}
+
+func (s *syncedRevocations) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refresh(ctx)
+		}
+	}
+}
+
+// fileRevocations reads a file of JSON lines; a missing file has no entries
+func fileRevocations(path string) func(context.Context) ([]revocation, error) {
+	return func(context.Context) ([]revocation, error) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entries []revocation
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for line := 1; sc.Scan(); line++ {
+			if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+				continue
+			}
+			var e revocation
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			entries = append(entries, e)
+		}
+		return entries, sc.Err()
+	}
+}
+
+func appendRevocation(path string) func(context.Context, revocation) error {
+	var mu sync.Mutex
+	return func(_ context.Context, e revocation) error {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+func fetchRevocations(client *http.Client, endpoint string) func(context.Context) ([]revocation, error) {
+	return func(ctx context.Context) ([]revocation, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("revocation endpoint returned %s", resp.Status)
+		}
+		var entries []revocation
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("decoding revocations: %w", err)
+		}
+		return entries, nil
+	}
+}
+
+func postRevocation(client *http.Client, endpoint string) func(context.Context, revocation) error {
+	return func(ctx context.Context, e revocation) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("revocation endpoint returned %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestRevocation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{AdminToken: "admin-secret"},
+		JWTSecret: "secret",
+		// lets the test sign a token as if issued after the revocation
+		JWTLeeway: "5m",
+		Services:  []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true}},
+	}
+	r := mustBuildRouter(t, cfg)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	issued := time.Now().Add(-time.Minute).Unix()
+	stolen := signToken(t, "secret", jwt.MapClaims{"sub": "alice", "jti": "revocation-test-1", "iat": issued})
+	other := signToken(t, "secret", jwt.MapClaims{"sub": "alice", "jti": "revocation-test-2", "iat": issued})
+
+	if rw := call("GET", "/api/orders/1", stolen, ""); rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 before revocation, got %d", rw.Code)
+	}
+	if rw := call("POST", "/admin/revocations", "admin-secret", `{"jti": "revocation-test-1", "ttl": "1h"}`); rw.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+	}
+	rw := call("GET", "/api/orders/1", stolen, "")
+	if rw.Code != http.StatusUnauthorized || decodeError(t, rw).Message != "Token Revoked" {
+		t.Fatalf("expected 401 Token Revoked, got %d: %s", rw.Code, rw.Body)
+	}
+	if rw := call("GET", "/api/orders/1", other, ""); rw.Code != http.StatusOK {
+		t.Fatalf("expected other jti to pass, got %d", rw.Code)
+	}
+
+	// revoking a subject logs out its existing tokens but not later ones
+	mallory := signToken(t, "secret", jwt.MapClaims{"sub": "revocation-test-mallory", "iat": issued})
+	later := signToken(t, "secret", jwt.MapClaims{"sub": "revocation-test-mallory", "iat": time.Now().Add(time.Minute).Unix()})
+	expires := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if rw := call("POST", "/admin/revocations", "admin-secret", `{"sub": "revocation-test-mallory", "expires_at": "`+expires+`"}`); rw.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rw.Code, rw.Body)
+	}
+	if rw := call("GET", "/api/orders/1", mallory, ""); rw.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked subject to get 401, got %d", rw.Code)
+	}
+	if rw := call("GET", "/api/orders/1", later, ""); rw.Code != http.StatusOK {
+		t.Fatalf("expected token issued after revocation to pass, got %d", rw.Code)
+	}
+
+	for _, body := range []string{
+		`{"ttl": "1h"}`,
+		`{"jti": "a", "sub": "b", "ttl": "1h"}`,
+		`{"jti": "a"}`,
+		`{"jti": "a", "ttl": "-1h"}`,
+		`{"jti": "a", "expires_at": "2001-01-01T00:00:00Z"}`,
+		`not json`,
+	} {
+		if rw := call("POST", "/admin/revocations", "admin-secret", body); rw.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rw.Code)
+		}
+	}
+}
+
+func TestMemoryRevocationsExpire(t *testing.T) {
+	m := newMemoryRevocations()
+	now := time.Now()
+	m.add(context.Background(), revocation{JTI: "a", RevokedAt: now, ExpiresAt: now.Add(time.Minute)})
+	claims := jwt.MapClaims{"jti": "a"}
+	if !m.revoked(claims, now) {
+		t.Fatal("expected token to be revoked")
+	}
+	if m.revoked(claims, now.Add(2*time.Minute)) {
+		t.Fatal("expected revocation to expire")
+	}
+
+	m.add(context.Background(), revocation{JTI: "b", RevokedAt: now, ExpiresAt: now.Add(-time.Second)})
+	if len(m.jtis) != 1 {
+		t.Fatalf("expected expired entries to be swept, have %d", len(m.jtis))
+	}
+}
+
+func TestFileRevocations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.jsonl")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := newRevocationStore(ctx, &RevocationConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := s.add(ctx, revocation{JTI: "a", RevokedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second gateway, or this one after a restart, reads the same file
+	s2, err := newRevocationStore(ctx, &RevocationConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s2.revoked(jwt.MapClaims{"jti": "a"}, now) {
+		t.Fatal("expected entry to be read from the file")
+	}
+
+	if err := os.WriteFile(path, []byte("not json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s2.(*syncedRevocations).refresh(ctx)
+	if !s2.revoked(jwt.MapClaims{"jti": "a"}, now) {
+		t.Fatal("expected a broken file to keep the last known entries")
+	}
+}
+
+func TestURLRevocations(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		entries []revocation
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			var e revocation
+			json.NewDecoder(r.Body).Decode(&e)
+			entries = append(entries, e)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	defer backend.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := newRevocationStore(ctx, &RevocationConfig{URL: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := s.add(ctx, revocation{Sub: "mallory", RevokedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the entry to be posted to the backend, got %v", entries)
+	}
+
+	s2, err := newRevocationStore(ctx, &RevocationConfig{URL: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s2.revoked(jwt.MapClaims{"sub": "mallory", "iat": float64(now.Add(-time.Minute).Unix())}, now) {
+		t.Fatal("expected entry to be fetched from the backend")
+	}
+}
+
+func TestLoadConfigInvalidRevocation(t *testing.T) {
+	for _, revocation := range []string{`{}`, `{file: "a", url: "http://b"}`, `{file: "a", refresh_interval: "soon"}`} {
+		path := writeConfig(t, `
+jwt_secret: "secret"
+revocation: `+revocation+`
+services: []
+`)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%s: expected error", revocation)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RewriteConfig rewrites the upstream path with a regular expression.
+// replacement may reference capture groups as $1 or ${name}.
+type RewriteConfig struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+func (c *RewriteConfig) compile() (*regexp.Regexp, error) {
+	if c.Pattern == "" {
+		return nil, errors.New("rewrite: pattern must be set")
+	}
+	re, err := regexp.Compile(c.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite: invalid pattern: %w", err)
+	}
+	return re, nil
+}
+
+// rewriteRule is a compiled RewriteConfig
+type rewriteRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// rewriteRules compiles the service's rewrite, or its rewrites in order
+func (s ServiceConfig) rewriteRules() ([]rewriteRule, error) {
+	if s.Rewrite != nil && len(s.Rewrites) > 0 {
+		return nil, errors.New("rewrite: set either rewrite or rewrites, not both")
+	}
+	configs := s.Rewrites
+	if s.Rewrite != nil {
+		configs = []RewriteConfig{*s.Rewrite}
+	}
+	var rules []rewriteRule
+	for i := range configs {
+		re, err := configs[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("rewrites[%d]: %w", i, err)
+		}
+		rules = append(rules, rewriteRule{re: re, replacement: configs[i].Replacement})
+	}
+	return rules, nil
+}
+
+// rewritePath applies the first rule matching path, leaving paths no rule
+// matches untouched
+func rewritePath(rules []rewriteRule, path string) string {
+	for _, r := range rules {
+		if r.re.MatchString(path) {
+			return r.re.ReplaceAllString(path, r.replacement)
+		}
+	}
+	return path
+}
+
+// rewriteURL strips prefix from the path of u and applies rules to what is
+// left. Both work on the escaped path so encoded characters such as %2F stay
+// encoded instead of turning into path separators.
+func rewriteURL(u *url.URL, prefix string, rules []rewriteRule) {
+	escaped := u.EscapedPath()
+	p := rewritePath(rules, strings.TrimPrefix(escaped, prefix))
+	if p == escaped {
+		return
+	}
+	path, err := url.PathUnescape(p)
+	if err != nil {
+		// a replacement produced an invalid escape; keep it literal
+		path = p
+	}
+	u.Path, u.RawPath = path, p
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRewritePath(t *testing.T) {
+	var gotURI string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{
+				Name: "users", PathPrefix: "/api/v1/users", TargetURL: upstream.URL,
+				Rewrite: &RewriteConfig{Pattern: `^/api/v1/users/(\d+)$`, Replacement: "/users/$1/profile"},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct{ path, want string }{
+		{"/api/v1/users/42?fields=name", "/users/42/profile?fields=name"},
+		{"/api/v1/users/me", "/api/v1/users/me"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", tt.path, nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", tt.path, rw.Code)
+		}
+		if gotURI != tt.want {
+			t.Errorf("%s: upstream got %q want %q", tt.path, gotURI, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigInvalidRewrite(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "users"
+    path_prefix: "/api/users"
+    target_url: "http://users:8080"
+    env_var: "TEST_REWRITE_SERVICE_URL"
+    rewrite:
+      pattern: "/users/(\\d+"
+      replacement: "/u/$1"
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for invalid rewrite pattern")
+	}
+}
+
+func TestRewriteRules(t *testing.T) {
+	var gotURI string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{
+				Name: "users", PathPrefix: "/api/v2/users", TargetURL: upstream.URL, StripPrefix: "/api/v2",
+				Rewrites: []RewriteConfig{
+					{Pattern: `^/users/([^/]+)/profile$`, Replacement: "/internal/profiles/$1"},
+					{Pattern: `^/users/(?P<id>[^/]+)(/.*)?$`, Replacement: "/internal/users/${id}$2"},
+				},
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct{ path, want string }{
+		{"/api/v2/users/42/profile", "/internal/profiles/42"},
+		{"/api/v2/users/42/orders?page=2", "/internal/users/42/orders?page=2"},
+		{"/api/v2/users/a%2Fb/profile", "/internal/profiles/a%2Fb"},
+		{"/api/v2/users", "/users"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", tt.path, nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", tt.path, rw.Code)
+		}
+		if gotURI != tt.want {
+			t.Errorf("%s: upstream got %q want %q", tt.path, gotURI, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigInvalidRewrites(t *testing.T) {
+	tests := map[string]string{
+		"invalid pattern": `
+    rewrites:
+      - pattern: "^/users$"
+        replacement: "/u"
+      - pattern: "/users/(\\d+"
+        replacement: "/u/$1"
+`,
+		"both forms": `
+    rewrite:
+      pattern: "^/a$"
+      replacement: "/b"
+    rewrites:
+      - pattern: "^/c$"
+        replacement: "/d"
+`,
+	}
+	for name, rules := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "users"
+    path_prefix: "/api/users"
+    target_url: "http://users:8080"
+    env_var: "TEST_REWRITE_SERVICE_URL"
+`+rules)
+			_, err := loadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), "service users") {
+				t.Fatalf("expected error naming the service, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const defaultRolesClaim = "roles"
+
+// claimValue walks a dot separated claim path such as "realm_access.roles"
+func claimValue(claims jwt.MapClaims, path string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// claimRoles reads the roles at path, accepting either a JSON array or a
+// comma separated string
+func claimRoles(claims jwt.MapClaims, path string) []string {
+	v, ok := claimValue(claims, path)
+	if !ok {
+		return nil
+	}
+	var roles []string
+	switch rs := v.(type) {
+	case []interface{}:
+		for _, r := range rs {
+			roles = append(roles, fmt.Sprintf("%v", r))
+		}
+	case string:
+		for _, r := range strings.Split(rs, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				roles = append(roles, r)
+			}
+		}
+	}
+	return roles
+}
+
+// requireRoles rejects requests whose token carries none of the given roles,
+// or with all set, not every one of them. It must run after authMiddleware.
+func requireRoles(required []string, all bool, rolesClaim string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value(userClaimsKey).(jwt.MapClaims)
+			have := make(map[string]bool)
+			for _, role := range claimRoles(claims, rolesClaim) {
+				have[role] = true
+			}
+			var missing []string
+			for _, want := range required {
+				if !have[want] {
+					missing = append(missing, want)
+				}
+			}
+			if len(missing) == 0 || (!all && len(missing) < len(required)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger.Warn("missing required role", "sub", claims["sub"], "required", required, "missing", missing, "require_all", all, "path", r.URL.Path)
+			writeError(w, r, http.StatusForbidden, "Insufficient Role")
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestClaimRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		path   string
+		want   []string
+	}{
+		{"array", jwt.MapClaims{"roles": []interface{}{"admin", "user"}}, "roles", []string{"admin", "user"}},
+		{"comma separated", jwt.MapClaims{"roles": "admin, user"}, "roles", []string{"admin", "user"}},
+		{"nested", jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}}}, "realm_access.roles", []string{"admin"}},
+		{"missing", jwt.MapClaims{"sub": "1"}, "roles", nil},
+		{"path through scalar", jwt.MapClaims{"realm_access": "x"}, "realm_access.roles", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claimRoles(tt.claims, tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredRoles(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret:  "secret",
+		RolesClaim: "realm_access.roles",
+		Services: []ServiceConfig{
+			{Name: "admin", PathPrefix: "/api/admin", TargetURL: upstream.URL, AuthRequired: true, RequiredRoles: []string{"admin", "ops"}},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name  string
+		roles []interface{}
+		want  int
+	}{
+		{"has role", []interface{}{"user", "ops"}, http.StatusOK},
+		{"lacks role", []interface{}{"user"}, http.StatusForbidden},
+		{"no roles", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"sub": "42", "realm_access": map[string]interface{}{"roles": tt.roles}}
+			req := httptest.NewRequest("GET", "/api/admin/x", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", claims))
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Code; got != tt.want {
+				t.Fatalf("unexpected status: got %d want %d", got, tt.want)
+			}
+			if tt.want == http.StatusForbidden && rw.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("expected JSON error body, got %q", rw.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestRequireAllRoles(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{
+				Name: "billing", PathPrefix: "/api/billing", TargetURL: upstream.URL, AuthRequired: true,
+				RequiredRoles: []string{"finance", "admin"}, RequireAllRoles: true,
+			},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name  string
+		roles []interface{}
+		want  int
+	}{
+		{"has all roles", []interface{}{"admin", "finance", "user"}, http.StatusOK},
+		{"has one role", []interface{}{"admin"}, http.StatusForbidden},
+		{"no roles", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"sub": "42", "roles": tt.roles}
+			req := httptest.NewRequest("GET", "/api/billing/x", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", claims))
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if got := rw.Code; got != tt.want {
+				t.Fatalf("unexpected status: got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	routeKindGateway  = "gateway"
+	routeKindRedirect = "redirect"
+	routeKindService  = "service"
+)
+
+// routeEntry describes one prefix the router serves, together with its
+// wildcard pattern, for -print-routes
+type routeEntry struct {
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name,omitempty"`
+	Methods      []string `json:"methods"`
+	Prefix       string   `json:"prefix"`
+	Wildcard     string   `json:"wildcard,omitempty"`
+	Targets      []string `json:"targets"`
+	Auth         string   `json:"auth"`
+	AuthOptional bool     `json:"auth_optional,omitempty"`
+	StripPrefix  string   `json:"strip_prefix,omitempty"`
+	Rewrites     []string `json:"rewrites,omitempty"`
+}
+
+// routeTable lists the routes buildRouter registers for cfg, ordered by
+// prefix. Methods are "*" where any method is accepted.
+func routeTable(cfg *Config) ([]routeEntry, error) {
+	anyMethod := []string{"*"}
+	routes := []routeEntry{
+		{Kind: routeKindGateway, Methods: []string{"GET"}, Prefix: "/healthz", Targets: []string{"gateway"}, Auth: "none"},
+		{Kind: routeKindGateway, Methods: anyMethod, Prefix: "/healthz/services", Targets: []string{"gateway"}, Auth: "none"},
+		{Kind: routeKindGateway, Methods: anyMethod, Prefix: "/readyz", Targets: []string{"gateway"}, Auth: "none"},
+	}
+	if cfg.Server.metricsEnabled() && cfg.Server.MetricsPort == "" {
+		routes = append(routes, routeEntry{Kind: routeKindGateway, Methods: anyMethod, Prefix: "/metrics", Targets: []string{"gateway"}, Auth: "none"})
+	}
+	if cfg.Server.AdminToken != "" {
+		routes = append(routes, routeEntry{Kind: routeKindGateway, Methods: anyMethod, Prefix: "/admin", Wildcard: "/admin/*", Targets: []string{"gateway"}, Auth: "admin_token"})
+	}
+
+	for _, rd := range cfg.Redirects {
+		routes = append(routes, routeEntry{
+			Kind:     routeKindRedirect,
+			Methods:  anyMethod,
+			Prefix:   rd.FromPrefix,
+			Wildcard: strings.TrimSuffix(rd.FromPrefix, "/") + "/*",
+			Targets:  []string{fmt.Sprintf("%d %s", rd.status(), rd.To)},
+			Auth:     "none",
+		})
+	}
+
+	for _, s := range cfg.Services {
+		methods, err := s.allowedMethods()
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", s.Name, err)
+		}
+		if len(methods) == 0 {
+			methods = anyMethod
+		}
+		targets := s.targets()
+		if s.static() {
+			targets = []string{fmt.Sprintf("static %d", s.staticStatus())}
+		}
+		auth := "none"
+		if s.authenticates() {
+			auth = s.authMode()
+		}
+		e := routeEntry{
+			Kind:         routeKindService,
+			Name:         s.Name,
+			Methods:      methods,
+			Prefix:       s.PathPrefix,
+			Wildcard:     s.PathPrefix + "/*",
+			Targets:      targets,
+			Auth:         auth,
+			AuthOptional: s.AuthOptional,
+			StripPrefix:  s.StripPrefix,
+		}
+		rewrites := s.Rewrites
+		if s.Rewrite != nil {
+			rewrites = []RewriteConfig{*s.Rewrite}
+		}
+		for _, rw := range rewrites {
+			e.Rewrites = append(e.Rewrites, rw.Pattern+" => "+rw.Replacement)
+		}
+		routes = append(routes, e)
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Prefix < routes[j].Prefix })
+	return routes, nil
+}
+
+// printRoutes writes the route table of cfg to out, as aligned columns or,
+// with asJSON, as a JSON array
+func printRoutes(cfg *Config, out io.Writer, asJSON bool) error {
+	routes, err := routeTable(cfg)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(routes)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tWILDCARD\tMETHODS\tKIND\tNAME\tTARGET\tAUTH\tSTRIP\tREWRITE")
+	for _, e := range routes {
+		auth := e.Auth
+		if e.AuthOptional {
+			auth += " (optional)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Prefix, orDash(e.Wildcard), strings.Join(e.Methods, ","), e.Kind, orDash(e.Name),
+			strings.Join(e.Targets, ","), auth, orDash(e.StripPrefix), orDash(strings.Join(e.Rewrites, "; ")))
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrintRoutes(t *testing.T) {
+	t.Setenv("TEST_ROUTES_ORDERS_URL", "http://orders.internal:8080")
+	path := writeConfig(t, `
+jwt_secret: "secret"
+redirects:
+  - from_prefix: "/old"
+    to: "https://example.com/new"
+    status: 301
+services:
+  - name: "status"
+    path_prefix: "/status"
+    type: static
+    body: "ok"
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    env_var: "TEST_ROUTES_ORDERS_URL"
+    strip_prefix: "/api"
+    auth_optional: true
+    allowed_methods: [get, post]
+    rewrite:
+      pattern: "^/v1/(.*)$"
+      replacement: "/$1"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := printRoutes(cfg, &out, true); err != nil {
+		t.Fatal(err)
+	}
+	var routes []routeEntry
+	if err := json.Unmarshal(out.Bytes(), &routes); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, out.String())
+	}
+	var prefixes []string
+	for _, e := range routes {
+		prefixes = append(prefixes, e.Prefix)
+	}
+	want := []string{"/api/orders", "/healthz", "/healthz/services", "/metrics", "/old", "/readyz", "/status"}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Fatalf("prefixes = %v want %v", prefixes, want)
+	}
+	orders := routeEntry{
+		Kind:         routeKindService,
+		Name:         "orders",
+		Methods:      []string{"GET", "POST"},
+		Prefix:       "/api/orders",
+		Wildcard:     "/api/orders/*",
+		Targets:      []string{"http://orders.internal:8080"},
+		Auth:         authJWT,
+		AuthOptional: true,
+		StripPrefix:  "/api",
+		Rewrites:     []string{"^/v1/(.*)$ => /$1"},
+	}
+	if !reflect.DeepEqual(routes[0], orders) {
+		t.Errorf("orders route = %+v\nwant %+v", routes[0], orders)
+	}
+	if got := routes[4].Targets; len(got) != 1 || got[0] != "301 https://example.com/new" {
+		t.Errorf("redirect targets = %v", got)
+	}
+	if got := routes[6].Targets; len(got) != 1 || got[0] != "static 200" {
+		t.Errorf("static targets = %v", got)
+	}
+
+	out.Reset()
+	if err := printRoutes(cfg, &out, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want)+1 || !strings.HasPrefix(lines[0], "PREFIX") {
+		t.Fatalf("unexpected table:\n%s", out.String())
+	}
+	if fields := strings.Fields(lines[1]); !reflect.DeepEqual(fields, []string{
+		"/api/orders", "/api/orders/*", "GET,POST", "service", "orders", "http://orders.internal:8080", "jwt", "(optional)", "/api", "^/v1/(.*)$", "=>", "/$1",
+	}) {
+		t.Errorf("orders row = %q", lines[1])
+	}
+
+	// the same config prints the same table
+	again := new(bytes.Buffer)
+	if err := printRoutes(cfg, again, false); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != out.String() {
+		t.Error("table output is not deterministic")
+	}
+}
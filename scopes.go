@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writeMethods are the methods write_scopes apply to
+var writeMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+func (s ServiceConfig) validateScopes() error {
+	if len(s.RequiredScopes) == 0 && len(s.WriteScopes) == 0 {
+		return nil
+	}
+	if !s.AuthRequired {
+		return errors.New("required_scopes and write_scopes need auth_required: true")
+	}
+	for _, scope := range append(append([]string{}, s.RequiredScopes...), s.WriteScopes...) {
+		// scopes travel space separated, in the claim and in WWW-Authenticate
+		if scope == "" || strings.ContainsAny(scope, " \"\\") {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// claimScopes reads the OAuth scopes of a token: the space separated scope
+// claim, else the scp claim as an array or string
+func claimScopes(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	switch scp := claims["scp"].(type) {
+	case []interface{}:
+		scopes := make([]string, 0, len(scp))
+		for _, s := range scp {
+			scopes = append(scopes, fmt.Sprintf("%v", s))
+		}
+		return scopes
+	case string:
+		return strings.Fields(scp)
+	}
+	return nil
+}
+
+// requireScopes rejects requests whose token lacks any of required, or for
+// POST, PUT, PATCH and DELETE any of write as well, with an RFC 6750
+// insufficient_scope challenge. It must run after authMiddleware.
+func requireScopes(required, write []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			want := required
+			if writeMethods[r.Method] && len(write) > 0 {
+				want = append(append([]string{}, required...), write...)
+			}
+			claims, _ := r.Context().Value(userClaimsKey).(jwt.MapClaims)
+			have := make(map[string]bool)
+			for _, scope := range claimScopes(claims) {
+				have[scope] = true
+			}
+			var missing []string
+			for _, scope := range want {
+				if !have[scope] {
+					missing = append(missing, scope)
+				}
+			}
+			if len(missing) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger.Warn("missing required scope", "sub", claims["sub"], "required", want, "missing", missing, "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", error_description="The request requires higher privileges than provided by the access token", scope="%s"`, strings.Join(want, " ")))
+			writeError(w, r, http.StatusForbidden, "Insufficient Scope")
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestRequiredScopes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{{
+			Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true,
+			RequiredScopes: []string{"orders:read"}, WriteScopes: []string{"orders:write"},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	tests := []struct {
+		name   string
+		method string
+		claims jwt.MapClaims
+		want   int
+		scope  string
+	}{
+		{"read with scope string", "GET", jwt.MapClaims{"scope": "orders:read profile"}, http.StatusOK, ""},
+		{"read with scp array", "GET", jwt.MapClaims{"scp": []string{"orders:read"}}, http.StatusOK, ""},
+		{"read without scope", "GET", jwt.MapClaims{"scope": "profile"}, http.StatusForbidden, "orders:read"},
+		{"no scope claim", "GET", jwt.MapClaims{}, http.StatusForbidden, "orders:read"},
+		{"write with read only", "POST", jwt.MapClaims{"scope": "orders:read"}, http.StatusForbidden, "orders:read orders:write"},
+		{"write with both", "DELETE", jwt.MapClaims{"scope": "orders:read orders:write"}, http.StatusOK, ""},
+		{"write only is not read", "GET", jwt.MapClaims{"scope": "orders:write"}, http.StatusForbidden, "orders:read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["sub"] = "42"
+			req := httptest.NewRequest(tt.method, "/api/orders/1", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", tt.claims))
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+			want := ""
+			if tt.scope != "" {
+				want = `Bearer error="insufficient_scope", error_description="The request requires higher privileges than provided by the access token", scope="` + tt.scope + `"`
+			}
+			if got := rw.Header().Get("WWW-Authenticate"); got != want {
+				t.Errorf("WWW-Authenticate = %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidScopes(t *testing.T) {
+	tests := map[string]string{
+		"without auth":  `required_scopes: ["orders:read"]`,
+		"write no auth": `write_scopes: ["orders:write"]`,
+		"with space":    "auth_required: true\n    required_scopes: [\"orders:read orders:write\"]",
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+jwt_secret: "secret"
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    `+extra+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid scopes")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeLogInterval is how often a shutdown logs the requests still in flight
+const activeLogInterval = time.Second
+
+// activeRequests counts the requests being served. It backs the shutdown
+// progress log, /admin/requests and gateway_active_requests.
+var activeRequests atomic.Int64
+
+// countActive keeps activeRequests up to date
+func countActive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		activeRequests.Add(1)
+		defer activeRequests.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logActive logs the number of requests in flight every interval until it
+// reaches zero or stop is closed
+func logActive(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		n := activeRequests.Load()
+		if n == 0 {
+			return
+		}
+		logger.Info("waiting for in-flight requests", "active", n)
+	}
+}
+
+// connTracker follows the state of a server's connections via ConnState so a
+// shutdown that runs out of time can report what it cut off
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]http.ConnState)}
+}
+
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, c)
+	default:
+		t.conns[c] = state
+	}
+}
+
+// busy counts connections that are not idle, i.e. still serving a request
+func (t *connTracker) busy() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, state := range t.conns {
+		if state != http.StateIdle {
+			n++
+		}
+	}
+	return n
+}
+
+// shutdownServer waits for in-flight requests until ctx is done and then
+// cancels the requests that are left, via cancelRequests, and closes their
+// connections, returning how many were cut off
+func shutdownServer(ctx context.Context, srv *http.Server, conns *connTracker, cancelRequests context.CancelFunc) (int, error) {
+	if n := activeRequests.Load(); n > 0 {
+		logger.Info("waiting for in-flight requests", "active", n)
+	}
+	stop := make(chan struct{})
+	go logActive(stop, activeLogInterval)
+	err := srv.Shutdown(ctx)
+	close(stop)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return 0, err
+	}
+	n := conns.busy()
+	cancelRequests()
+	srv.Close()
+	return n, err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShutdownServerReportsForcedConnections(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	conns := newConnTracker()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-release:
+		}
+	}))
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv.Config.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	srv.Config.ConnState = conns.track
+	srv.Start()
+	defer srv.Close()
+	defer close(release)
+
+	go http.Get(srv.URL)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	forced, err := shutdownServer(ctx, srv.Config, conns, cancelRequests)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if forced != 1 {
+		t.Fatalf("expected 1 forced connection, got %d", forced)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the in-flight request's context to be cancelled")
+	}
+}
+
+func TestLoadConfigDrainDelay(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  drain_delay: "10s"
+services: []
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := cfg.Server.drainDelay(); d != 10*time.Second {
+		t.Fatalf("unexpected drain delay %v", d)
+	}
+
+	path = writeConfig(t, `
+server:
+  drain_delay: "-1s"
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for negative drain_delay")
+	}
+}
+
+func TestHealthzFailsWhileDraining(t *testing.T) {
+	r := mustBuildRouter(t, &Config{JWTSecret: "dummy"})
+	draining.Store(true)
+	t.Cleanup(func() { draining.Store(false) })
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected 503 while draining, got %d", path, rw.Code)
+		}
+	}
+}
+
+func TestLoadConfigShutdownTimeout(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  shutdown_timeout: "30s"
+services: []
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := cfg.Server.shutdownTimeout(); d != 30*time.Second {
+		t.Fatalf("unexpected shutdown timeout %v", d)
+	}
+	if d, _ := (ServerConfig{}).shutdownTimeout(); d != defaultShutdownTimeout {
+		t.Fatalf("unexpected default shutdown timeout %v", d)
+	}
+
+	path = writeConfig(t, `
+server:
+  shutdown_timeout: "soon"
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for invalid shutdown_timeout")
+	}
+}
+
+func TestActiveRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Server:    ServerConfig{AdminToken: "admin-secret"},
+		JWTSecret: "dummy",
+		Services:  []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	active := func() int64 {
+		req := httptest.NewRequest("GET", "/admin/requests", nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		var body struct{ Active int64 }
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Active
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/orders/1", nil))
+	}()
+	<-started
+	// the admin request counts itself
+	if got := active(); got != 2 {
+		t.Fatalf("expected 2 active requests, got %d", got)
+	}
+	if got := testutil.ToFloat64(activeRequestsGauge); got != 1 {
+		t.Fatalf("expected gauge 1, got %v", got)
+	}
+	close(release)
+	<-done
+	if got := active(); got != 1 {
+		t.Fatalf("expected 1 active request after release, got %d", got)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	serviceTypeProxy  = "proxy"
+	serviceTypeStatic = "static"
+)
+
+const defaultStaticContentType = "text/plain; charset=utf-8"
+
+// static reports whether the service answers with a fixed response instead
+// of proxying
+func (s ServiceConfig) static() bool {
+	return s.Type == serviceTypeStatic
+}
+
+func (s ServiceConfig) staticStatus() int {
+	if s.Status == 0 {
+		return http.StatusOK
+	}
+	return s.Status
+}
+
+func (s ServiceConfig) validateStatic() error {
+	switch s.Type {
+	case "", serviceTypeProxy:
+		if s.Status != 0 || s.ContentType != "" || s.Body != "" || s.BodyFile != "" {
+			return errors.New("status, content_type, body and body_file need type: static")
+		}
+		return nil
+	case serviceTypeStatic:
+	default:
+		return fmt.Errorf("unknown type %q, want %q or %q", s.Type, serviceTypeProxy, serviceTypeStatic)
+	}
+	switch {
+	case len(s.targets()) > 0:
+		return errors.New("static services have no target_url or target_urls")
+	case s.Canary != nil || s.FallbackURL != "" || s.HeaderRoutes != nil || len(s.Match) > 0 || s.Transform != nil || s.UpstreamTLS != nil || s.Sticky != nil:
+		return errors.New("canary, fallback_url, header_routes, match, transform, upstream_tls and sticky need an upstream, not type: static")
+	case s.HealthCheckPath != "" || s.Retries > 0 || s.GRPC || s.WebSocket:
+		return errors.New("health_check_path, retries, grpc and websocket need an upstream, not type: static")
+	case s.Status != 0 && (s.Status < 200 || s.Status > 599):
+		return fmt.Errorf("invalid status %d", s.Status)
+	case s.Body != "" && s.BodyFile != "":
+		return errors.New("set either body or body_file, not both")
+	}
+	if s.BodyFile != "" {
+		if _, err := os.Stat(s.BodyFile); err != nil {
+			return fmt.Errorf("body_file: %w", err)
+		}
+	}
+	return nil
+}
+
+// staticHandler answers every request with the configured status and body.
+// body_file is read here, so a reload picks up a changed file.
+func staticHandler(s ServiceConfig) (http.Handler, error) {
+	body := []byte(s.Body)
+	if s.BodyFile != "" {
+		var err error
+		if body, err = os.ReadFile(s.BodyFile); err != nil {
+			return nil, fmt.Errorf("body_file: %w", err)
+		}
+	}
+	status := s.staticStatus()
+	contentType := s.ContentType
+	if contentType == "" {
+		contentType = defaultStaticContentType
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+	}), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestStaticService(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "maintenance.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"message":"payments are being migrated"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		JWTSecret: "secret",
+		Services: []ServiceConfig{
+			{Name: "payments", PathPrefix: "/api/payments", Type: serviceTypeStatic, Status: http.StatusServiceUnavailable, ContentType: "application/json", BodyFile: bodyFile},
+			{Name: "stub", PathPrefix: "/api/stub", Type: serviceTypeStatic, Body: "ok", AuthRequired: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("POST", "/api/payments/charges", nil))
+	if rw.Code != http.StatusServiceUnavailable || rw.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+	if got := rw.Body.String(); got != `{"message":"payments are being migrated"}` {
+		t.Fatalf("unexpected body %q", got)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/stub", nil))
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatalf("expected static routes to require auth, got %d", rw.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/stub", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", jwt.MapClaims{"sub": "42"}))
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || rw.Body.String() != "ok" || rw.Header().Get("Content-Type") != defaultStaticContentType {
+		t.Fatalf("unexpected response %d %q %q", rw.Code, rw.Body.String(), rw.Header().Get("Content-Type"))
+	}
+}
+
+func TestLoadConfigStaticService(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "payments"
+    path_prefix: "/api/payments"
+    type: static
+    status: 503
+    content_type: "application/json"
+    body: '{"error":"maintenance"}'
+`)
+	if _, err := loadConfig(path); err != nil {
+		t.Fatalf("static services need no target: %v", err)
+	}
+
+	tests := map[string]string{
+		"unknown type":      "type: lambda",
+		"target":            "type: static\n    target_url: \"http://payments:8080\"",
+		"both bodies":       "type: static\n    body: \"x\"\n    body_file: \"/tmp/x\"",
+		"missing body file": "type: static\n    body_file: \"/nonexistent/body.json\"",
+		"bad status":        "type: static\n    status: 42",
+		"status on proxy":   "target_url: \"http://payments:8080\"\n    status: 503",
+	}
+	for name, fields := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "payments"
+    path_prefix: "/api/payments"
+    `+fields+`
+`)
+			if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "service payments") {
+				t.Fatalf("expected error naming the service, got %v", err)
+			}
+		})
+	}
+}
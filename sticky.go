@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// defaultStickyCookie names the affinity cookie when sticky sets no cookie
+const defaultStickyCookie = "gateway_affinity"
+
+// StickyConfig pins a client to the upstream that served its first request
+// with a cookie
+type StickyConfig struct {
+	Cookie   string `yaml:"cookie"`
+	TTL      string `yaml:"ttl"`
+	SameSite string `yaml:"same_site"`
+	Secure   bool   `yaml:"secure"`
+}
+
+func (c *StickyConfig) cookieName() string {
+	if c.Cookie == "" {
+		return defaultStickyCookie
+	}
+	return c.Cookie
+}
+
+// ttl is the cookie's lifetime; zero makes it a session cookie
+func (c *StickyConfig) ttl() (time.Duration, error) {
+	if c.TTL == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.TTL)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("sticky: invalid ttl %q", c.TTL)
+	}
+	return d, nil
+}
+
+func (c *StickyConfig) sameSite() (http.SameSite, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("sticky: invalid same_site %q, want lax, strict or none", c.SameSite)
+}
+
+func (c *StickyConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if !httpguts.ValidHeaderFieldName(c.cookieName()) {
+		return fmt.Errorf("sticky: invalid cookie name %q", c.Cookie)
+	}
+	if _, err := c.ttl(); err != nil {
+		return err
+	}
+	sameSite, err := c.sameSite()
+	if err != nil {
+		return err
+	}
+	// browsers drop SameSite=None cookies that aren't Secure
+	if sameSite == http.SameSiteNoneMode && !c.Secure {
+		return errors.New("sticky: same_site none needs secure")
+	}
+	return nil
+}
+
+// stickySessions routes requests carrying the affinity cookie back to the
+// upstream named in it
+type stickySessions struct {
+	cookie   string
+	path     string
+	maxAge   int
+	sameSite http.SameSite
+	secure   bool
+}
+
+func newStickySessions(c *StickyConfig, path string) (*stickySessions, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	ttl, _ := c.ttl()
+	sameSite, _ := c.sameSite()
+	return &stickySessions{
+		cookie:   c.cookieName(),
+		path:     path,
+		maxAge:   int(ttl / time.Second),
+		sameSite: sameSite,
+		secure:   c.Secure,
+	}, nil
+}
+
+// upstreamID names an upstream in the cookie without giving away its
+// address. It only depends on the url, so it survives reloads and is the
+// same on every gateway instance.
+func upstreamID(u *upstream) string {
+	h := fnv.New64a()
+	h.Write([]byte(u.url.String()))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// pinned returns the upstream the request's cookie names, or nil when there
+// is no cookie or that upstream is gone, unhealthy or recently failed
+func (s *stickySessions) pinned(r *http.Request, lb *balancer) *upstream {
+	c, err := r.Cookie(s.cookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	now := time.Now()
+	for _, u := range lb.current() {
+		if upstreamID(u) == c.Value {
+			if !u.healthy.Load() || u.recentlyFailed(now) {
+				return nil
+			}
+			return u
+		}
+	}
+	return nil
+}
+
+// pin sets the cookie that sends the client's next requests to u
+func (s *stickySessions) pin(w http.ResponseWriter, u *upstream) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookie,
+		Value:    upstreamID(u),
+		Path:     s.path,
+		MaxAge:   s.maxAge,
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: s.sameSite,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStickySessions(t *testing.T) {
+	newUpstreamServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	a, b := newUpstreamServer("a"), newUpstreamServer("b")
+	defer b.Close()
+
+	cfg := &Config{
+		Services: []ServiceConfig{{
+			Name: "carts", PathPrefix: "/api/carts", TargetURLs: []string{a.URL, b.URL},
+			Sticky: &StickyConfig{Cookie: "cart_affinity", TTL: "1h", SameSite: "strict", Secure: true},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+	send := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/carts/1", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := send(nil)
+	cookies := rw.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "cart_affinity" {
+		t.Fatalf("expected the affinity cookie, got %v", rw.Header()["Set-Cookie"])
+	}
+	c := cookies[0]
+	if c.Path != "/api/carts" || c.MaxAge != 3600 || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+		t.Errorf("cookie attributes: %+v", c)
+	}
+	first := rw.Body.String()
+	if first != "a" {
+		t.Fatalf("first request went to %q, want a", first)
+	}
+	// round-robin would alternate; the cookie keeps the client on a
+	for i := 0; i < 4; i++ {
+		rw := send(c)
+		if rw.Body.String() != "a" {
+			t.Fatalf("request %d went to %q, want a", i, rw.Body.String())
+		}
+		if len(rw.Result().Cookies()) != 0 {
+			t.Errorf("request %d: cookie set again for a pinned client", i)
+		}
+	}
+
+	// a stale or forged cookie is balanced as usual and re-pinned
+	if rw := send(&http.Cookie{Name: "cart_affinity", Value: "nope"}); len(rw.Result().Cookies()) != 1 {
+		t.Errorf("unknown upstream id: expected a new cookie")
+	}
+
+	// once a fails the client moves to b and stays there
+	a.Close()
+	if rw := send(c); rw.Code != http.StatusBadGateway {
+		t.Fatalf("failed upstream: got %d want %d", rw.Code, http.StatusBadGateway)
+	}
+	rw = send(c)
+	if rw.Body.String() != "b" {
+		t.Fatalf("after failure went to %q, want b", rw.Body.String())
+	}
+	cookies = rw.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == c.Value {
+		t.Fatalf("expected the cookie to be re-pinned, got %v", rw.Header()["Set-Cookie"])
+	}
+	if rw := send(cookies[0]); rw.Body.String() != "b" {
+		t.Errorf("re-pinned client went to %q, want b", rw.Body.String())
+	}
+}
+
+func TestStickyUnhealthyUpstream(t *testing.T) {
+	lb, err := newBalancer([]string{"http://a:8080", "http://b:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newStickySessions(&StickyConfig{}, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := lb.current()[0]
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultStickyCookie, Value: upstreamID(a)})
+	if got := s.pinned(req, lb); got != a {
+		t.Fatalf("pinned = %v want %v", got, a)
+	}
+	a.healthy.Store(false)
+	if got := s.pinned(req, lb); got != nil {
+		t.Errorf("unhealthy upstream still pinned: %v", got.url)
+	}
+}
+
+func TestLoadConfigInvalidSticky(t *testing.T) {
+	const target = "target_url: \"http://carts:8080\"\n    "
+	tests := map[string]string{
+		"bad ttl":            target + `sticky: {ttl: "a while"}`,
+		"negative ttl":       target + `sticky: {ttl: "-1h"}`,
+		"bad same_site":      target + `sticky: {same_site: "sometimes"}`,
+		"none not secure":    target + `sticky: {same_site: "none"}`,
+		"bad cookie name":    target + `sticky: {cookie: "my cookie"}`,
+		"static with sticky": "type: static\n    sticky: {}",
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "carts"
+    path_prefix: "/api/carts"
+    `+extra+`
+`)
+			_, err := loadConfig(path)
+			if err == nil {
+				t.Fatal("expected error for invalid sticky")
+			}
+			if !strings.Contains(err.Error(), "sticky") {
+				t.Errorf("expected a sticky error, got %v", err)
+			}
+		})
+	}
+}
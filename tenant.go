@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/net/http/httpguts"
+)
+
+// defaultTenantHeader carries the tenant upstream when tenant sets no header
+const defaultTenantHeader = "X-Tenant-Id"
+
+// what happens when a client sends a tenant header that differs from its
+// token's tenant
+const (
+	tenantOverwrite = "overwrite"
+	tenantReject    = "reject"
+)
+
+// TenantConfig takes the tenant of a request from a token claim and passes
+// it upstream in a header
+type TenantConfig struct {
+	Claim      string `yaml:"claim"`
+	Header     string `yaml:"header"`
+	OnMismatch string `yaml:"on_mismatch"`
+}
+
+func (c *TenantConfig) header() string {
+	if c.Header == "" {
+		return defaultTenantHeader
+	}
+	return c.Header
+}
+
+func (c *TenantConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Claim == "" || !validFieldPath(c.Claim) {
+		return fmt.Errorf("tenant: invalid claim %q", c.Claim)
+	}
+	if !httpguts.ValidHeaderFieldName(c.header()) {
+		return fmt.Errorf("tenant: invalid header name %q", c.Header)
+	}
+	switch c.OnMismatch {
+	case "", tenantOverwrite, tenantReject:
+		return nil
+	}
+	return fmt.Errorf("tenant: invalid on_mismatch %q, want %s or %s", c.OnMismatch, tenantOverwrite, tenantReject)
+}
+
+// validateTenants checks the service's allowed_tenants, which need a
+// top-level tenant block and credentials to take the tenant from
+func (s ServiceConfig) validateTenants(tenant *TenantConfig) error {
+	if len(s.AllowedTenants) == 0 {
+		return nil
+	}
+	if tenant == nil {
+		return errors.New("allowed_tenants needs a top-level tenant block")
+	}
+	if !s.authenticates() {
+		return errors.New("allowed_tenants needs auth_required or auth_optional")
+	}
+	for _, t := range s.AllowedTenants {
+		if t == "" {
+			return errors.New("allowed_tenants: empty tenant")
+		}
+	}
+	return nil
+}
+
+const sentTenantKey contextKey = "sentTenant"
+
+// takeTenantHeader removes the client's tenant header at the edge, like the
+// X-User-* headers, keeping its value so enforceTenant can compare it to
+// the token
+func takeTenantHeader(header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if sent := r.Header.Get(header); sent != "" {
+				r.Header.Del(header)
+				r = r.WithContext(context.WithValue(r.Context(), sentTenantKey, sent))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// enforceTenant sets the tenant header from the verified token. With
+// allowed, tenants not on the list and requests without a tenant get 403.
+// A client sending a different tenant than its token's is rejected with
+// on_mismatch: reject; otherwise its header was already replaced.
+func enforceTenant(c *TenantConfig, allowed []string) func(http.Handler) http.Handler {
+	header := c.header()
+	allow := make(map[string]bool, len(allowed))
+	for _, t := range allowed {
+		allow[t] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value(userClaimsKey).(jwt.MapClaims)
+			tenant := claimTenant(claims, c.Claim)
+			sent, _ := r.Context().Value(sentTenantKey).(string)
+			if sent != "" && sent != tenant && c.OnMismatch == tenantReject {
+				logger.Warn("tenant header mismatch", "sub", claims["sub"], "tenant", tenant, "sent", sent, "path", r.URL.Path)
+				writeError(w, r, http.StatusForbidden, "Tenant Mismatch")
+				return
+			}
+			if len(allow) > 0 && !allow[tenant] {
+				logger.Warn("tenant not allowed", "sub", claims["sub"], "tenant", tenant, "path", r.URL.Path)
+				writeError(w, r, http.StatusForbidden, "Tenant Not Allowed")
+				return
+			}
+			if tenant != "" {
+				r.Header.Set(header, tenant)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// claimTenant returns the tenant claim as sent in the header, or "" when
+// the token has none
+func claimTenant(claims jwt.MapClaims, claim string) string {
+	v, ok := claimValue(claims, claim)
+	if !ok || v == nil {
+		return ""
+	}
+	tenant, ok := claimHeaderValue(v)
+	if !ok {
+		return ""
+	}
+	return tenant
+}
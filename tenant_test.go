@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestTenant(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	newConfig := func(onMismatch string) *Config {
+		return &Config{
+			JWTSecret: "secret",
+			Tenant:    &TenantConfig{Claim: "org.tenant", OnMismatch: onMismatch},
+			Services: []ServiceConfig{
+				{Name: "billing", PathPrefix: "/api/billing", TargetURL: upstream.URL, AuthRequired: true, AllowedTenants: []string{"acme", "7"}},
+				{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+				{Name: "public", PathPrefix: "/api/public", TargetURL: upstream.URL},
+			},
+		}
+	}
+	token := func(claims jwt.MapClaims) string {
+		return signToken(t, "secret", claims)
+	}
+	acme := token(jwt.MapClaims{"sub": "1", "org": map[string]interface{}{"tenant": "acme"}})
+	globex := token(jwt.MapClaims{"sub": "2", "org": map[string]interface{}{"tenant": "globex"}})
+	numeric := token(jwt.MapClaims{"sub": "3", "org": map[string]interface{}{"tenant": 7}})
+	none := token(jwt.MapClaims{"sub": "4"})
+
+	tests := []struct {
+		name       string
+		onMismatch string
+		path       string
+		token      string
+		sent       string
+		wantCode   int
+		wantTenant string
+	}{
+		{name: "allowed", path: "/api/billing/x", token: acme, wantCode: http.StatusOK, wantTenant: "acme"},
+		{name: "numeric claim", path: "/api/billing/x", token: numeric, wantCode: http.StatusOK, wantTenant: "7"},
+		{name: "other tenant", path: "/api/billing/x", token: globex, wantCode: http.StatusForbidden},
+		{name: "no tenant claim", path: "/api/billing/x", token: none, wantCode: http.StatusForbidden},
+		{name: "no allowlist", path: "/api/orders/x", token: globex, wantCode: http.StatusOK, wantTenant: "globex"},
+		{name: "no claim no header", path: "/api/orders/x", token: none, sent: "acme", wantCode: http.StatusOK},
+		{name: "forged header overwritten", path: "/api/orders/x", token: globex, sent: "acme", wantCode: http.StatusOK, wantTenant: "globex"},
+		{name: "forged header rejected", onMismatch: tenantReject, path: "/api/orders/x", token: globex, sent: "acme", wantCode: http.StatusForbidden},
+		{name: "matching header accepted", onMismatch: tenantReject, path: "/api/orders/x", token: globex, sent: "globex", wantCode: http.StatusOK, wantTenant: "globex"},
+		{name: "stripped without auth", path: "/api/public/x", sent: "acme", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustBuildRouter(t, newConfig(tt.onMismatch))
+			got = nil
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.sent != "" {
+				req.Header.Set("X-Tenant-Id", tt.sent)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.wantCode {
+				t.Fatalf("got %d want %d", rw.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				if got != nil {
+					t.Error("rejected request reached the upstream")
+				}
+				return
+			}
+			if tenant := got.Get("X-Tenant-Id"); tenant != tt.wantTenant {
+				t.Errorf("X-Tenant-Id = %q want %q", tenant, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidTenant(t *testing.T) {
+	tests := map[string]struct{ global, service string }{
+		"no claim":            {global: `{header: "X-Tenant"}`},
+		"bad claim path":      {global: `{claim: "org..tenant"}`},
+		"bad header":          {global: `{claim: "tenant", header: "X Tenant"}`},
+		"bad on_mismatch":     {global: `{claim: "tenant", on_mismatch: "ignore"}`},
+		"allowlist no tenant": {service: "auth_required: true\n    allowed_tenants: [acme]"},
+		"allowlist no auth":   {global: `{claim: "tenant"}`, service: "allowed_tenants: [acme]"},
+		"empty tenant":        {global: `{claim: "tenant"}`, service: "auth_required: true\n    allowed_tenants: [\"\"]"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			global := ""
+			if tt.global != "" {
+				global = "tenant: " + tt.global
+			}
+			path := writeConfig(t, `
+jwt_secret: "secret"
+`+global+`
+services:
+  - name: "billing"
+    path_prefix: "/api/billing"
+    target_url: "http://billing:8080"
+    `+tt.service+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid tenant config")
+			}
+		})
+	}
+}
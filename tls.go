@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// client_auth modes of the TLS listener
+const (
+	clientAuthNone          = "none"
+	clientAuthVerifyIfGiven = "verify_if_given"
+	clientAuthRequire       = "require"
+)
+
+// headers carrying the verified client certificate to upstreams
+const (
+	clientCNHeader  = "X-Client-CN"
+	clientSANHeader = "X-Client-SAN"
+)
+
+// TLSConfig makes server.port serve HTTPS. With http_port set, plain HTTP is
+// served on that address as well, either routed normally or, with
+// redirect_http, redirected to HTTPS. client_ca_file enables client
+// certificates, verified against those CAs as client_auth says.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	HTTPPort     string `yaml:"http_port"`
+	RedirectHTTP bool   `yaml:"redirect_http"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"`
+}
+
+func (c *TLSConfig) validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("tls: cert_file and key_file must both be set")
+	}
+	if c.RedirectHTTP && c.HTTPPort == "" {
+		return errors.New("tls: redirect_http needs http_port")
+	}
+	_, err := c.clientAuth()
+	return err
+}
+
+// clientAuth maps client_auth to the handshake policy. It defaults to
+// verify_if_given with a client_ca_file and to none without.
+func (c *TLSConfig) clientAuth() (tls.ClientAuthType, error) {
+	mode := c.ClientAuth
+	if mode == "" && c.ClientCAFile != "" {
+		mode = clientAuthVerifyIfGiven
+	}
+	switch mode {
+	case "", clientAuthNone:
+		return tls.NoClientCert, nil
+	case clientAuthVerifyIfGiven, clientAuthRequire:
+		if c.ClientCAFile == "" {
+			return 0, fmt.Errorf("tls: client_auth %s needs client_ca_file", mode)
+		}
+		if mode == clientAuthRequire {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.VerifyClientCertIfGiven, nil
+	}
+	return 0, fmt.Errorf("tls: invalid client_auth %q, want %q, %q or %q", c.ClientAuth, clientAuthNone, clientAuthVerifyIfGiven, clientAuthRequire)
+}
+
+// verifiesClientCerts reports whether the listener checks client certificates
+func (c *TLSConfig) verifiesClientCerts() bool {
+	mode, err := c.clientAuth()
+	return err == nil && mode != tls.NoClientCert
+}
+
+// applyClientAuth loads client_ca_file into cfg and sets its client_auth
+func (c *TLSConfig) applyClientAuth(cfg *tls.Config) error {
+	mode, err := c.clientAuth()
+	if err != nil || mode == tls.NoClientCert {
+		return err
+	}
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("tls: reading client_ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("tls: no certificates in client_ca_file %s", c.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = mode
+	return nil
+}
+
+// certReloader serves the most recently loaded certificate so renewed
+// certificates can be picked up without restarting the listener
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+// newCertReloader loads the certificate up front so a bad cert or key fails
+// startup instead of the first handshake
+func newCertReloader(c *TLSConfig) (*certReloader, error) {
+	r := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload re-reads the key pair. On error the previous certificate stays in use.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: loading certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// httpsRedirect permanently redirects every request to the same URL on the
+// HTTPS listener at httpsAddr
+func httpsRedirect(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// validateClientCert checks that require_client_cert has a listener that
+// verifies client certificates
+func (s ServiceConfig) validateClientCert(server ServerConfig) error {
+	if s.RequireClientCert && (server.TLS == nil || !server.TLS.verifiesClientCerts()) {
+		return errors.New("require_client_cert needs server.tls with client_ca_file")
+	}
+	return nil
+}
+
+// requireClientCert rejects requests without a verified client certificate
+// and passes the certificate's subject to upstreams in X-Client-CN and
+// X-Client-SAN. Clients can't set those themselves: they are stripped at the
+// edge with the other identity headers.
+func requireClientCert(service string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				logger.Warn("client certificate missing", "service", service, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				writeError(w, r, http.StatusForbidden, "client certificate required")
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			r.Header.Set(clientCNHeader, cert.Subject.CommonName)
+			if san := certSANs(cert); san != "" {
+				r.Header.Set(clientSANHeader, san)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// certSANs lists the subject alternative names of cert, comma separated, as
+// DNS:, email:, IP: and URI: entries
+func certSANs(cert *x509.Certificate) string {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	return strings.Join(sans, ",")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for localhost and returns the
+// cert and key file paths
+func writeCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	certs, err := newCertReloader(&TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest.Server would add its own certificate, bypassing GetCertificate
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", certs.tlsConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	served := func() []byte {
+		resp, err := client.Get("https://" + ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		client.CloseIdleConnections()
+		return resp.TLS.PeerCertificates[0].Raw
+	}
+	first := served()
+
+	// a renewal replaces both files in place
+	renewedCert, renewedKey := writeCert(t)
+	for src, dst := range map[string]string{renewedCert: certFile, renewedKey: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := certs.reload(); err != nil {
+		t.Fatal(err)
+	}
+	renewed := served()
+	if bytes.Equal(first, renewed) {
+		t.Fatal("expected the renewed certificate after reload")
+	}
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.reload(); err == nil {
+		t.Fatal("expected reload of a broken key to fail")
+	}
+	if !bytes.Equal(served(), renewed) {
+		t.Fatal("failed reload replaced the certificate")
+	}
+
+	if _, err := newCertReloader(&TLSConfig{CertFile: certFile, KeyFile: certFile}); err == nil {
+		t.Fatal("expected error for a key file without a key")
+	}
+}
+
+func TestLoadConfigTLS(t *testing.T) {
+	tests := map[string]string{
+		"missing key": `
+server:
+  tls:
+    cert_file: "cert.pem"
+services: []
+`,
+		"redirect without http port": `
+server:
+  tls:
+    cert_file: "cert.pem"
+    key_file: "key.pem"
+    redirect_http: true
+services: []
+`,
+		"client auth without ca": `
+server:
+  tls:
+    cert_file: "cert.pem"
+    key_file: "key.pem"
+    client_auth: "require"
+services: []
+`,
+		"unknown client auth": `
+server:
+  tls:
+    cert_file: "cert.pem"
+    key_file: "key.pem"
+    client_ca_file: "ca.pem"
+    client_auth: "optional"
+services: []
+`,
+		"client cert without ca": `
+server:
+  tls:
+    cert_file: "cert.pem"
+    key_file: "key.pem"
+services:
+  - name: "partners"
+    path_prefix: "/api/partners"
+    target_url: "http://partners:8080"
+    require_client_cert: true
+`,
+	}
+	for name, body := range tests {
+		if _, err := loadConfig(writeConfig(t, body)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		httpsAddr, host, want string
+	}{
+		{":443", "example.com", "https://example.com/a/b?c=d"},
+		{":8443", "example.com:8080", "https://example.com:8443/a/b?c=d"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/a/b?c=d", nil)
+		rw := httptest.NewRecorder()
+		httpsRedirect(tt.httpsAddr).ServeHTTP(rw, req)
+		if rw.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: got status %d want %d", tt.httpsAddr, rw.Code, http.StatusPermanentRedirect)
+		}
+		if got := rw.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: got location %q want %q", tt.httpsAddr, got, tt.want)
+		}
+	}
+}
+
+// issueClientCert creates a CA, written to a file for client_ca_file, and a
+// client certificate it signed for the given common name
+func issueClientCert(t *testing.T, cn string) (string, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "partners CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://partners/acme")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"acme.example"},
+		URIs:         []*url.URL{spiffe},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	var gotCN, gotSAN string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCN, gotSAN = r.Header.Get("X-Client-CN"), r.Header.Get("X-Client-SAN")
+	}))
+	defer upstream.Close()
+
+	certFile, keyFile := writeCert(t)
+	caFile, clientCert := issueClientCert(t, "acme")
+	_, strangerCert := issueClientCert(t, "acme")
+	tlsCfg := &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+	cfg := &Config{
+		Server: ServerConfig{TLS: tlsCfg},
+		Services: []ServiceConfig{
+			{Name: "partners", PathPrefix: "/api/partners", TargetURL: upstream.URL, RequireClientCert: true},
+			{Name: "public", PathPrefix: "/api/public", TargetURL: upstream.URL},
+		},
+	}
+	certs, err := newCertReloader(tlsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTLS := certs.tlsConfig()
+	if err := tlsCfg.applyClientAuth(serverTLS); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mustBuildRouter(t, cfg)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	get := func(path string, cert *tls.Certificate) (int, error) {
+		clientTLS := &tls.Config{InsecureSkipVerify: true}
+		if cert != nil {
+			clientTLS.Certificates = []tls.Certificate{*cert}
+		}
+		req, _ := http.NewRequest("GET", "https://"+ln.Addr().String()+path, nil)
+		req.Header.Set("X-Client-CN", "forged")
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}).Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := get("/api/partners/x", &clientCert); err != nil || code != http.StatusOK {
+		t.Fatalf("with a client cert: got %d, %v", code, err)
+	}
+	if gotCN != "acme" || gotSAN != "DNS:acme.example,URI:spiffe://partners/acme" {
+		t.Errorf("upstream got X-Client-CN %q, X-Client-SAN %q", gotCN, gotSAN)
+	}
+	if code, err := get("/api/partners/x", nil); err != nil || code != http.StatusForbidden {
+		t.Fatalf("without a client cert: got %d, %v", code, err)
+	}
+	if code, err := get("/api/public/x", nil); err != nil || code != http.StatusOK {
+		t.Fatalf("other routes without a client cert: got %d, %v", code, err)
+	}
+	if gotCN != "" {
+		t.Errorf("expected the forged X-Client-CN to be stripped, upstream got %q", gotCN)
+	}
+	// a certificate from another CA fails the handshake
+	if _, err := get("/api/public/x", &strangerCert); err == nil {
+		t.Fatal("expected a certificate from an unknown CA to be refused")
+	}
+}
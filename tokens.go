@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	tokenSourceHeader  = "header"
+	cookieSourcePrefix = "cookie:"
+	querySourcePrefix  = "query:"
+)
+
+// tokenSource is a place authMiddleware looks for a bearer token: the
+// Authorization header, the cookie named cookie or the query parameter
+// named query
+type tokenSource struct {
+	cookie string
+	query  string
+}
+
+// tokenSources parses token_sources; unset means the Authorization header only
+func (c *Config) tokenSources() ([]tokenSource, error) {
+	if len(c.TokenSources) == 0 {
+		return []tokenSource{{}}, nil
+	}
+	sources := make([]tokenSource, 0, len(c.TokenSources))
+	for _, raw := range c.TokenSources {
+		switch {
+		case raw == tokenSourceHeader:
+			sources = append(sources, tokenSource{})
+		case strings.HasPrefix(raw, cookieSourcePrefix):
+			name := strings.TrimPrefix(raw, cookieSourcePrefix)
+			if name == "" || strings.ContainsAny(name, " \t\r\n;,=\"") {
+				return nil, fmt.Errorf("token_sources: invalid cookie name in %q", raw)
+			}
+			sources = append(sources, tokenSource{cookie: name})
+		case strings.HasPrefix(raw, querySourcePrefix):
+			name := strings.TrimPrefix(raw, querySourcePrefix)
+			if name == "" || strings.ContainsAny(name, " \t\r\n&=#") {
+				return nil, fmt.Errorf("token_sources: invalid query parameter in %q", raw)
+			}
+			sources = append(sources, tokenSource{query: name})
+		default:
+			return nil, fmt.Errorf("token_sources: %q must be %q, %q<name> or %q<name>", raw, tokenSourceHeader, cookieSourcePrefix, querySourcePrefix)
+		}
+	}
+	return sources, nil
+}
+
+var errBadAuthorizationHeader = errors.New("authorization header is not a bearer token")
+
+// findToken returns the token of the first source that has one, and the
+// source it came from. A malformed Authorization header is an error rather
+// than a reason to try the next source. Empty means no source had a token.
+func findToken(sources []tokenSource, r *http.Request) (string, tokenSource, error) {
+	for _, src := range sources {
+		switch {
+		case src.cookie != "":
+			if c, err := r.Cookie(src.cookie); err == nil && c.Value != "" {
+				return c.Value, src, nil
+			}
+			continue
+		case src.query != "":
+			if tok := r.URL.Query().Get(src.query); tok != "" {
+				return tok, src, nil
+			}
+			continue
+		}
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			continue
+		}
+		tok, found := strings.CutPrefix(auth, "Bearer ")
+		if !found {
+			return "", src, errBadAuthorizationHeader
+		}
+		return tok, src, nil
+	}
+	return "", tokenSource{}, nil
+}
+
+// missingTokenMessage is the error for requests without a token
+func missingTokenMessage(sources []tokenSource) string {
+	if len(sources) == 1 && sources[0] == (tokenSource{}) {
+		return "Missing Authorization Header"
+	}
+	return "Missing Token"
+}
+
+// hasToken reports whether any source carries something to verify
+func hasToken(sources []tokenSource, r *http.Request) bool {
+	tok, _, err := findToken(sources, r)
+	return tok != "" || err != nil
+}
+
+type tokenSourceKey struct{}
+
+// requestTokenSource returns where authMiddleware found the request's
+// verified token; ok is false when it verified none
+func requestTokenSource(r *http.Request) (src tokenSource, ok bool) {
+	src, ok = r.Context().Value(tokenSourceKey{}).(tokenSource)
+	return src, ok
+}
+
+// removeCookie drops the cookie called name from the request's Cookie header
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != name {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// withoutQueryParam returns a copy of u without the query parameter name
+func withoutQueryParam(u *url.URL, name string) *url.URL {
+	q := u.Query()
+	q.Del(name)
+	out := *u
+	out.RawQuery = q.Encode()
+	return &out
+}
+
+// Tokens in URLs leak into logs and browser history and are easy to replay,
+// so clients failing to verify them are slowed down: each client address
+// gets queryTokenFailureBurst failures, refilled at queryTokenFailureRate.
+const (
+	queryTokenFailureRate  = 10.0 / 60
+	queryTokenFailureBurst = 10
+)
+
+func newQueryTokenFailureLimiter() *rateLimiter {
+	return newRateLimiter(RateLimitConfig{RequestsPerSecond: queryTokenFailureRate, Burst: queryTokenFailureBurst})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestTokenFromCookie(t *testing.T) {
+	var gotUser, gotCookie string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotCookie = r.Header.Get("X-User-Id"), r.Header.Get("Cookie")
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret:    "secret",
+		TokenSources: []string{"header", "cookie:access_token"},
+		Services: []ServiceConfig{
+			{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL, AuthRequired: true},
+			{Name: "billing", PathPrefix: "/api/billing", TargetURL: upstream.URL, AuthRequired: true, StripAuthorization: true},
+			{Name: "catalog", PathPrefix: "/api/catalog", TargetURL: upstream.URL, AuthOptional: true},
+		},
+	}
+	r := mustBuildRouter(t, cfg)
+	alice := signToken(t, "secret", jwt.MapClaims{"sub": "alice"})
+	bob := signToken(t, "secret", jwt.MapClaims{"sub": "bob"})
+
+	tests := []struct {
+		name, path, header, cookie string
+		want                       int
+		wantUser, wantCookie       string
+	}{
+		{name: "cookie", path: "/api/orders/1", cookie: alice, want: http.StatusOK, wantUser: "alice", wantCookie: "theme=dark; access_token=" + alice},
+		{name: "header first", path: "/api/orders/1", header: "Bearer " + bob, cookie: alice, want: http.StatusOK, wantUser: "bob"},
+		{name: "bad cookie", path: "/api/orders/1", cookie: "garbage", want: http.StatusUnauthorized},
+		{name: "none", path: "/api/orders/1", want: http.StatusUnauthorized},
+		{name: "stripped", path: "/api/billing/1", cookie: alice, want: http.StatusOK, wantUser: "alice", wantCookie: "theme=dark"},
+		{name: "optional anonymous", path: "/api/catalog/1", want: http.StatusOK},
+		{name: "optional cookie", path: "/api/catalog/1", cookie: alice, want: http.StatusOK, wantUser: "alice", wantCookie: "theme=dark; access_token=" + alice},
+		{name: "optional bad cookie", path: "/api/catalog/1", cookie: "garbage", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser, gotCookie = "", ""
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: tt.cookie})
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("X-User-Id = %q want %q", gotUser, tt.wantUser)
+			}
+			if tt.wantCookie != "" && gotCookie != tt.wantCookie {
+				t.Errorf("Cookie = %q want %q", gotCookie, tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestTokenFromQuery(t *testing.T) {
+	var gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+	}))
+	defer upstream.Close()
+
+	services := []ServiceConfig{{Name: "events", PathPrefix: "/api/events", TargetURL: upstream.URL, AuthRequired: true}}
+	token := signToken(t, "secret", jwt.MapClaims{"sub": "alice"})
+
+	// off by default
+	r := mustBuildRouter(t, &Config{JWTSecret: "secret", Services: services})
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/events/stream?access_token="+token, nil))
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a query source, got %d", rw.Code)
+	}
+
+	r = mustBuildRouter(t, &Config{JWTSecret: "secret", TokenSources: []string{"header", "query:access_token"}, Services: services})
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/events/stream?topic=orders&access_token="+token, nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d", rw.Code)
+	}
+	if gotQuery != "topic=orders" {
+		t.Errorf("upstream got query %q", gotQuery)
+	}
+
+	// failed query tokens are limited per client, header tokens are not
+	bad := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw.Code
+	}
+	for i := 0; i < queryTokenFailureBurst; i++ {
+		if code := bad("/api/events/stream?access_token=garbage"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, code)
+		}
+	}
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/events/stream?access_token="+token, nil))
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After after repeated failures, got %d", rw.Code)
+	}
+	req := httptest.NewRequest("GET", "/api/events/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected header token to pass, got %d", rw.Code)
+	}
+}
+
+func TestLoadConfigInvalidTokenSources(t *testing.T) {
+	for _, sources := range []string{`["body:token"]`, `["cookie:"]`, `["cookie:a b"]`, `["query:"]`} {
+		path := writeConfig(t, `
+jwt_secret: "secret"
+token_sources: `+sources+`
+services: []
+`)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%s: expected error", sources)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName                = "github.com/CSO2/api-gateway"
+	defaultTracingServiceName = "api-gateway"
+)
+
+// TracingConfig exports spans over OTLP/HTTP to endpoint (host:port). Without
+// it, or with enabled: false, spans are not recorded and no trace headers are
+// sent upstream.
+type TracingConfig struct {
+	Enabled     *bool    `yaml:"enabled"`
+	Endpoint    string   `yaml:"endpoint"`
+	Insecure    bool     `yaml:"insecure"`
+	ServiceName string   `yaml:"service_name"`
+	SampleRatio *float64 `yaml:"sample_ratio"`
+	Propagators []string `yaml:"propagators"`
+}
+
+// trace context formats read from clients and written to upstreams
+const (
+	propagatorTraceContext = "tracecontext"
+	propagatorB3           = "b3"
+)
+
+func (c *TracingConfig) enabled() bool {
+	return c != nil && (c.Enabled == nil || *c.Enabled)
+}
+
+func (c *TracingConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.Endpoint == "" {
+		return fmt.Errorf("tracing: endpoint must be set")
+	}
+	if r := c.SampleRatio; r != nil && (*r < 0 || *r > 1) {
+		return fmt.Errorf("tracing: sample_ratio must be between 0 and 1, got %v", *r)
+	}
+	_, err := c.propagator()
+	return err
+}
+
+// sampler samples root spans at sample_ratio, default all, and follows the
+// sampling decision of an incoming trace context
+func (c *TracingConfig) sampler() sdktrace.Sampler {
+	ratio := 1.0
+	if c.SampleRatio != nil {
+		ratio = *c.SampleRatio
+	}
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))
+}
+
+// propagator combines the configured formats, W3C trace context by default
+func (c *TracingConfig) propagator() (propagation.TextMapPropagator, error) {
+	names := c.Propagators
+	if len(names) == 0 {
+		names = []string{propagatorTraceContext}
+	}
+	var props []propagation.TextMapPropagator
+	for _, name := range names {
+		switch name {
+		case propagatorTraceContext:
+			props = append(props, propagation.TraceContext{})
+		case propagatorB3:
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			return nil, fmt.Errorf("tracing: unknown propagator %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(props...), nil
+}
+
+// setupTracing installs the global tracer provider and propagators. The returned function flushes pending spans on shutdown.
+func setupTracing(ctx context.Context, c *TracingConfig) (func(context.Context) error, error) {
+	if !c.enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: creating exporter: %w", err)
+	}
+	name := c.ServiceName
+	if name == "" {
+		name = defaultTracingServiceName
+	}
+	prop, err := c.propagator()
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(c.sampler()),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(prop)
+	return tp.Shutdown, nil
+}
+
+// traceRequests starts a server span per request to the service, continuing
+// any trace context the client sent
+func traceRequests(s ServiceConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method+" "+s.PathPrefix,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					attribute.String("gateway.service", s.Name),
+					attribute.String("gateway.prefix", s.PathPrefix),
+					attribute.String("gateway.request_id", middleware.GetReqID(r.Context())),
+				),
+			)
+			defer span.End()
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
+
+// tracingTransport wraps the whole upstream call, retries included, in a
+// client span and hands its context to the upstream in the request headers
+type tracingTransport struct {
+	base    http.RoundTripper
+	service string
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(tracerName).Start(req.Context(), "proxy "+t.service,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Host),
+			semconv.URLPath(req.URL.Path),
+		),
+	)
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	// an upgraded connection outlives the call; its body must stay writable
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		span.End()
+		return resp, nil
+	}
+	// the call lasts until the body has been read
+	resp.Body = &endSpanOnClose{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+type endSpanOnClose struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+func (b *endSpanOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
+
+// traceLogHandler adds the trace id of the active span to log records, for
+// log calls that pass a context
+type traceLogHandler struct {
+	slog.Handler
+}
+
+func (h traceLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceLogHandler) WithGroup(name string) slog.Handler {
+	return traceLogHandler{h.Handler.WithGroup(name)}
+}
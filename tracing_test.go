@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+	return rec
+}
+
+func TestTracingPropagatesToUpstream(t *testing.T) {
+	rec := recordSpans(t)
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services:  []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: upstream.URL}},
+	}
+	r := mustBuildRouter(t, cfg)
+
+	const clientTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/api/orders/x", nil)
+	req.Header.Set("Traceparent", "00-"+clientTrace+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected a server and a client span, got %d", len(spans))
+	}
+	var server, client sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		switch span.SpanKind() {
+		case trace.SpanKindServer:
+			server = span
+		case trace.SpanKindClient:
+			client = span
+		}
+	}
+	if server == nil || client == nil {
+		t.Fatal("missing server or client span")
+	}
+	if got := server.SpanContext().TraceID().String(); got != clientTrace {
+		t.Fatalf("span did not continue the client trace: got %s", got)
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("client span is not a child of the server span")
+	}
+	if !strings.Contains(traceparent, clientTrace) || !strings.Contains(traceparent, client.SpanContext().SpanID().String()) {
+		t.Fatalf("upstream traceparent %q does not reference the client span", traceparent)
+	}
+	attrs := map[string]string{}
+	for _, kv := range server.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["gateway.service"] != "orders" || attrs["gateway.prefix"] != "/api/orders" || attrs["http.response.status_code"] != "201" {
+		t.Fatalf("unexpected server span attributes %v", attrs)
+	}
+	if attrs["gateway.request_id"] == "" {
+		t.Fatal("span lacks the request id")
+	}
+	var upstreamStatus int64
+	for _, kv := range client.Attributes() {
+		if kv.Key == "http.response.status_code" {
+			upstreamStatus = kv.Value.AsInt64()
+		}
+	}
+	if upstreamStatus != http.StatusCreated {
+		t.Fatalf("client span recorded upstream status %d, want %d", upstreamStatus, http.StatusCreated)
+	}
+}
+
+func TestTracingConfig(t *testing.T) {
+	half, tooMuch := 0.5, 1.5
+	valid := &TracingConfig{Endpoint: "collector:4318", SampleRatio: &half, Propagators: []string{"tracecontext", "b3"}}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	prop, _ := valid.propagator()
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	}))
+	h := http.Header{}
+	prop.Inject(ctx, propagation.HeaderCarrier(h))
+	if h.Get("Traceparent") == "" || h.Get("X-B3-Traceid") == "" {
+		t.Fatalf("expected W3C and B3 headers, got %v", h)
+	}
+
+	for _, c := range []*TracingConfig{
+		{},
+		{Endpoint: "collector:4318", SampleRatio: &tooMuch},
+		{Endpoint: "collector:4318", Propagators: []string{"jaeger"}},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestTraceLogHandler(t *testing.T) {
+	recordSpans(t)
+
+	var buf bytes.Buffer
+	l := slog.New(traceLogHandler{slog.NewJSONHandler(&buf, nil)})
+	ctx, span := otel.Tracer(tracerName).Start(context.Background(), "test")
+	l.InfoContext(ctx, "with span")
+	span.End()
+
+	if !strings.Contains(buf.String(), `"trace_id":"`+span.SpanContext().TraceID().String()+`"`) {
+		t.Fatalf("log line lacks trace id: %s", buf.String())
+	}
+	buf.Reset()
+	l.Info("without span")
+	if strings.Contains(buf.String(), "trace_id") {
+		t.Fatalf("unexpected trace id: %s", buf.String())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// trailing_slash policies for paths such as /api/users/
+const (
+	trailingSlashStrict   = "strict"
+	trailingSlashRedirect = "redirect"
+	trailingSlashStrip    = "strip"
+)
+
+func validateTrailingSlash(policy string) error {
+	switch policy {
+	case "", trailingSlashStrict, trailingSlashRedirect, trailingSlashStrip:
+		return nil
+	}
+	return fmt.Errorf("invalid trailing_slash %q, want %q, %q or %q", policy, trailingSlashStrict, trailingSlashRedirect, trailingSlashStrip)
+}
+
+// trailingSlash applies the trailing_slash policy before routing. strict, the
+// default, leaves paths alone; redirect sends clients to the path without
+// trailing slashes; strip removes them so upstreams see the canonical path.
+func trailingSlash(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy == "" || policy == trailingSlashStrict {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := r.URL.Path
+			if len(p) <= 1 || !strings.HasSuffix(p, "/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			u := *r.URL
+			// a single leading slash keeps //host/ from becoming an absolute URL
+			u.Path = "/" + strings.Trim(p, "/")
+			if u.RawPath != "" {
+				u.RawPath = "/" + strings.Trim(u.RawPath, "/")
+			}
+			if policy == trailingSlashRedirect {
+				// 301 lets clients turn a POST into a GET; 308 keeps the method
+				status := http.StatusMovedPermanently
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					status = http.StatusPermanentRedirect
+				}
+				http.Redirect(w, r, u.RequestURI(), status)
+				return
+			}
+			r2 := *r
+			r2.URL = &u
+			next.ServeHTTP(w, &r2)
+		})
+	}
+}
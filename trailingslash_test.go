@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	var gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.RequestURI()
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		policy, method, path string
+		want                 int
+		wantLocation         string
+		wantUpstream         string
+	}{
+		{policy: "", method: "GET", path: "/api/users/42/", want: http.StatusOK, wantUpstream: "/42/"},
+		{policy: "strict", method: "GET", path: "/api/users/42", want: http.StatusOK, wantUpstream: "/42"},
+		{policy: "strict", method: "GET", path: "/api/users/42/", want: http.StatusOK, wantUpstream: "/42/"},
+		{policy: "redirect", method: "GET", path: "/api/users/42", want: http.StatusOK, wantUpstream: "/42"},
+		{policy: "redirect", method: "GET", path: "/api/users/42/?x=1", want: http.StatusMovedPermanently, wantLocation: "/api/users/42?x=1"},
+		{policy: "redirect", method: "POST", path: "/api/users/42/", want: http.StatusPermanentRedirect, wantLocation: "/api/users/42"},
+		{policy: "redirect", method: "GET", path: "//evil.example/", want: http.StatusMovedPermanently, wantLocation: "/evil.example"},
+		{policy: "strip", method: "GET", path: "/api/users/42", want: http.StatusOK, wantUpstream: "/42"},
+		{policy: "strip", method: "GET", path: "/api/users/42/?x=1", want: http.StatusOK, wantUpstream: "/42?x=1"},
+		{policy: "strip", method: "GET", path: "/api/users/", want: http.StatusOK, wantUpstream: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			cfg := &Config{
+				Server:   ServerConfig{TrailingSlash: tt.policy},
+				Services: []ServiceConfig{{Name: "users", PathPrefix: "/api/users", TargetURL: upstream.URL, StripPrefix: "/api/users"}},
+			}
+			r := mustBuildRouter(t, cfg)
+			gotPath = ""
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+			if loc := rw.Header().Get("Location"); loc != tt.wantLocation {
+				t.Errorf("Location = %q want %q", loc, tt.wantLocation)
+			}
+			if gotPath != tt.wantUpstream {
+				t.Errorf("upstream got %q want %q", gotPath, tt.wantUpstream)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidTrailingSlash(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  trailing_slash: "append"
+services: []
+`)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for invalid trailing_slash")
+	}
+}
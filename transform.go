@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultTransformMaxBody caps the bodies transform buffers when
+// max_body_size is unset; larger bodies pass through unchanged
+const defaultTransformMaxBody = 1 << 20
+
+// TransformConfig rewrites JSON request and response bodies, e.g. to adapt a
+// legacy upstream's field names
+type TransformConfig struct {
+	Request     *BodyTransformConfig `yaml:"request"`
+	Response    *BodyTransformConfig `yaml:"response"`
+	MaxBodySize string               `yaml:"max_body_size"`
+}
+
+// BodyTransformConfig renames fields, given as dot separated paths such as
+// "user.fullName", from the key to the value path
+type BodyTransformConfig struct {
+	Rename map[string]string `yaml:"rename"`
+}
+
+func (c *TransformConfig) maxBodySize() (int64, error) {
+	if c.MaxBodySize == "" {
+		return defaultTransformMaxBody, nil
+	}
+	n, err := parseByteSize(c.MaxBodySize)
+	if err != nil {
+		return 0, fmt.Errorf("transform: max_body_size: %w", err)
+	}
+	return n, nil
+}
+
+func (c *TransformConfig) validate() error {
+	if c.Request == nil && c.Response == nil {
+		return errors.New("transform: set request or response")
+	}
+	for name, t := range map[string]*BodyTransformConfig{"request": c.Request, "response": c.Response} {
+		if t == nil {
+			continue
+		}
+		if len(t.Rename) == 0 {
+			return fmt.Errorf("transform: %s.rename must not be empty", name)
+		}
+		for from, to := range t.Rename {
+			if !validFieldPath(from) || !validFieldPath(to) {
+				return fmt.Errorf("transform: %s.rename: invalid field path in %q: %q", name, from, to)
+			}
+		}
+	}
+	_, err := c.maxBodySize()
+	return err
+}
+
+func validFieldPath(p string) bool {
+	for _, part := range strings.Split(p, ".") {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+type fieldRename struct {
+	from, to []string
+}
+
+// bodyTransform holds the renames of one direction, applied in the sorted
+// order of their source paths so overlapping renames behave the same on
+// every request
+type bodyTransform []fieldRename
+
+func newBodyTransform(c *BodyTransformConfig) bodyTransform {
+	if c == nil {
+		return nil
+	}
+	froms := make([]string, 0, len(c.Rename))
+	for from := range c.Rename {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+	t := make(bodyTransform, 0, len(froms))
+	for _, from := range froms {
+		t = append(t, fieldRename{from: strings.Split(from, "."), to: strings.Split(c.Rename[from], ".")})
+	}
+	return t
+}
+
+// transformer applies a service's transform to proxied bodies
+type transformer struct {
+	request  bodyTransform
+	response bodyTransform
+	maxBody  int64
+}
+
+func newTransformer(c *TransformConfig) (*transformer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	maxBody, _ := c.maxBodySize()
+	return &transformer{request: newBodyTransform(c.Request), response: newBodyTransform(c.Response), maxBody: maxBody}, nil
+}
+
+// transformRequest rewrites the body of req, which the proxy is about to send
+func (t *transformer) transformRequest(req *http.Request) {
+	if len(t.request) == 0 || req.Body == nil || req.Body == http.NoBody || !transformable(req.Header) {
+		return
+	}
+	body, ok := t.rewrite(req.Body, t.request)
+	req.Body = body
+	if !ok {
+		return
+	}
+	n := body.(*transformedBody).Len()
+	req.ContentLength = int64(n)
+	req.Header.Set("Content-Length", strconv.Itoa(n))
+	req.GetBody = nil
+}
+
+// transformResponse rewrites the body of resp before it reaches the client
+func (t *transformer) transformResponse(resp *http.Response) {
+	if len(t.response) == 0 || resp.Body == nil || resp.Body == http.NoBody || !transformable(resp.Header) {
+		return
+	}
+	body, ok := t.rewrite(resp.Body, t.response)
+	resp.Body = body
+	if !ok {
+		return
+	}
+	n := body.(*transformedBody).Len()
+	resp.ContentLength = int64(n)
+	resp.Header.Set("Content-Length", strconv.Itoa(n))
+}
+
+// transformable reports whether a body with headers h is uncompressed JSON
+func transformable(h http.Header) bool {
+	if enc := h.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// transformedBody is a rewritten body held in memory
+type transformedBody struct {
+	*bytes.Reader
+}
+
+func (transformedBody) Close() error { return nil }
+
+// rewrite reads body and applies renames. It reports false, returning a body
+// that streams the original bytes, when body is larger than t.maxBody, can't
+// be read or isn't valid JSON.
+func (t *transformer) rewrite(body io.ReadCloser, renames bodyTransform) (io.ReadCloser, bool) {
+	buf, err := io.ReadAll(io.LimitReader(body, t.maxBody+1))
+	if err != nil || int64(len(buf)) > t.maxBody {
+		// read errors, such as a body over max_body_size, surface to the proxy
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), body), body}, false
+	}
+	body.Close()
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return io.NopCloser(bytes.NewReader(buf)), false
+	}
+	if items, ok := doc.([]interface{}); ok {
+		for _, item := range items {
+			renames.apply(item)
+		}
+	} else {
+		renames.apply(doc)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return io.NopCloser(bytes.NewReader(buf)), false
+	}
+	return &transformedBody{bytes.NewReader(bytes.TrimSuffix(out.Bytes(), []byte("\n")))}, true
+}
+
+func (t bodyTransform) apply(doc interface{}) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, rn := range t {
+		parent, ok := fieldParent(obj, rn.from, false)
+		if !ok {
+			continue
+		}
+		key := rn.from[len(rn.from)-1]
+		v, ok := parent[key]
+		if !ok {
+			continue
+		}
+		dst, ok := fieldParent(obj, rn.to, true)
+		if !ok {
+			// the target path runs through a non-object; leave the field be
+			continue
+		}
+		delete(parent, key)
+		dst[rn.to[len(rn.to)-1]] = v
+	}
+}
+
+// fieldParent returns the object holding the last element of path, creating
+// missing objects on the way when create is set
+func fieldParent(obj map[string]interface{}, path []string, create bool) (map[string]interface{}, bool) {
+	for _, part := range path[:len(path)-1] {
+		next, ok := obj[part]
+		if !ok && create {
+			child := make(map[string]interface{})
+			obj[part] = child
+			obj = child
+			continue
+		}
+		if obj, ok = next.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return obj, true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	var gotBody, gotLength string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody, gotLength = string(body), r.Header.Get("Content-Length")
+		switch r.URL.Path {
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(`{"user_name": "alice"}`))
+		case "/list":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"user_name": "alice"}, {"user_name": "bob"}, 7]`))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"user_name": "alice", "meta": {"created": 1700000000000000001}, "note": "<b>"}`))
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{
+		Services: []ServiceConfig{{
+			Name: "legacy", PathPrefix: "/api/legacy", TargetURL: upstream.URL, StripPrefix: "/api/legacy",
+			Transform: &TransformConfig{
+				Request:     &BodyTransformConfig{Rename: map[string]string{"userName": "user_name", "address.zip": "postcode"}},
+				Response:    &BodyTransformConfig{Rename: map[string]string{"user_name": "userName", "meta.created": "createdAt"}},
+				MaxBodySize: "100",
+			},
+		}},
+	}
+	r := mustBuildRouter(t, cfg)
+	send := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := send("/api/legacy/users", "application/json", `{"userName": "alice", "address": {"zip": "12345", "city": "Berlin"}}`)
+	if want := `{"address":{"city":"Berlin"},"postcode":"12345","user_name":"alice"}`; gotBody != want {
+		t.Errorf("upstream got %s, want %s", gotBody, want)
+	}
+	if gotLength != strconv.Itoa(len(gotBody)) {
+		t.Errorf("upstream got Content-Length %s for %d bytes", gotLength, len(gotBody))
+	}
+	// large numbers keep their precision and HTML characters stay unescaped
+	if want := `{"createdAt":1700000000000000001,"meta":{},"note":"<b>","userName":"alice"}`; rw.Body.String() != want {
+		t.Errorf("client got %s, want %s", rw.Body, want)
+	}
+	if rw.Header().Get("Content-Length") != strconv.Itoa(rw.Body.Len()) {
+		t.Errorf("client got Content-Length %s for %d bytes", rw.Header().Get("Content-Length"), rw.Body.Len())
+	}
+
+	rw = send("/api/legacy/list", "application/json", `[]`)
+	if want := `[{"userName":"alice"},{"userName":"bob"},7]`; rw.Body.String() != want {
+		t.Errorf("client got %s, want %s", rw.Body, want)
+	}
+
+	// non-JSON and oversized bodies pass through untouched
+	form := `userName=alice`
+	rw = send("/api/legacy/text", "application/x-www-form-urlencoded", form)
+	if gotBody != form {
+		t.Errorf("upstream got %s, want the form unchanged", gotBody)
+	}
+	if want := `{"user_name": "alice"}`; rw.Body.String() != want {
+		t.Errorf("client got %s, want %s", rw.Body, want)
+	}
+	large := `{"userName": "` + strings.Repeat("a", 200) + `"}`
+	send("/api/legacy/users", "application/json", large)
+	if gotBody != large {
+		t.Errorf("expected a body over max_body_size to pass through, upstream got %d bytes", len(gotBody))
+	}
+}
+
+func TestLoadConfigInvalidTransform(t *testing.T) {
+	tests := map[string]string{
+		"empty":      `{}`,
+		"no renames": `{request: {rename: {}}}`,
+		"bad path":   `{response: {rename: {"user..name": "userName"}}}`,
+		"bad size":   `{response: {rename: {"a": "b"}}, max_body_size: "lots"}`,
+	}
+	for name, transform := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "legacy"
+    path_prefix: "/api/legacy"
+    target_url: "http://legacy:8080"
+    transform: `+transform+`
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error for invalid transform")
+			}
+		})
+	}
+}
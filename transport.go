@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Connection pool defaults for server.transport. The per-host limit is well
+// above net/http's 2, which makes a busy gateway open and close connections
+// to each upstream constantly.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// TransportConfig tunes the connection pool and TLS settings shared by all
+// proxies
+type TransportConfig struct {
+	MaxIdleConns        int    `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int    `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout     string `yaml:"idle_conn_timeout"`
+	DisableKeepAlives   bool   `yaml:"disable_keep_alives"`
+	InsecureSkipVerify  bool   `yaml:"insecure_skip_verify"`
+	CAFile              string `yaml:"ca_file"`
+}
+
+func (c *TransportConfig) idleConnTimeout() (time.Duration, error) {
+	if c == nil || c.IdleConnTimeout == "" {
+		return defaultIdleConnTimeout, nil
+	}
+	d, err := time.ParseDuration(c.IdleConnTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("transport: invalid idle_conn_timeout %q", c.IdleConnTimeout)
+	}
+	return d, nil
+}
+
+func (c *TransportConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 {
+		return errors.New("transport: max_idle_conns and max_idle_conns_per_host must not be negative")
+	}
+	if _, err := c.idleConnTimeout(); err != nil {
+		return err
+	}
+	_, err := c.tlsConfig()
+	return err
+}
+
+// tlsConfig returns the TLS settings for upstream connections, or nil for
+// the defaults
+func (c *TransportConfig) tlsConfig() (*tls.Config, error) {
+	if c == nil || !c.InsecureSkipVerify && c.CAFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("transport: ca_file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("transport: ca_file %s contains no certificates", c.CAFile)
+		}
+	}
+	return cfg, nil
+}
+
+// newUpstreamTransport builds the transport shared by every proxy of a router
+func newUpstreamTransport(c *TransportConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = defaultMaxIdleConns
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	var err error
+	if t.IdleConnTimeout, err = c.idleConnTimeout(); err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return t, nil
+	}
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	t.DisableKeepAlives = c.DisableKeepAlives
+	if t.TLSClientConfig, err = c.tlsConfig(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// UpstreamTLSConfig sets the TLS of one service's upstream connections, e.g.
+// for upstreams behind an internal CA or requiring client certificates. Unset
+// fields keep the server.transport settings.
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// validate loads the configured files so a bad path fails at startup
+func (c *UpstreamTLSConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	_, err := c.tlsConfig(nil)
+	return err
+}
+
+// tlsConfig applies c on top of base, the shared upstream settings
+func (c *UpstreamTLSConfig) tlsConfig(base *tls.Config) (*tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("upstream_tls: cert_file and key_file must be set together")
+	}
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("upstream_tls: ca_file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("upstream_tls: ca_file %s contains no certificates", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("upstream_tls: loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.ServerName != "" {
+		cfg.ServerName = c.ServerName
+	}
+	if c.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// newServiceTransport derives a service's own transport from the shared one.
+// It keeps the pool settings but not the pooled connections, which were
+// made with the shared TLS settings.
+func newServiceTransport(shared *http.Transport, c *UpstreamTLSConfig) (*http.Transport, error) {
+	tlsConfig, err := c.tlsConfig(shared.TLSClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	t := shared.Clone()
+	t.TLSClientConfig = tlsConfig
+	return t, nil
+}
+
+// deadlineTransport bounds each upstream round trip, including reading the
+// response body, by a fixed timeout
+type deadlineTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// upgraded connections are long lived; a deadline would tear them down
+	if isWebSocketUpgrade(req.Header) {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request context once the body has been consumed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// isWebSocketUpgrade reports whether h asks to upgrade to a WebSocket
+func isWebSocketUpgrade(h http.Header) bool {
+	return strings.EqualFold(h.Get("Upgrade"), "websocket")
+}
+
+// isTimeout reports whether err came from a deadline rather than a refused
+// or reset connection
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
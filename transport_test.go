@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUpstreamTransport(t *testing.T) {
+	tr, err := newUpstreamTransport(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.MaxIdleConns != defaultMaxIdleConns || tr.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost || tr.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Fatalf("unexpected defaults %d %d %v", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+
+	tr, err = newUpstreamTransport(&TransportConfig{MaxIdleConns: 500, MaxIdleConnsPerHost: 100, IdleConnTimeout: "30s", DisableKeepAlives: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.MaxIdleConns != 500 || tr.MaxIdleConnsPerHost != 100 || tr.IdleConnTimeout != 30*time.Second || !tr.DisableKeepAlives {
+		t.Fatalf("settings not applied: %d %d %v %v", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.DisableKeepAlives)
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		transport *TransportConfig
+		want      int
+	}{
+		"verified by default":  {nil, http.StatusBadGateway},
+		"insecure skip verify": {&TransportConfig{InsecureSkipVerify: true}, http.StatusOK},
+		"custom ca":            {&TransportConfig{CAFile: caFile}, http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := mustBuildRouter(t, &Config{
+				Server:    ServerConfig{Transport: tt.transport},
+				JWTSecret: "dummy",
+				Services:  []ServiceConfig{{Name: "internal", PathPrefix: "/api/internal", TargetURL: upstream.URL}},
+			})
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/internal/x", nil))
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidTransport(t *testing.T) {
+	tests := map[string]string{
+		"negative pool":    "max_idle_conns: -1",
+		"bad idle timeout": `idle_conn_timeout: "forever"`,
+		"missing ca file":  `ca_file: "/nonexistent/ca.pem"`,
+	}
+	for name, field := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+server:
+  transport:
+    `+field+`
+services: []
+`)
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	clientCAFile, clientCert := issueClientCert(t, "gateway")
+	clientCAs := x509.NewCertPool()
+	pemCA, _ := os.ReadFile(clientCAFile)
+	clientCAs.AppendCertsFromPEM(pemCA)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	keyDER, err := x509.MarshalECPrivateKey(clientCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, block := range map[string]*pem.Block{
+		caFile:   {Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw},
+		certFile: {Type: "CERTIFICATE", Bytes: clientCert.Certificate[0]},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		upstreamTLS *UpstreamTLSConfig
+		want        int
+	}{
+		"shared transport":     {nil, http.StatusBadGateway},
+		"no client cert":       {&UpstreamTLSConfig{CAFile: caFile}, http.StatusBadGateway},
+		"client cert":          {&UpstreamTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, http.StatusOK},
+		"server name mismatch": {&UpstreamTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "orders.internal"}, http.StatusBadGateway},
+		"server name":          {&UpstreamTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com"}, http.StatusOK},
+		"insecure skip verify": {&UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}, http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := mustBuildRouter(t, &Config{
+				JWTSecret: "dummy",
+				Services: []ServiceConfig{
+					{Name: "internal", PathPrefix: "/api/internal", TargetURL: upstream.URL, UpstreamTLS: tt.upstreamTLS},
+				},
+			})
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest("GET", "/api/internal/x", nil))
+			if rw.Code != tt.want {
+				t.Fatalf("got %d want %d", rw.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidUpstreamTLS(t *testing.T) {
+	certFile, _ := writeCert(t)
+	tests := map[string]string{
+		"missing ca file":   `{ca_file: "/nonexistent/ca.pem"}`,
+		"cert without key":  `{cert_file: "` + certFile + `"}`,
+		"missing cert file": `{cert_file: "/nonexistent/cert.pem", key_file: "/nonexistent/key.pem"}`,
+		"key mismatch":      `{cert_file: "` + certFile + `", key_file: "` + certFile + `"}`,
+	}
+	for name, upstreamTLS := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "https://orders:8443"
+    upstream_tls: `+upstreamTLS+`
+`)
+			_, err := loadConfig(path)
+			if err == nil {
+				t.Fatal("expected error for invalid upstream_tls")
+			}
+			if !strings.Contains(err.Error(), "service orders") {
+				t.Errorf("expected the error to name the service, got %v", err)
+			}
+		})
+	}
+}
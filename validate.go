@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// checkServices looks for mistakes that only show when services are looked
+// at together or that would otherwise fail late, such as a target url the
+// proxy cannot reach. Problems are fatal; warnings describe configs that
+// work but probably don't do what was meant.
+func checkServices(services []ServiceConfig) (problems []error, warnings []string) {
+	names := make(map[string]bool)
+	prefixes := make(map[string]string)
+	for i, s := range services {
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			problems = append(problems, fmt.Errorf("service %s: name must be set", name))
+		} else if names[name] {
+			problems = append(problems, fmt.Errorf("service %s: duplicate name", name))
+		}
+		names[name] = true
+
+		switch {
+		case s.PathPrefix == "":
+			problems = append(problems, fmt.Errorf("service %s: path_prefix must be set", name))
+		case !strings.HasPrefix(s.PathPrefix, "/"):
+			problems = append(problems, fmt.Errorf("service %s: path_prefix %q must start with /", name, s.PathPrefix))
+		case prefixes[s.PathPrefix] != "":
+			problems = append(problems, fmt.Errorf("service %s: path_prefix %q is already used by service %s", name, s.PathPrefix, prefixes[s.PathPrefix]))
+		default:
+			prefixes[s.PathPrefix] = name
+		}
+
+		if s.static() {
+			continue
+		}
+		if len(s.targets()) == 0 {
+			problems = append(problems, fmt.Errorf("service %s: target_url or target_urls must be set", name))
+		}
+		for _, target := range s.targets() {
+			if err := checkTargetURL(target); err != nil {
+				problems = append(problems, fmt.Errorf("service %s: %w", name, err))
+			}
+		}
+	}
+
+	// a prefix inside another one takes requests away from it
+	sorted := make([]string, 0, len(prefixes))
+	for p := range prefixes {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for _, outer := range sorted {
+		for _, inner := range sorted {
+			if inner != outer && strings.HasPrefix(inner, strings.TrimSuffix(outer, "/")+"/") {
+				warnings = append(warnings, fmt.Sprintf("path_prefix %q of service %s overlaps %q of service %s; requests under %q go to %s",
+					inner, prefixes[inner], outer, prefixes[outer], inner, prefixes[inner]))
+			}
+		}
+	}
+	return problems, warnings
+}
+
+// checkTargetURL rejects urls the proxy could not forward to
+func checkTargetURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid target url %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("target url %q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("target url %q has no host", raw)
+	}
+	return nil
+}
+
+// validateConfigFile writes a report on the config at path to out and
+// reports whether it can be started with
+func validateConfigFile(path string, out io.Writer) bool {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		fmt.Fprintf(out, "%s: invalid\n", path)
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, e := range joined.Unwrap() {
+				fmt.Fprintf(out, "  error: %v\n", e)
+			}
+		} else {
+			fmt.Fprintf(out, "  error: %v\n", err)
+		}
+		return false
+	}
+	_, warnings := checkServices(cfg.Services)
+	fmt.Fprintf(out, "%s: ok, %d services\n", path, len(cfg.Services))
+	for _, w := range warnings {
+		fmt.Fprintf(out, "  warning: %s\n", w)
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckServices(t *testing.T) {
+	valid := func(name, prefix string) ServiceConfig {
+		return ServiceConfig{Name: name, PathPrefix: prefix, TargetURL: "http://" + name + ":8080"}
+	}
+	tests := []struct {
+		name     string
+		services []ServiceConfig
+		problem  string
+	}{
+		{"valid", []ServiceConfig{valid("orders", "/api/orders"), valid("users", "/api/users")}, ""},
+		{"missing name", []ServiceConfig{{PathPrefix: "/api/x", TargetURL: "http://x:8080"}}, "service #1: name must be set"},
+		{"duplicate name", []ServiceConfig{valid("orders", "/api/orders"), valid("orders", "/api/orders2")}, "duplicate name"},
+		{"missing prefix", []ServiceConfig{valid("orders", "")}, "path_prefix must be set"},
+		{"relative prefix", []ServiceConfig{valid("orders", "api/orders")}, "must start with /"},
+		{"duplicate prefix", []ServiceConfig{valid("orders", "/api/orders"), valid("orders2", "/api/orders")}, "already used by service orders"},
+		{"missing target", []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders"}}, "target_url or target_urls must be set"},
+		{"unparsable target", []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://a b:80/%zz"}}, "invalid target url"},
+		{"target without scheme", []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURL: "orders:8080"}}, "must use http or https"},
+		{"target without host", []ServiceConfig{{Name: "orders", PathPrefix: "/api/orders", TargetURLs: []string{"http://a:8080", "http:///x"}}}, "has no host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, _ := checkServices(tt.services)
+			if tt.problem == "" {
+				if len(problems) > 0 {
+					t.Fatalf("unexpected problems %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0].Error(), tt.problem) {
+				t.Fatalf("expected one problem containing %q, got %v", tt.problem, problems)
+			}
+		})
+	}
+}
+
+func TestCheckServicesOverlap(t *testing.T) {
+	services := []ServiceConfig{
+		{Name: "api", PathPrefix: "/api", TargetURL: "http://api:8080"},
+		{Name: "orders", PathPrefix: "/api/orders", TargetURL: "http://orders:8080"},
+		{Name: "ordersv2", PathPrefix: "/api/orders-v2", TargetURL: "http://orders:8080"},
+	}
+	problems, warnings := checkServices(services)
+	if len(problems) > 0 {
+		t.Fatalf("overlaps should not be fatal, got %v", problems)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], `"/api/orders" of service orders overlaps "/api"`) {
+		t.Fatalf("unexpected warnings %q", warnings)
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+services:
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    env_var: "TEST_VALIDATE_ORDERS_URL"
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "orders:8080"
+    env_var: "TEST_VALIDATE_ORDERS_URL"
+`)
+	var out bytes.Buffer
+	if validateConfigFile(path, &out) {
+		t.Fatal("expected invalid config")
+	}
+	report := out.String()
+	for _, want := range []string{"invalid", "duplicate name", "already used", "must use http or https"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+
+	path = writeConfig(t, `
+services:
+  - name: "api"
+    path_prefix: "/api"
+    target_url: "http://api:8080"
+    env_var: "TEST_VALIDATE_API_URL"
+  - name: "orders"
+    path_prefix: "/api/orders"
+    target_url: "http://orders:8080"
+    env_var: "TEST_VALIDATE_ORDERS_URL"
+`)
+	out.Reset()
+	if !validateConfigFile(path, &out) {
+		t.Fatalf("expected valid config:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "ok, 2 services") || !strings.Contains(out.String(), "warning:") {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the tests speak just enough of RFC 6455 to exchange short text frames
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// writeFrame writes a final text frame, masked as clients must
+func writeFrame(w io.Writer, payload string, masked bool) error {
+	frame := []byte{0x81, byte(len(payload))}
+	data := []byte(payload)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame[1] |= 0x80
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+func readFrame(r io.Reader) (string, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return "", err
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return "", err
+		}
+	}
+	data := make([]byte, head[1]&0x7f)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	return string(data), nil
+}
+
+// echoWebSocket upgrades the connection and echoes every frame back
+func echoWebSocket(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r.Header) {
+			w.Write([]byte("plain http"))
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + wsAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		rw.Flush()
+		for {
+			msg, err := readFrame(rw)
+			if err != nil {
+				return
+			}
+			writeFrame(conn, msg, false)
+		}
+	})
+}
+
+// dialWebSocket performs the opening handshake against addr and returns the
+// connection along with the handshake response
+func dialWebSocket(t *testing.T, addr, path string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols && resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		t.Fatalf("unexpected accept header %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return conn, br, resp
+}
+
+func TestWebSocketProxy(t *testing.T) {
+	upstream := httptest.NewServer(echoWebSocket(t))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services: []ServiceConfig{
+			{Name: "live", PathPrefix: "/api/live", TargetURL: upstream.URL, WebSocket: true, Timeout: "50ms"},
+		},
+	}
+	gw := httptest.NewServer(mustBuildRouter(t, cfg))
+	defer gw.Close()
+
+	conn, br, resp := dialWebSocket(t, strings.TrimPrefix(gw.URL, "http://"), "/api/live/socket")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected handshake status %d", resp.StatusCode)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		if err := writeFrame(conn, msg, true); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readFrame(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != msg {
+			t.Fatalf("echo: got %q want %q", got, msg)
+		}
+		// outlive the service timeout to show it does not apply to the socket
+		time.Sleep(60 * time.Millisecond)
+	}
+}
+
+func TestWebSocketDisabled(t *testing.T) {
+	upstream := httptest.NewServer(echoWebSocket(t))
+	defer upstream.Close()
+
+	cfg := &Config{
+		JWTSecret: "dummy",
+		Services:  []ServiceConfig{{Name: "rest", PathPrefix: "/api/rest", TargetURL: upstream.URL}},
+	}
+	gw := httptest.NewServer(mustBuildRouter(t, cfg))
+	defer gw.Close()
+
+	_, _, resp := dialWebSocket(t, strings.TrimPrefix(gw.URL, "http://"), "/api/rest/socket")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a plain response from a non-websocket service, got %d", resp.StatusCode)
+	}
+}